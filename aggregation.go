@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/alissonsales/esexport/client"
+	"github.com/alissonsales/esexport/cursor"
+	"github.com/alissonsales/esexport/features"
+	"github.com/alissonsales/esexport/transform"
+)
+
+func init() {
+	features.Register("strategy", "composite-aggregation", "Exports the buckets of a composite aggregation, one per line (-mode agg)")
+}
+
+// Export modes selected with -mode
+const (
+	modeScroll      = "scroll"
+	modeAggregation = "agg"
+)
+
+// checkAggregationMode returns an error when -mode agg is combined with flags
+// working on documents, which it doesn't export
+func checkAggregationMode(opts *cmdOpts, transforms transform.Pipeline) error {
+	if opts.aggQueryFile == "" {
+		return errors.New("-mode agg requires -aggQueryFile")
+	}
+
+	if len(transforms) > 0 || opts.transformCmd != "" || opts.partitionBy != "" || opts.idsOnly || opts.deadLetter != "" || opts.follow || opts.maxDocs > 0 || opts.sample > 0 {
+		return errors.New("-mode agg can't be combined with transformations, -partitionBy, -idsOnly, -deadLetter, -follow, -maxDocs or -sample")
+	}
+
+	if opts.manifest != "" || opts.expectMinDocs > 0 || opts.expectMaxDocs > 0 || len(opts.requireFields) > 0 {
+		return errors.New("-mode agg can't be combined with -manifest or quality checks")
+	}
+
+	return nil
+}
+
+// exportAggregation writes every bucket of the composite aggregation of
+// -aggQueryFile to the output, one JSON per line, returning the exit status
+func exportAggregation(ctx context.Context, opts *cmdOpts, esClient *client.Client, rep *reporter, bufferSize int) int {
+	content, err := ioutil.ReadFile(opts.aggQueryFile)
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error reading -aggQueryFile:", err)
+		return 1
+	}
+
+	rendered, err := renderQuery(string(content), opts.params)
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error parsing %v: %v\n", opts.aggQueryFile, err)
+		return 1
+	}
+
+	var query map[string]interface{}
+
+	if err := json.Unmarshal([]byte(rendered), &query); err != nil {
+		fmt.Fprintf(os.Stderr, "Error parsing %v: %v\n", opts.aggQueryFile, err)
+		return 1
+	}
+
+	cac, err := cursor.NewCompositeAggregationCursor(esClient, query)
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error parsing %v: %v\n", opts.aggQueryFile, err)
+		return 1
+	}
+
+	out, err := openOutput(opts, bufferSize)
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error creating output file:", err)
+		return 1
+	}
+
+	defer out.lock.release()
+	err = writeBuckets(ctx, cac, out)
+
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+
+	if err == nil && ctx.Err() == nil {
+		err = out.commit(opts.verifyOutput)
+	}
+
+	if out.tmpPath != "" {
+		fmt.Fprintln(os.Stderr, "The incomplete output is left in", out.tmpPath)
+	}
+
+	summary := summaryData{Output: opts.output, Docs: cac.NumBuckets, Total: cac.NumBuckets, Interrupted: ctx.Err() != nil}
+
+	if !out.isStdout() {
+		summary.Checksums = out.checksums()
+	}
+
+	rep.printSummary(summary)
+
+	switch {
+	case ctx.Err() != nil:
+		fmt.Fprintln(os.Stderr, "Export interrupted, the output is incomplete")
+		return 130
+	case err != nil:
+		fmt.Fprintln(os.Stderr, "Error exporting aggregation:", err)
+		return 1
+	}
+
+	return 0
+}
+
+func writeBuckets(ctx context.Context, cac *cursor.CompositeAggregationCursor, out *output) error {
+	for ctx.Err() == nil {
+		buckets, err := cac.Next()
+
+		if err != nil {
+			return err
+		}
+
+		if len(buckets) == 0 {
+			return nil
+		}
+
+		for _, bucket := range buckets {
+			line, err := json.Marshal(bucket)
+
+			if err != nil {
+				return err
+			}
+
+			if _, err := out.write(ctx, append(line, '\n')); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
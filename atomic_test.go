@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeAtomic writes content to path with -atomic and closes it, without
+// committing it
+func writeAtomic(t *testing.T, path, content string) *output {
+	o, err := openOutput(&cmdOpts{output: path, atomic: true, verifyOutput: true, force: true}, 0)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := o.write(context.Background(), []byte(content)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := o.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return o
+}
+
+func expectContent(t *testing.T, path, expected string) {
+	t.Helper()
+	b, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(b) != expected {
+		t.Errorf("Expected %v to contain %q, got %q", path, expected, b)
+	}
+}
+
+func TestAtomicOutputIsRenamedOnCommit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.json")
+	o := writeAtomic(t, path, "{\"id\":1}\n")
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected %v not to exist before the commit, got %v", path, err)
+	}
+
+	if err := o.commit(true); err != nil {
+		t.Fatal(err)
+	}
+
+	expectContent(t, path, "{\"id\":1}\n")
+
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("Expected %v.tmp to be renamed, got %v", path, err)
+	}
+}
+
+func TestAtomicOutputKeepsPreviousOutput(t *testing.T) {
+	scenarios := []struct {
+		name string
+		// tamper changes the file written before the commit
+		tamper bool
+		commit bool
+	}{
+		{"failed export", false, false},
+		{"checksum mismatch", true, true},
+	}
+
+	for _, scenario := range scenarios {
+		path := filepath.Join(t.TempDir(), "out.json")
+
+		if err := ioutil.WriteFile(path, []byte("previous\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		o := writeAtomic(t, path, "{\"id\":1}\n")
+
+		if scenario.tamper {
+			if err := ioutil.WriteFile(path+".tmp", []byte("{\"id\":2}\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if scenario.commit {
+			if err := o.commit(true); err == nil {
+				t.Errorf("Expected the commit to fail (%v)", scenario.name)
+			}
+		}
+
+		expectContent(t, path, "previous\n")
+
+		if _, err := os.Stat(path + ".tmp"); err != nil {
+			t.Errorf("Expected the incomplete output to be left in %v.tmp (%v), got %v", path, scenario.name, err)
+		}
+
+		o.lock.release()
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/alissonsales/esexport/features"
+	yaml "gopkg.in/yaml.v3"
+)
+
+func init() {
+	commands["batch"] = batch
+	features.Register("command", "batch", "Runs the exports described by a jobs file (esexport batch -jobs jobs.yaml)")
+}
+
+// jobsFile describes several exports. Jobs hold flag values like the config
+// file does, on top of the values in defaults shared by every job.
+type jobsFile struct {
+	Defaults map[string]interface{}   `yaml:"defaults"`
+	Jobs     []map[string]interface{} `yaml:"jobs"`
+}
+
+// job is an export of a batch
+type job struct {
+	name string
+	opts *cmdOpts
+}
+
+func loadJobs(path string) ([]job, error) {
+	content, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var f jobsFile
+
+	if err := yaml.Unmarshal(content, &f); err != nil {
+		return nil, fmt.Errorf("Error parsing jobs file %v: %v", path, err)
+	}
+
+	if len(f.Jobs) == 0 {
+		return nil, fmt.Errorf("No jobs found in %v", path)
+	}
+
+	var jobs []job
+	outputs := map[string]string{}
+
+	for i, values := range f.Jobs {
+		name := fmt.Sprint(i + 1)
+		merged := map[string]interface{}{}
+
+		for k, v := range f.Defaults {
+			merged[k] = v
+		}
+
+		for k, v := range values {
+			if k == "name" {
+				name = fmt.Sprint(v)
+				continue
+			}
+
+			merged[k] = v
+		}
+
+		// The debug level and GC settings are process-wide, they can't change
+		// between jobs run at once
+		for _, k := range []string{"v", "vv", "quiet", "memoryProfile"} {
+			if _, ok := merged[k]; ok {
+				return nil, fmt.Errorf("Job %v: %v applies to every job, pass -%v to esexport batch", name, k, k)
+			}
+		}
+
+		opts, fs := newCmdOpts("esexport batch", flag.ContinueOnError)
+
+		if err := (&configFile{values: merged}).apply(fs, ""); err != nil {
+			return nil, fmt.Errorf("Job %v: %v", name, err)
+		}
+
+		if opts.output != "" && opts.output != "-" {
+			if other, ok := outputs[opts.output]; ok {
+				return nil, fmt.Errorf("Jobs %v and %v both write to %v", other, name, opts.output)
+			}
+
+			outputs[opts.output] = name
+		}
+
+		jobs = append(jobs, job{name, opts})
+	}
+
+	return jobs, nil
+}
+
+// batch runs the exports of a jobs file, at most -parallel at once. It fails
+// when any of them does, after running the others.
+func batch(args []string) int {
+	fs := flag.NewFlagSet("esexport batch", flag.ExitOnError)
+	jobsPath := fs.String("jobs", "", "YAML file describing the exports to run (flag values under jobs, shared ones under defaults)")
+	parallel := fs.Int("parallel", 1, "Number of exports run at once")
+	verbose := fs.Bool("v", false, "Print debug information of every job")
+	veryVerbose := fs.Bool("vv", false, "Print every request sent to ES by every job as well")
+	quiet := fs.Bool("quiet", false, "Print errors only, without the progress and summary of the jobs")
+	memoryProfileName := fs.String("memoryProfile", "balanced", "Memory usage preset of every job (GC, buffers and prefetching): low, balanced or throughput")
+	fs.Parse(args)
+
+	if *jobsPath == "" || *parallel < 1 {
+		fmt.Fprintln(os.Stderr, "Error parsing options: -jobs is required and -parallel must be at least 1")
+		return 1
+	}
+
+	if *quiet && (*verbose || *veryVerbose) {
+		fmt.Fprintln(os.Stderr, "Error parsing options: -quiet can't be used with -v or -vv")
+		return 1
+	}
+
+	jobs, err := loadJobs(*jobsPath)
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error loading jobs:", err)
+		return 1
+	}
+
+	memProfile, err := lookupMemoryProfile(*memoryProfileName)
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error parsing options:", err)
+		return 1
+	}
+
+	memProfile.applyGCSettings()
+
+	for i := range jobs {
+		o := jobs[i].opts
+		o.memoryProfile, o.verbose, o.veryVerbose, o.quiet = *memoryProfileName, *verbose, *veryVerbose, *quiet
+	}
+
+	setDebugLevel(&cmdOpts{verbose: *verbose, veryVerbose: *veryVerbose, quiet: *quiet})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handleInterrupt(cancel)
+
+	statuses := make([]int, len(jobs))
+	running := make(chan struct{}, *parallel)
+	var wg sync.WaitGroup
+
+	for i := range jobs {
+		select {
+		case running <- struct{}{}:
+		case <-ctx.Done():
+		}
+
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-running }()
+
+			fmt.Fprintf(os.Stderr, "Job %v: starting\n", jobs[i].name)
+			statuses[i] = runExport(ctx, jobs[i].opts)
+			fmt.Fprintf(os.Stderr, "Job %v: exited with status %v\n", jobs[i].name, statuses[i])
+		}(i)
+	}
+
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		fmt.Fprintln(os.Stderr, "Batch interrupted")
+		return 130
+	}
+
+	failed := 0
+
+	for i, status := range statuses {
+		if status != 0 {
+			fmt.Fprintf(os.Stderr, "Job %v failed\n", jobs[i].name)
+			failed++
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "%v of %v jobs completed\n", len(jobs)-failed, len(jobs))
+
+	if failed > 0 {
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/alissonsales/esexport/client"
+	"github.com/alissonsales/esexport/cursor"
+)
+
+// circuitBreaker pauses the requests of every slice once -breakerFailures
+// requests failed in a row with errors of the cluster, polling its health
+// until it recovers, so an outage doesn't fail the slices one by one and lose
+// their scrolls
+type circuitBreaker struct {
+	ctx       context.Context
+	health    func() (*client.ClusterHealth, error)
+	threshold int
+	interval  time.Duration
+	// ttl is how long ES keeps the scrolls, the export fails once the
+	// breaker stays open longer
+	ttl time.Duration
+
+	mu       sync.Mutex
+	failures int
+	// closed is closed when the open breaker closes again, nil while closed
+	closed chan struct{}
+	err    error
+}
+
+func newCircuitBreaker(ctx context.Context, esClient *client.Client, threshold int, interval, ttl time.Duration) *circuitBreaker {
+	return &circuitBreaker{ctx: ctx, health: esClient.Health, threshold: threshold, interval: interval, ttl: ttl}
+}
+
+// isClusterError returns whether the request failed without reaching ES or
+// was turned down by the cluster (429 or 5xx), so sending it again doesn't
+// skip a page of the scroll
+func isClusterError(err error) bool {
+	var statusErr *client.HTTPStatusError
+
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode == 429 || statusErr.StatusCode >= 500
+	}
+
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// wait blocks while the breaker is open, returning why the export can't go
+// on if the cluster didn't recover
+func (b *circuitBreaker) wait() error {
+	b.mu.Lock()
+	closed, err := b.closed, b.err
+	b.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	if closed == nil {
+		return nil
+	}
+
+	select {
+	case <-closed:
+	case <-b.ctx.Done():
+		return b.ctx.Err()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.err
+}
+
+// succeeded resets the failures in a row
+func (b *circuitBreaker) succeeded() {
+	b.mu.Lock()
+	b.failures = 0
+	b.mu.Unlock()
+}
+
+// failed counts the failed request, opening the breaker once there are
+// enough in a row
+func (b *circuitBreaker) failed(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures++; b.failures < b.threshold || b.closed != nil || b.err != nil {
+		return
+	}
+
+	b.closed = make(chan struct{})
+	fmt.Fprintf(os.Stderr, "\n%v requests failed in a row (%v), pausing the export until the cluster recovers\n", b.failures, err)
+
+	go b.poll(b.closed)
+}
+
+// poll closes the breaker once the health of the index is yellow or green,
+// failing the export when it takes longer than the scrolls are kept
+func (b *circuitBreaker) poll(closed chan struct{}) {
+	start := time.Now()
+	var err error
+
+	for {
+		select {
+		case <-time.After(b.interval):
+		case <-b.ctx.Done():
+			return
+		}
+
+		health, healthErr := b.health()
+
+		if healthErr == nil && health.Status != "red" {
+			fmt.Fprintf(os.Stderr, "\nCluster health is %v after %v, resuming the export\n", health.Status, time.Since(start).Round(time.Second))
+			break
+		}
+
+		if b.ttl > 0 && time.Since(start) > b.ttl {
+			if healthErr == nil {
+				healthErr = fmt.Errorf("cluster health is %v", health.Status)
+			}
+
+			err = fmt.Errorf("The cluster didn't recover within -searchContextTTL (%v), the scrolls are lost: %v", b.ttl, healthErr)
+			break
+		}
+	}
+
+	b.mu.Lock()
+	b.failures, b.closed, b.err = 0, nil, err
+	b.mu.Unlock()
+
+	close(closed)
+}
+
+// breakerClient sends the requests of a slice through the breaker, sending
+// those failing with cluster errors again once it closes (up to
+// -breakerFailures more times)
+type breakerClient struct {
+	next    cursor.ElasticsearchClient
+	breaker *circuitBreaker
+}
+
+func (c *breakerClient) Search(searchBody map[string]interface{}) (*client.ESSearchResponse, error) {
+	return c.send(func() (*client.ESSearchResponse, error) { return c.next.Search(searchBody) })
+}
+
+func (c *breakerClient) Scroll(scrollID string) (*client.ESSearchResponse, error) {
+	return c.send(func() (*client.ESSearchResponse, error) { return c.next.Scroll(scrollID) })
+}
+
+// SearchEach and ScrollEach are sent again like Search and Scroll: cluster
+// errors fail the requests before any hit is handed
+func (c *breakerClient) SearchEach(searchBody map[string]interface{}, hit func(*client.Hit) error) (*client.ESSearchResponse, error) {
+	return c.send(func() (*client.ESSearchResponse, error) { return cursor.SearchEach(c.next, searchBody, hit) })
+}
+
+func (c *breakerClient) ScrollEach(scrollID string, hit func(*client.Hit) error) (*client.ESSearchResponse, error) {
+	return c.send(func() (*client.ESSearchResponse, error) { return cursor.ScrollEach(c.next, scrollID, hit) })
+}
+
+func (c *breakerClient) send(request func() (*client.ESSearchResponse, error)) (*client.ESSearchResponse, error) {
+	for attempt := 0; ; attempt++ {
+		if err := c.breaker.wait(); err != nil {
+			return nil, err
+		}
+
+		resp, err := request()
+
+		if err == nil {
+			c.breaker.succeeded()
+			return resp, nil
+		}
+
+		if !isClusterError(err) || attempt >= c.breaker.threshold {
+			return nil, err
+		}
+
+		c.breaker.failed(err)
+
+		// Waits a bit before trying again while the breaker is closed
+		select {
+		case <-time.After(c.breaker.interval / 10):
+		case <-c.breaker.ctx.Done():
+			return nil, err
+		}
+	}
+}
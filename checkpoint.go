@@ -0,0 +1,34 @@
+package main
+
+// outputCheckpoint is what the destination of the export holds once it
+// stopped, recorded in the manifest. Resuming a slice (-resumeScroll) from
+// its scroll id only makes sense when the documents written before are at
+// the destination.
+type outputCheckpoint struct {
+	// Committed tells whether the documents written are at the destination.
+	// Local files and the batches of http(s):// and bigquery:// outputs are
+	// as they're written, gs://, azblob:// and sftp:// uploads and the
+	// transactions of postgres:// and sqlite:// once the export completed.
+	Committed bool `json:"committed"`
+	// Object is the object, file or table the documents were committed to
+	Object string `json:"object,omitempty"`
+	// Batches is the number of batches committed (requests posted to
+	// http(s):// outputs, load jobs of bigquery://) and Docs their documents
+	Batches int `json:"batches,omitempty"`
+	Docs    int `json:"docs,omitempty"`
+}
+
+// checkpointer is implemented by the outputs telling what they committed.
+// Those which don't are local files, committed as they're written.
+type checkpointer interface {
+	checkpoint() outputCheckpoint
+}
+
+// checkpointOf returns the checkpoint of the output
+func checkpointOf(out interface{}) outputCheckpoint {
+	if c, ok := out.(checkpointer); ok {
+		return c.checkpoint()
+	}
+
+	return outputCheckpoint{Committed: true}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHTTPOutputCheckpoint(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	h := &httpOutput{client: server.Client(), url: server.URL, header: http.Header{}, batchSize: 2}
+	h.Write([]byte("{\"_id\":\"1\"}\n{\"_id\":\"2\"}\n{\"_id\":\"3\"}\n"))
+
+	if c := h.checkpoint(); c.Committed || c.Batches != 1 || c.Docs != 2 {
+		t.Errorf("Expected the first batch only to be committed, got %+v", c)
+	}
+
+	if err := h.Close(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if c := h.checkpoint(); !c.Committed || c.Batches != 2 || c.Docs != 3 {
+		t.Errorf("Expected every document to be committed once closed, got %+v", c)
+	}
+}
+
+func TestManifestCheckpoint(t *testing.T) {
+	scenarios := []struct {
+		checkpoint outputCheckpoint
+		scrollID   string
+	}{
+		{outputCheckpoint{Committed: true}, "scroll"},
+		{outputCheckpoint{Committed: false}, ""},
+	}
+
+	for _, scenario := range scenarios {
+		m := &manifest{Slices: []sliceManifest{{ID: 0, Completed: true}, {ID: 1, ScrollID: "scroll"}}}
+		m.setCheckpoint(scenario.checkpoint)
+
+		if m.Checkpoint == nil || *m.Checkpoint != scenario.checkpoint {
+			t.Errorf("Expected the checkpoint to be %+v, got %+v", scenario.checkpoint, m.Checkpoint)
+		}
+
+		if m.Slices[1].ScrollID != scenario.scrollID {
+			t.Errorf("Expected the scroll id with %+v to be '%v', got '%v'", scenario.checkpoint, scenario.scrollID, m.Slices[1].ScrollID)
+		}
+	}
+
+	if c := checkpointOf(&output{dest: nopCloser{}}); !c.Committed || c.Object != "" {
+		t.Errorf("Expected local outputs to be committed as they're written, got %+v", c)
+	}
+}
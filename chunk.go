@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/alissonsales/esexport/client"
+	"github.com/alissonsales/esexport/features"
+)
+
+func init() {
+	features.Register("strategy", "time-chunks", "Exports ranges of a date field as separate scrolls processed by a pool of workers, instead of slices (-chunkByField)")
+	features.Register("strategy", "ids-file", "Exports the documents of a file of ids, in batches processed by a pool of workers (-idsFile)")
+}
+
+// chunk is part of the documents of the query exported by a scroll of its
+// own, those matching the filter. Chunks take the place of slices.
+type chunk struct {
+	filter interface{}
+	// name tells which documents the chunk holds, the manifest lists it to
+	// export a failed chunk again
+	name string
+}
+
+// checkChunks returns an error when -chunkByField or -idsFile can't be used
+// with the other options
+func checkChunks(opts *cmdOpts, routed bool) error {
+	if opts.chunkField != "" && opts.idsFile != "" {
+		return errors.New("-chunkByField and -idsFile can't be combined")
+	}
+
+	if opts.chunkField != "" && opts.chunkInterval <= 0 {
+		return errors.New("-chunkInterval must be positive")
+	}
+
+	if opts.idsFile != "" && opts.idsBatchSize < 1 {
+		return errors.New("-idsBatchSize must be at least 1")
+	}
+
+	if routed || opts.stealWork || opts.follow {
+		return errors.New("-chunkByField and -idsFile can't be combined with -routing, -stealWork or -follow")
+	}
+
+	return nil
+}
+
+// timeChunks splits the documents of the query in chunks of -chunkInterval
+// of the -chunkByField values, aligned on the interval, from the lowest
+// value to the highest. A last chunk holds the documents without the field.
+func timeChunks(esClient *client.Client, opts *cmdOpts, query map[string]interface{}) ([]chunk, error) {
+	field := opts.chunkField
+	aggs := map[string]interface{}{
+		"min":     map[string]interface{}{"min": map[string]interface{}{"field": field}},
+		"max":     map[string]interface{}{"max": map[string]interface{}{"field": field}},
+		"missing": map[string]interface{}{"missing": map[string]interface{}{"field": field}},
+	}
+	body := map[string]interface{}{"size": 0, "aggs": aggs}
+
+	if q, ok := query["query"]; ok {
+		body["query"] = q
+	}
+
+	resp, err := esClient.Aggregate(body)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read the range of %v: %v", field, err)
+	}
+
+	var min, max struct {
+		Value *float64 `json:"value"`
+	}
+	var missing struct {
+		DocCount int64 `json:"doc_count"`
+	}
+
+	for name, v := range map[string]interface{}{"min": &min, "max": &max, "missing": &missing} {
+		if err := json.Unmarshal(resp.Aggregations[name], v); err != nil {
+			return nil, fmt.Errorf("Failed to read the range of %v: %v", field, err)
+		}
+	}
+
+	var chunks []chunk
+
+	// Without a value the query has no document with the field
+	if min.Value != nil && max.Value != nil {
+		from := time.Unix(0, int64(*min.Value)*int64(time.Millisecond)).UTC().Truncate(opts.chunkInterval)
+		last := time.Unix(0, int64(*max.Value)*int64(time.Millisecond)).UTC()
+
+		for t := from; !t.After(last); t = t.Add(opts.chunkInterval) {
+			to := t.Add(opts.chunkInterval)
+			filter := map[string]interface{}{"range": map[string]interface{}{field: map[string]interface{}{
+				"gte":    t.UnixNano() / int64(time.Millisecond),
+				"lt":     to.UnixNano() / int64(time.Millisecond),
+				"format": "epoch_millis",
+			}}}
+
+			// ISO 8601 intervals
+			chunks = append(chunks, chunk{filter, t.Format(time.RFC3339) + "/" + to.Format(time.RFC3339)})
+		}
+	}
+
+	if missing.DocCount > 0 || len(chunks) == 0 {
+		filter := map[string]interface{}{"bool": map[string]interface{}{"must_not": map[string]interface{}{"exists": map[string]interface{}{"field": field}}}}
+		chunks = append(chunks, chunk{filter, "missing"})
+	}
+
+	return chunks, nil
+}
+
+// idsChunks splits the ids of -idsFile, one per line, in chunks of
+// -idsBatchSize ids, named after the lines they come from. It returns the
+// number of ids read as well.
+func idsChunks(opts *cmdOpts) ([]chunk, int, error) {
+	f, err := os.Open(opts.idsFile)
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	defer f.Close()
+
+	var chunks []chunk
+	var batch []interface{}
+	first, line, ids := 0, 0, 0
+	seen := map[string]bool{}
+
+	add := func() {
+		filter := map[string]interface{}{"ids": map[string]interface{}{"values": batch}}
+		chunks = append(chunks, chunk{filter, fmt.Sprintf("ids:%v-%v", first, line)})
+		batch = nil
+	}
+
+	scanner := bufio.NewScanner(f)
+
+	for scanner.Scan() {
+		line++
+		id := strings.TrimSpace(scanner.Text())
+
+		// Ids listed twice would be exported twice by different chunks
+		if id == "" || seen[id] {
+			continue
+		}
+
+		if len(batch) == 0 {
+			first = line
+		}
+
+		seen[id] = true
+		batch = append(batch, id)
+		ids++
+
+		if len(batch) == opts.idsBatchSize {
+			add()
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, 0, fmt.Errorf("Failed to read %v: %v", opts.idsFile, err)
+	}
+
+	if len(batch) > 0 {
+		add()
+	}
+
+	if ids == 0 {
+		return nil, 0, fmt.Errorf("No ids found in %v", opts.idsFile)
+	}
+
+	return chunks, ids, nil
+}
@@ -4,17 +4,118 @@ package client
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/alissonsales/esexport/debug"
 )
 
 var debugCursors bool
 
+// ErrScrollExpired is returned when ES no longer has the search context of a
+// scroll, because more than the search context TTL passed between requests
+var ErrScrollExpired = errors.New("Scroll expired (search context not found)")
+
+// ErrResponseTooLarge is returned when a response body exceeds the limit set
+// by WithMaxResponseBytes
+var ErrResponseTooLarge = errors.New("Response exceeds the maximum size")
+
+// ErrUnauthorized matches the errors of requests ES rejected for missing or
+// insufficient credentials (401 and 403)
+var ErrUnauthorized = errors.New("Unauthorized")
+
+// ErrShardFailure is returned when a search or scroll response doesn't
+// include the documents of every shard, which would make the export silently
+// incomplete
+var ErrShardFailure = errors.New("Response incomplete")
+
+// HTTPStatusError is returned when ES responds with an unexpected status,
+// with the type and reason of the ES error when the body has one. Besides
+// errors.As, it matches ErrUnauthorized and ErrScrollExpired with errors.Is.
+type HTTPStatusError struct {
+	StatusCode int
+	Type       string
+	Reason     string
+	Body       []byte
+}
+
+// maxErrorBodyExcerpt is how much of a body without ES error (e.g. the HTML
+// page of a proxy) makes it into the error message
+const maxErrorBodyExcerpt = 200
+
+func newHTTPStatusError(statusCode int, body []byte) *HTTPStatusError {
+	err := &HTTPStatusError{StatusCode: statusCode, Body: body}
+
+	var esError struct {
+		Error json.RawMessage `json:"error"`
+	}
+
+	if json.Unmarshal(body, &esError) != nil || len(esError.Error) == 0 {
+		return err
+	}
+
+	// ES errors are objects, some older versions and plugins send a string
+	var cause struct {
+		Type   string `json:"type"`
+		Reason string `json:"reason"`
+	}
+
+	if json.Unmarshal(esError.Error, &cause) == nil {
+		err.Type, err.Reason = cause.Type, cause.Reason
+	} else {
+		json.Unmarshal(esError.Error, &err.Reason)
+	}
+
+	return err
+}
+
+func (e *HTTPStatusError) Error() string {
+	if e.Is(ErrScrollExpired) {
+		return ErrScrollExpired.Error()
+	}
+
+	msg := fmt.Sprintf("Unexpected response received: %v", e.StatusCode)
+
+	switch {
+	case e.Type != "" && e.Reason != "":
+		return fmt.Sprintf("%v (%v: %v)", msg, e.Type, e.Reason)
+	case e.Type != "" || e.Reason != "":
+		return fmt.Sprintf("%v (%v%v)", msg, e.Type, e.Reason)
+	case len(e.Body) > 0 && !json.Valid(e.Body):
+		excerpt := strings.TrimSpace(string(e.Body))
+
+		if len(excerpt) > maxErrorBodyExcerpt {
+			excerpt = excerpt[:maxErrorBodyExcerpt] + "..."
+		}
+
+		return fmt.Sprintf("%v (%v)", msg, excerpt)
+	}
+
+	return msg
+}
+
+// Is reports whether the error is one of the sentinels its status stands for
+func (e *HTTPStatusError) Is(target error) bool {
+	switch target {
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+	case ErrScrollExpired:
+		return e.StatusCode == http.StatusNotFound && bytes.Contains(e.Body, []byte("search_context_missing_exception"))
+	}
+
+	return false
+}
+
 func init() {
 	debug.Init("ESEXPORTDEBUG")
 }
@@ -22,22 +123,128 @@ func init() {
 // A HTTPClient is required to send HTTP requests to Elasticsearch
 type HTTPClient interface {
 	Post(string, string, io.Reader) (*http.Response, error)
+	Do(*http.Request) (*http.Response, error)
 }
 
 // Client implements methods to use search and scroll documents from Elasticsearch
 type Client struct {
+	// partialResponses is accessed atomically, first to be 64-bit aligned
+	partialResponses int64
 	client           HTTPClient
 	host             string
 	index            string
 	docType          string
 	routing          string
 	searchContextTTL string
+	maxResponseBytes int64
+	maxFailedShards  int
+	filterPath       string
+	hooks            Hooks
+	searchMethod     string
+	methodOverride   string
+}
+
+// An Option changes the default settings of a Client
+type Option func(*Client)
+
+// WithMaxResponseBytes makes requests fail with ErrResponseTooLarge when the
+// (decompressed) response body is larger than n bytes, instead of holding it
+// in memory. Zero means no limit.
+func WithMaxResponseBytes(n int64) Option {
+	return func(c *Client) {
+		c.maxResponseBytes = n
+	}
+}
+
+// WithMaxFailedShards makes search and scroll responses missing the documents
+// of up to n shards succeed with the documents of the other shards instead of
+// failing with ErrShardFailure. They are counted by PartialResponses. A
+// negative n accepts any number of failed shards.
+func WithMaxFailedShards(n int) Option {
+	return func(c *Client) {
+		c.maxFailedShards = n
+	}
+}
+
+// WithFilterPath makes ES leave out of search and scroll responses everything
+// but the scroll id, took, the shards, the total and the given fields of every hit
+// (e.g. _id and _source), shrinking the responses of hits with a lot of
+// metadata (_index, _score, sort...). ES filters error responses too, the error
+// and status are kept for HTTPStatusError.
+func WithFilterPath(hitFields ...string) Option {
+	return func(c *Client) {
+		paths := []string{"_scroll_id", "took", "_shards", "hits.total", "error", "status"}
+
+		for _, field := range hitFields {
+			paths = append(paths, "hits.hits."+field)
+		}
+
+		c.filterPath = strings.Join(paths, ",")
+	}
 }
 
-// Hit represents a returned document from Elasticsearch
+// Hooks are called around every request sent to ES, letting embedders record
+// latencies or trace requests. Either may be nil. The client doesn't retry
+// requests, a failed one is reported once to OnResponse.
+type Hooks struct {
+	// OnRequest is called before sending the request
+	OnRequest func(req RequestInfo)
+	// OnResponse is called once the response headers were received, or the
+	// request failed
+	OnResponse func(req RequestInfo, resp ResponseInfo)
+}
+
+// RequestInfo describes a request sent to ES
+type RequestInfo struct {
+	// Name is the operation: search, scroll, aggregate, stats, settings or count
+	Name   string
+	Method string
+	URL    string
+}
+
+// ResponseInfo describes how a request sent to ES ended
+type ResponseInfo struct {
+	// StatusCode is 0 when no response was received
+	StatusCode int
+	// Duration is the time until the response headers were received
+	Duration time.Duration
+	// Err is the error of the request, statuses other than 200 aren't
+	Err error
+}
+
+// WithSearchMethod sends the search and scroll requests with the given HTTP
+// method instead of POST (GET sending the body all the same), and with the
+// X-HTTP-Method-Override header set to override if not empty, for gateways
+// restricting the methods of the search endpoints
+func WithSearchMethod(method, override string) Option {
+	return func(c *Client) {
+		c.searchMethod, c.methodOverride = method, override
+	}
+}
+
+// WithHooks calls the given hooks around every request
+func WithHooks(hooks Hooks) Option {
+	return func(c *Client) {
+		c.hooks = hooks
+	}
+}
+
+// Hit represents a returned document from Elasticsearch. Fields holds the
+// docvalue and stored fields requested by the search, if any, and Sort the
+// sort values of the hit when the search is sorted. Version, SeqNo and
+// PrimaryTerm are only returned when the search asks for them.
 type Hit struct {
-	ID     string                 `json:"_id"`
-	Source map[string]interface{} `json:"_source,omitempty"`
+	Index       string                 `json:"_index,omitempty"`
+	Type        string                 `json:"_type,omitempty"`
+	ID          string                 `json:"_id"`
+	Version     *int64                 `json:"_version,omitempty"`
+	SeqNo       *int64                 `json:"_seq_no,omitempty"`
+	PrimaryTerm *int64                 `json:"_primary_term,omitempty"`
+	Score       *float64               `json:"_score,omitempty"`
+	Routing     string                 `json:"_routing,omitempty"`
+	Source      map[string]interface{} `json:"_source,omitempty"`
+	Fields      map[string]interface{} `json:"fields,omitempty"`
+	Sort        []interface{}          `json:"sort,omitempty"`
 }
 
 // Hits represents the hits part of a search response
@@ -56,43 +263,115 @@ type Shards struct {
 // ESSearchResponse represents a search or scroll response from Elasticsearch
 type ESSearchResponse struct {
 	ScrollID string `json:"_scroll_id"`
-	Hits     Hits   `json:"hits"`
-	Shards   Shards `json:"_shards"`
+	// Took is the time ES reports having spent on the request, in ms
+	Took   int64  `json:"took"`
+	Hits   Hits   `json:"hits"`
+	Shards Shards `json:"_shards"`
+	// Bytes is the size of the response body read (decompressed)
+	Bytes int64 `json:"-"`
+	// Streamed is the number of hits handed one at a time by SearchEach or
+	// ScrollEach, which leave Hits.Hits empty
+	Streamed int `json:"-"`
+}
+
+// ESAggregationResponse represents the aggregations of a search response,
+// left raw to be decoded by the caller knowing their type
+type ESAggregationResponse struct {
+	Shards       Shards                     `json:"_shards"`
+	Aggregations map[string]json.RawMessage `json:"aggregations"`
+}
+
+// IndexStats represents the primaries part of an index stats response
+type IndexStats struct {
+	Docs struct {
+		Count int64 `json:"count"`
+	} `json:"docs"`
+	Store struct {
+		SizeInBytes int64 `json:"size_in_bytes"`
+	} `json:"store"`
+}
+
+// ClusterHealth represents the parts of a cluster health response used
+type ClusterHealth struct {
+	// Status is green, yellow or red
+	Status             string `json:"status"`
+	RelocatingShards   int    `json:"relocating_shards"`
+	InitializingShards int    `json:"initializing_shards"`
+	UnassignedShards   int    `json:"unassigned_shards"`
 }
 
 // NewClient returns a new Client
-func NewClient(httpClient HTTPClient, host, index, docType, routing, searchContextTTL string) (*Client, error) {
+func NewClient(httpClient HTTPClient, host, index, docType, routing, searchContextTTL string, options ...Option) (*Client, error) {
 	_, err := url.ParseRequestURI(host)
 
 	if err != nil {
 		return nil, err
 	}
 
-	return &Client{httpClient, host, index, docType, routing, searchContextTTL}, nil
+	c := &Client{client: httpClient, host: host, index: index, docType: docType, routing: routing, searchContextTTL: searchContextTTL}
+
+	for _, option := range options {
+		option(c)
+	}
+
+	return c, nil
 }
 
 // Search performs a search request using the given query
 func (c *Client) Search(searchBody map[string]interface{}) (searchResponse *ESSearchResponse, err error) {
+	return c.SearchWithRouting(searchBody, c.routing)
+}
+
+// SearchWithRouting performs a search request using the given query, routed
+// by the given routing instead of the client one
+func (c *Client) SearchWithRouting(searchBody map[string]interface{}, routing string) (searchResponse *ESSearchResponse, err error) {
+	return c.searchWithRouting(searchBody, routing, nil)
+}
+
+// SearchEach performs a search request like Search, handing every hit to hit
+// as it's decoded instead of returning them together, so the page isn't held
+// in memory. Hits.Hits of the response is left empty, Streamed counts the
+// hits handed. The error of hit stops decoding and is returned as is.
+// Responses aren't limited by WithMaxResponseBytes, not being held.
+func (c *Client) SearchEach(searchBody map[string]interface{}, hit func(*Hit) error) (*ESSearchResponse, error) {
+	return c.SearchEachWithRouting(searchBody, c.routing, hit)
+}
+
+// SearchEachWithRouting performs a search request like SearchEach, routed by
+// the given routing instead of the client one
+func (c *Client) SearchEachWithRouting(searchBody map[string]interface{}, routing string, hit func(*Hit) error) (*ESSearchResponse, error) {
+	return c.searchWithRouting(searchBody, routing, hit)
+}
+
+func (c *Client) searchWithRouting(searchBody map[string]interface{}, routing string, hit func(*Hit) error) (*ESSearchResponse, error) {
 	jsonBody, err := json.Marshal(searchBody)
 
 	if err != nil {
 		return nil, err
 	}
 
-	url := c.searchURL()
-	resp, err := c.client.Post(url, "application/json", bytes.NewReader(jsonBody))
+	url := c.searchURL(routing)
+	resp, err := c.search("search", url, jsonBody)
 
 	if err != nil {
 		return nil, err
 	}
 
-	searchResponse, err = c.searchResponse(resp)
-
-	return searchResponse, err
+	return c.searchResponse(resp, hit)
 }
 
 // Scroll performs a scroll request using the given scroll id
 func (c *Client) Scroll(scrollID string) (scrollResponse *ESSearchResponse, err error) {
+	return c.scroll(scrollID, nil)
+}
+
+// ScrollEach performs a scroll request like Scroll, handing every hit to hit
+// as it's decoded like SearchEach does
+func (c *Client) ScrollEach(scrollID string, hit func(*Hit) error) (*ESSearchResponse, error) {
+	return c.scroll(scrollID, hit)
+}
+
+func (c *Client) scroll(scrollID string, hit func(*Hit) error) (*ESSearchResponse, error) {
 	scrollBody := map[string]interface{}{"scroll": c.searchContextTTL, "scroll_id": scrollID}
 	jsonBody, err := json.Marshal(scrollBody)
 
@@ -101,79 +380,899 @@ func (c *Client) Scroll(scrollID string) (scrollResponse *ESSearchResponse, err
 	}
 
 	url := c.host + "/_search/scroll"
-	resp, err := c.client.Post(url, "application/json", bytes.NewReader(jsonBody))
+
+	if c.filterPath != "" {
+		url += "?" + c.filterParams().Encode()
+	}
+
+	resp, err := c.search("scroll", url, jsonBody)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return c.searchResponse(resp, hit)
+}
+
+// Sample performs a search request (without scroll) returning the first size
+// documents of the query
+func (c *Client) Sample(searchBody map[string]interface{}, size int) (*ESSearchResponse, error) {
+	body := make(map[string]interface{}, len(searchBody)+1)
+
+	for k, v := range searchBody {
+		body[k] = v
+	}
+
+	body["size"] = size
+	jsonBody, err := json.Marshal(body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	queryParams := c.routingParams(c.routing)
+
+	for k, v := range c.filterParams() {
+		queryParams[k] = v
+	}
+
+	resp, err := c.search("sample", c.url("/_search", true, queryParams), jsonBody)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return c.searchResponse(resp, nil)
+}
+
+// Aggregate performs a search request (without scroll) using the given body,
+// returning its aggregations
+func (c *Client) Aggregate(searchBody map[string]interface{}) (*ESAggregationResponse, error) {
+	jsonBody, err := json.Marshal(searchBody)
+
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := c.searchRequest(c.url("/_search", true, c.routingParams(c.routing)), jsonBody)
 
 	if err != nil {
 		return nil, err
 	}
 
-	scrollResponse, err = c.searchResponse(resp)
+	var aggResponse ESAggregationResponse
 
-	return scrollResponse, err
+	if err := c.do("aggregate", req, &aggResponse); err != nil {
+		return nil, err
+	}
+
+	if err := c.validateShards(aggResponse.Shards); err != nil {
+		return nil, err
+	}
+
+	return &aggResponse, nil
 }
 
-func (c *Client) searchResponse(resp *http.Response) (searchResponse *ESSearchResponse, err error) {
+// Stats returns the docs and store stats of the primary shards of the index
+func (c *Client) Stats() (*IndexStats, error) {
+	req, err := http.NewRequest(http.MethodGet, c.url("/_stats/docs,store", false, nil), nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var stats struct {
+		All struct {
+			Primaries IndexStats `json:"primaries"`
+		} `json:"_all"`
+	}
+
+	if err := c.do("stats", req, &stats); err != nil {
+		return nil, err
+	}
+
+	return &stats.All.Primaries, nil
+}
+
+// NumberOfShards returns the number of primary shards of the index. When the
+// client targets several indices (an alias or a pattern) the lowest number of
+// shards among them is returned, like ES does for slices=auto on reindex.
+func (c *Client) NumberOfShards() (int, error) {
+	req, err := http.NewRequest(http.MethodGet, c.url("/_settings/index.number_of_shards", false, nil), nil)
+
+	if err != nil {
+		return 0, err
+	}
+
+	var settings map[string]struct {
+		Settings struct {
+			Index struct {
+				NumberOfShards string `json:"number_of_shards"`
+			} `json:"index"`
+		} `json:"settings"`
+	}
+
+	if err := c.do("settings", req, &settings); err != nil {
+		return 0, err
+	}
+
+	shards := 0
+
+	for index, s := range settings {
+		n, err := strconv.Atoi(s.Settings.Index.NumberOfShards)
+
+		if err != nil {
+			return 0, fmt.Errorf("Invalid number of shards for index %v: %v", index, err)
+		}
+
+		if shards == 0 || n < shards {
+			shards = n
+		}
+	}
+
+	if shards == 0 {
+		return 0, errors.New("No index found to read the number of shards from")
+	}
+
+	return shards, nil
+}
+
+// FieldMapping is the mapping of a field: its type and, for objects, the
+// mappings of the fields it holds
+type FieldMapping struct {
+	Type       string                  `json:"type,omitempty"`
+	Properties map[string]FieldMapping `json:"properties,omitempty"`
+}
+
+// Mapping returns the mappings of the fields of the index. When the client
+// targets several indices their fields are merged, a field mapped by
+// several of them keeping the mapping of the first index by name.
+func (c *Client) Mapping() (map[string]FieldMapping, error) {
+	req, err := http.NewRequest(http.MethodGet, c.url("/_mapping", false, nil), nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var mappings map[string]struct {
+		Mappings json.RawMessage `json:"mappings"`
+	}
+
+	if err := c.do("mapping", req, &mappings); err != nil {
+		return nil, err
+	}
+
+	var indices []string
+
+	for index := range mappings {
+		indices = append(indices, index)
+	}
+
+	sort.Strings(indices)
+	fields := map[string]FieldMapping{}
+
+	for _, index := range indices {
+		var m struct {
+			Properties map[string]FieldMapping `json:"properties"`
+		}
+
+		if err := json.Unmarshal(mappings[index].Mappings, &m); err != nil {
+			return nil, fmt.Errorf("Invalid mapping for index %v: %v", index, err)
+		}
+
+		// Before ES 7 the fields are mapped by document type
+		if m.Properties == nil {
+			var types map[string]struct {
+				Properties map[string]FieldMapping `json:"properties"`
+			}
+
+			if err := json.Unmarshal(mappings[index].Mappings, &types); err == nil {
+				for _, t := range types {
+					mergeFields(fields, t.Properties)
+				}
+			}
+		}
+
+		mergeFields(fields, m.Properties)
+	}
+
+	return fields, nil
+}
+
+func mergeFields(fields, other map[string]FieldMapping) {
+	for name, f := range other {
+		existing, ok := fields[name]
+
+		if !ok {
+			fields[name] = f
+			continue
+		}
+
+		if existing.Properties != nil && f.Properties != nil {
+			mergeFields(existing.Properties, f.Properties)
+		}
+	}
+}
+
+// Metadata returns the response of GET /index/<endpoint> (_mapping,
+// _settings or _alias) by index name, as returned by ES
+func (c *Client) Metadata(endpoint string) (map[string]json.RawMessage, error) {
+	req, err := http.NewRequest(http.MethodGet, c.url("/"+endpoint, false, nil), nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var metadata map[string]json.RawMessage
+
+	if err := c.do(strings.TrimPrefix(endpoint, "_"), req, &metadata); err != nil {
+		return nil, err
+	}
+
+	return metadata, nil
+}
+
+// ResolveIndex returns the concrete indices the index (an alias, a data
+// stream or a pattern) resolves to, sorted. ES versions without
+// _resolve/index (before 7.9) resolve it with _alias.
+func (c *Client) ResolveIndex() ([]string, error) {
+	index := c.index
+
+	if index == "" {
+		index = "*"
+	}
+
+	req, err := http.NewRequest(http.MethodGet, c.host+"/_resolve/index/"+index, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var resolved struct {
+		Indices []struct {
+			Name string `json:"name"`
+		} `json:"indices"`
+		Aliases []struct {
+			Indices []string `json:"indices"`
+		} `json:"aliases"`
+		DataStreams []struct {
+			BackingIndices []string `json:"backing_indices"`
+		} `json:"data_streams"`
+	}
+
+	err = c.do("resolve", req, &resolved)
+	var statusErr *HTTPStatusError
+
+	if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusBadRequest {
+		return c.resolveAliases()
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+
+	for _, i := range resolved.Indices {
+		seen[i.Name] = true
+	}
+
+	for _, a := range resolved.Aliases {
+		for _, i := range a.Indices {
+			seen[i] = true
+		}
+	}
+
+	for _, d := range resolved.DataStreams {
+		for _, i := range d.BackingIndices {
+			seen[i] = true
+		}
+	}
+
+	return sortedKeys(seen), nil
+}
+
+// resolveAliases returns the concrete indices of the index from _alias,
+// which lists every index it matches
+func (c *Client) resolveAliases() ([]string, error) {
+	aliases, err := c.Metadata("_alias")
+
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+
+	for index := range aliases {
+		seen[index] = true
+	}
+
+	return sortedKeys(seen), nil
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+// IndexExists reports whether the index (an index, alias or pattern) exists
+func (c *Client) IndexExists() (bool, error) {
+	req, err := http.NewRequest(http.MethodHead, c.url("", false, nil), nil)
+
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := c.track(RequestInfo{"exists", req.Method, req.URL.String()}, func() (*http.Response, error) {
+		return c.client.Do(req)
+	})
+
+	if err != nil {
+		return false, err
+	}
+
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+
+	return false, newHTTPStatusError(resp.StatusCode, nil)
+}
+
+// Health returns the health of the shards of the index, or of the whole
+// cluster when the client has no index
+func (c *Client) Health() (*ClusterHealth, error) {
+	endpoint := c.host + "/_cluster/health"
+
+	if c.index != "" {
+		endpoint += "/" + c.index
+	}
+
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var health ClusterHealth
+
+	if err := c.do("health", req, &health); err != nil {
+		return nil, err
+	}
+
+	return &health, nil
+}
+
+// ValidateQuery asks ES whether the query of the search body is valid,
+// returning why it isn't or an empty string when it is
+func (c *Client) ValidateQuery(searchBody map[string]interface{}) (string, error) {
+	validateBody := map[string]interface{}{}
+
+	if query, ok := searchBody["query"]; ok {
+		validateBody["query"] = query
+	}
+
+	jsonBody, err := json.Marshal(validateBody)
+
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, c.url("/_validate/query", false, url.Values{"explain": []string{"true"}}), bytes.NewReader(jsonBody))
+
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	var validation struct {
+		Valid        bool   `json:"valid"`
+		Error        string `json:"error"`
+		Explanations []struct {
+			Valid bool   `json:"valid"`
+			Error string `json:"error"`
+		} `json:"explanations"`
+	}
+
+	if err := c.do("validate", req, &validation); err != nil {
+		return "", err
+	}
+
+	if validation.Valid {
+		return "", nil
+	}
+
+	if validation.Error != "" {
+		return validation.Error, nil
+	}
+
+	for _, e := range validation.Explanations {
+		if !e.Valid && e.Error != "" {
+			return e.Error, nil
+		}
+	}
+
+	return "The query is not valid", nil
+}
+
+// Count returns the number of documents matching the query of the given search body
+func (c *Client) Count(searchBody map[string]interface{}) (int64, error) {
+	countBody := map[string]interface{}{}
+
+	if query, ok := searchBody["query"]; ok {
+		countBody["query"] = query
+	}
+
+	jsonBody, err := json.Marshal(countBody)
+
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, c.url("/_count", true, c.routingParams(c.routing)), bytes.NewReader(jsonBody))
+
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	var count struct {
+		Count int64 `json:"count"`
+	}
+
+	if err := c.do("count", req, &count); err != nil {
+		return 0, err
+	}
+
+	return count.Count, nil
+}
+
+// search sends a request to a search endpoint, with the method of
+// WithSearchMethod if set
+func (c *Client) search(name, url string, body []byte) (*http.Response, error) {
+	if c.searchMethod == "" && c.methodOverride == "" {
+		return c.track(RequestInfo{name, http.MethodPost, url}, func() (*http.Response, error) {
+			return c.client.Post(url, "application/json", bytes.NewReader(body))
+		})
+	}
+
+	req, err := c.searchRequest(url, body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return c.track(RequestInfo{name, req.Method, url}, func() (*http.Response, error) {
+		return c.client.Do(req)
+	})
+}
+
+// searchRequest builds a request to a search endpoint, POST unless
+// WithSearchMethod says otherwise
+func (c *Client) searchRequest(url string, body []byte) (*http.Request, error) {
+	method := http.MethodPost
+
+	if c.searchMethod != "" {
+		method = c.searchMethod
+	}
+
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	if c.methodOverride != "" {
+		req.Header.Set("X-HTTP-Method-Override", c.methodOverride)
+	}
+
+	return req, nil
+}
+
+func (c *Client) do(name string, req *http.Request, v interface{}) error {
+	resp, err := c.track(RequestInfo{name, req.Method, req.URL.String()}, func() (*http.Response, error) {
+		return c.client.Do(req)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if err := checkResponseStatus(resp); err != nil {
+		return err
+	}
+
+	if err := c.decode(resp, v); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// track sends a request with send, calling the hooks around it
+func (c *Client) track(req RequestInfo, send func() (*http.Response, error)) (*http.Response, error) {
+	if c.hooks.OnRequest != nil {
+		c.hooks.OnRequest(req)
+	}
+
+	start := time.Now()
+	resp, err := send()
+
+	if c.hooks.OnResponse != nil {
+		info := ResponseInfo{Duration: time.Since(start), Err: err}
+
+		if resp != nil {
+			info.StatusCode = resp.StatusCode
+		}
+
+		c.hooks.OnResponse(req, info)
+	}
+
+	return resp, err
+}
+
+// decode decodes the JSON response body into v, enforcing the maximum
+// response size
+func (c *Client) decode(resp *http.Response, v interface{}) error {
+	return c.decodeWith(resp, func(d *json.Decoder) error { return d.Decode(v) })
+}
+
+// decodeWith decodes the response body with decode, enforcing the maximum
+// response size
+func (c *Client) decodeWith(resp *http.Response, decode func(d *json.Decoder) error) error {
+	return c.decodeLimited(resp, c.maxResponseBytes, decode)
+}
+
+// decodeLimited decodes the response body, failing with ErrResponseTooLarge
+// when larger than limit bytes (if not zero)
+func (c *Client) decodeLimited(resp *http.Response, limit int64, decode func(d *json.Decoder) error) error {
+	var body io.Reader = resp.Body
+
+	if limit > 0 {
+		if resp.ContentLength > limit {
+			return c.responseTooLarge()
+		}
+
+		body = &limitedReader{r: resp.Body, n: limit}
+	}
+
+	if err := decode(json.NewDecoder(body)); err != nil {
+		if errors.Is(err, ErrResponseTooLarge) {
+			return c.responseTooLarge()
+		}
+
+		return fmt.Errorf("Error decoding response: %w", err)
+	}
+
+	return nil
+}
+
+func (c *Client) responseTooLarge() error {
+	return fmt.Errorf("%w (%v bytes)", ErrResponseTooLarge, c.maxResponseBytes)
+}
+
+// limitedReader reads up to n bytes from r, failing with ErrResponseTooLarge
+// when there is more to read
+type limitedReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.n < 0 {
+		return 0, ErrResponseTooLarge
+	}
+
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+
+	if l.n < 0 {
+		// Drop the byte over the limit, otherwise the decoder could still
+		// find a complete value in what was read
+		return n - 1, ErrResponseTooLarge
+	}
+
+	return n, err
+}
+
+// countingReader counts the bytes read from r
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func checkResponseStatus(resp *http.Response) error {
 	if resp.StatusCode != http.StatusOK {
-		if r, e := ioutil.ReadAll(resp.Body); e == nil {
-			fmt.Printf("Bad response content: %s\n", r)
-		} else {
-			fmt.Println("Error reading response:", e)
+		r, e := ioutil.ReadAll(resp.Body)
+
+		if e != nil {
+			fmt.Fprintln(os.Stderr, "Error reading response:", e)
 		}
 
-		return nil, fmt.Errorf("Unexpected response received: %v", resp.StatusCode)
+		return newHTTPStatusError(resp.StatusCode, r)
+	}
+
+	return nil
+}
+
+// searchResponse decodes a search or scroll response, handing its hits to
+// hit one at a time if not nil
+func (c *Client) searchResponse(resp *http.Response, hit func(*Hit) error) (searchResponse *ESSearchResponse, err error) {
+	defer resp.Body.Close()
+
+	if err := checkResponseStatus(resp); err != nil {
+		return nil, err
+	}
+
+	body := &countingReader{r: resp.Body}
+	resp.Body = ioutil.NopCloser(body)
+	searchResponse = &ESSearchResponse{}
+	limit := c.maxResponseBytes
+	// validated is set once the shards were checked, before handing the
+	// first hit when the shards come first (as ES sends them), hitErr is
+	// the error stopping the decoding from there
+	var validated bool
+	var hitErr error
+	each := hit
+
+	if hit != nil {
+		limit = 0
+		each = func(h *Hit) error {
+			if !validated && searchResponse.Shards.Total > 0 {
+				validated = true
+
+				if hitErr = c.validateShards(searchResponse.Shards); hitErr != nil {
+					return hitErr
+				}
+			}
+
+			hitErr = hit(h)
+			return hitErr
+		}
 	}
 
-	if err := json.NewDecoder(resp.Body).Decode(&searchResponse); err != nil {
-		return nil, fmt.Errorf("Error decoding response: %v", err)
+	err = c.decodeLimited(resp, limit, func(d *json.Decoder) error { return decodeSearchResponse(d, searchResponse, each) })
+
+	if hitErr != nil {
+		return nil, hitErr
 	}
 
-	if err := c.validateShardsResponse(searchResponse); err != nil {
+	if err != nil {
 		return nil, err
 	}
 
-	return searchResponse, err
+	searchResponse.Bytes = body.n
+
+	if !validated {
+		if err := c.validateShards(searchResponse.Shards); err != nil {
+			return nil, err
+		}
+	}
+
+	return searchResponse, nil
+}
+
+// decodeSearchResponse decodes the search response token by token and its
+// hits one at a time. Decoding it at once would first read the whole page,
+// holding its JSON along with the hits decoded from it. The hits are
+// returned in r, or handed to hit if not nil without being kept.
+func decodeSearchResponse(d *json.Decoder, r *ESSearchResponse, hit func(*Hit) error) error {
+	return decodeObject(d, func(key string) error {
+		switch key {
+		case "_scroll_id":
+			return d.Decode(&r.ScrollID)
+		case "took":
+			return d.Decode(&r.Took)
+		case "_shards":
+			return d.Decode(&r.Shards)
+		case "hits":
+			return decodeObject(d, func(key string) error {
+				switch key {
+				case "total":
+					return d.Decode(&r.Hits.Total)
+				case "hits":
+					return decodeArray(d, func() error {
+						var h Hit
+
+						if err := d.Decode(&h); err != nil {
+							return err
+						}
+
+						if hit == nil {
+							r.Hits.Hits = append(r.Hits.Hits, h)
+							return nil
+						}
+
+						if err := hit(&h); err != nil {
+							return err
+						}
+
+						r.Streamed++
+						return nil
+					})
+				}
+
+				return skipValue(d)
+			})
+		}
+
+		return skipValue(d)
+	})
+}
+
+// decodeObject calls field for every key of the JSON object read next, which
+// has to decode the value of the key
+func decodeObject(d *json.Decoder, field func(key string) error) error {
+	if err := expectDelim(d, '{'); err != nil {
+		return err
+	}
+
+	for d.More() {
+		t, err := d.Token()
+
+		if err != nil {
+			return err
+		}
+
+		if err := field(t.(string)); err != nil {
+			return err
+		}
+	}
+
+	return expectDelim(d, '}')
+}
+
+// decodeArray calls value for every value of the JSON array read next,
+// which has to decode it
+func decodeArray(d *json.Decoder, value func() error) error {
+	if err := expectDelim(d, '['); err != nil {
+		return err
+	}
+
+	for d.More() {
+		if err := value(); err != nil {
+			return err
+		}
+	}
+
+	return expectDelim(d, ']')
+}
+
+func expectDelim(d *json.Decoder, delim json.Delim) error {
+	t, err := d.Token()
+
+	if err != nil {
+		return err
+	}
+
+	if t != delim {
+		return fmt.Errorf("Expected %v, got %v", delim, t)
+	}
+
+	return nil
+}
+
+func skipValue(d *json.Decoder) error {
+	var skipped json.RawMessage
+	return d.Decode(&skipped)
 }
 
-func (c *Client) validateShardsResponse(searchResponse *ESSearchResponse) (err error) {
+func (c *Client) validateShards(shards Shards) (err error) {
 	// For details check:
 	// https://github.com/elastic/elasticsearch-py/blob/2a96ce14f1ec81fe719bfaf1669dd2a94083f085/elasticsearch/helpers/__init__.py#L385
 	// https://github.com/elastic/elasticsearch-py/issues/660
-	shards := searchResponse.Shards
 
 	if !(shards.Failed == 0 && (shards.Successful == shards.Total)) {
-		err = fmt.Errorf("Response incomplete (shards response: [total: %d, successful: %d, failed: %d])", shards.Total, shards.Successful, shards.Failed)
+		err = fmt.Errorf("%w (shards response: [total: %d, successful: %d, failed: %d])", ErrShardFailure, shards.Total, shards.Successful, shards.Failed)
 	}
 
-	return err
+	if err == nil || !c.allowsFailedShards(shards) {
+		return err
+	}
+
+	if atomic.AddInt64(&c.partialResponses, 1) == 1 {
+		fmt.Fprintf(os.Stderr, "\nWarning: %v, continuing with the documents of the other shards\n", err)
+	}
+
+	return nil
+}
+
+func (c *Client) allowsFailedShards(shards Shards) bool {
+	failed := shards.Total - shards.Successful
+
+	if shards.Failed > failed {
+		failed = shards.Failed
+	}
+
+	return c.maxFailedShards < 0 || failed <= c.maxFailedShards
+}
+
+// PartialResponses returns the number of responses accepted without the
+// documents of some shards (see WithMaxFailedShards)
+func (c *Client) PartialResponses() int64 {
+	return atomic.LoadInt64(&c.partialResponses)
+}
+
+func (c *Client) searchURL(routing string) string {
+	queryParams := c.routingParams(routing)
+
+	for k, v := range c.filterParams() {
+		queryParams[k] = v
+	}
+
+	if c.searchContextTTL != "" {
+		queryParams.Set("scroll", c.searchContextTTL)
+	}
+
+	return c.url("/_search", true, queryParams)
+}
+
+func (c *Client) routingParams(routing string) url.Values {
+	queryParams := url.Values{}
+
+	if routing != "" {
+		queryParams.Set("routing", routing)
+	}
+
+	return queryParams
+}
+
+// filterParams returns the filter_path of search and scroll requests, if any
+func (c *Client) filterParams() url.Values {
+	queryParams := url.Values{}
+
+	if c.filterPath != "" {
+		queryParams.Set("filter_path", c.filterPath)
+	}
+
+	return queryParams
 }
 
-func (c *Client) searchURL() string {
+// url builds the url of the given endpoint on the client index (and
+// document type when withType is set)
+func (c *Client) url(endpoint string, withType bool, queryParams url.Values) string {
 	var buffer bytes.Buffer
 	buffer.WriteString(c.host)
 
 	if c.index != "" {
 		buffer.WriteString("/")
 		buffer.WriteString(c.index)
-	} else if c.docType != "" {
+	} else if withType && c.docType != "" {
 		buffer.WriteString("/*")
 	}
 
-	if c.docType != "" {
+	if withType && c.docType != "" {
 		buffer.WriteString("/")
 		buffer.WriteString(c.docType)
 	}
 
-	buffer.WriteString("/_search")
-
-	queryParams := url.Values{}
-
-	if c.searchContextTTL != "" {
-		queryParams.Set("scroll", c.searchContextTTL)
-	}
-
-	if c.routing != "" {
-		queryParams.Set("routing", c.routing)
-	}
+	buffer.WriteString(endpoint)
 
 	if len(queryParams) > 0 {
 		buffer.WriteString("?")
@@ -3,10 +3,11 @@ package client
 import (
 	"encoding/json"
 	"errors"
-	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -21,6 +22,13 @@ type MockHTTPClient struct {
 		Response *http.Response
 		Err      error
 	}
+	DoArgsReceived struct {
+		Request *http.Request
+	}
+	DoResponse struct {
+		Response *http.Response
+		Err      error
+	}
 }
 
 func (m *MockHTTPClient) Post(url, contentType string, body io.Reader) (*http.Response, error) {
@@ -31,8 +39,14 @@ func (m *MockHTTPClient) Post(url, contentType string, body io.Reader) (*http.Re
 	return m.PostResponse.Response, m.PostResponse.Err
 }
 
+func (m *MockHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	m.DoArgsReceived.Request = req
+	return m.DoResponse.Response, m.DoResponse.Err
+}
+
 func TestNewClient(t *testing.T) {
 	mockHTTPClient := &MockHTTPClient{}
+	_, invalidURLErr := url.ParseRequestURI("invalid-url")
 	scenarios := []struct {
 		httpClient       HTTPClient
 		host             string
@@ -42,7 +56,7 @@ func TestNewClient(t *testing.T) {
 		searchContextTTL string
 		err              error
 	}{
-		{mockHTTPClient, "invalid-url", "index", "docType", "routing", "searchContextTTL", fmt.Errorf("parse invalid-url: invalid URI for request")},
+		{mockHTTPClient, "invalid-url", "index", "docType", "routing", "searchContextTTL", invalidURLErr},
 		{mockHTTPClient, "http://localhost:9200", "index", "docType", "routing", "searchContextTTL", nil},
 	}
 
@@ -244,6 +258,10 @@ func TestSearchWhenAShardFailed(t *testing.T) {
 	if err.Error() != "Response incomplete (shards response: [total: 2, successful: 1, failed: 1])" {
 		t.Errorf("Search returned an unexpected error: %v", err)
 	}
+
+	if !errors.Is(err, ErrShardFailure) {
+		t.Errorf("Expected error to be '%v', got '%v'", ErrShardFailure, err)
+	}
 }
 
 func TestSearchWhenAShardIsMissing(t *testing.T) {
@@ -281,6 +299,106 @@ func TestSearchWhenAShardIsMissing(t *testing.T) {
 	}
 }
 
+func TestDecodeSearchResponse(t *testing.T) {
+	scenarios := []struct {
+		body        string
+		scrollID    string
+		took        int64
+		total       int
+		ids         []string
+		expectedErr bool
+	}{
+		{`{"_scroll_id":"s","took":3,"timed_out":false,"_shards":{"total":1,"successful":1,"failed":0},
+			"hits":{"total":2,"max_score":null,"hits":[{"_id":"a","_source":{"n":[1,{"x":2}]}},{"_id":"b","sort":[1]}]}}`, "s", 3, 2, []string{"a", "b"}, false},
+		{`{"hits":{"hits":[],"total":0},"_scroll_id":"s"}`, "s", 0, 0, nil, false},
+		{`{"hits":{"total":1,"hits":[{"_id":"a"}`, "", 0, 1, []string{"a"}, true},
+		{`[]`, "", 0, 0, nil, true},
+	}
+
+	for _, scenario := range scenarios {
+		var resp ESSearchResponse
+		err := decodeSearchResponse(json.NewDecoder(strings.NewReader(scenario.body)), &resp, nil)
+
+		if scenario.expectedErr != (err != nil) {
+			t.Errorf("Unexpected error decoding %v: %v", scenario.body, err)
+			continue
+		}
+
+		if scenario.expectedErr {
+			continue
+		}
+
+		var ids []string
+
+		for _, hit := range resp.Hits.Hits {
+			ids = append(ids, hit.ID)
+		}
+
+		if resp.ScrollID != scenario.scrollID || resp.Took != scenario.took || resp.Hits.Total != scenario.total || !reflect.DeepEqual(ids, scenario.ids) {
+			t.Errorf("Unexpected response decoded from %v: %+v", scenario.body, resp)
+		}
+	}
+}
+
+func TestSearchEach(t *testing.T) {
+	body := `{"_scroll_id":"s","_shards":{"total":2,"successful":2,"failed":0},"hits":{"total":3,"hits":[{"_id":"a"},{"_id":"b"},{"_id":"c"}]}}`
+	errStop := errors.New("stop")
+
+	scenarios := []struct {
+		body     string
+		stopAt   string
+		ids      []string
+		streamed int
+		err      error
+	}{
+		{body, "", []string{"a", "b", "c"}, 3, nil},
+		{body, "b", []string{"a", "b"}, 0, errStop},
+		// The shards are checked before the first hit is handed
+		{strings.Replace(body, `"successful":2,"failed":0`, `"successful":1,"failed":1`, 1), "", nil, 0, ErrShardFailure},
+	}
+
+	for _, scenario := range scenarios {
+		mockHTTPClient := &MockHTTPClient{}
+		mockHTTPClient.PostResponse.Response = &http.Response{
+			StatusCode: 200,
+			Body:       ioutil.NopCloser(strings.NewReader(scenario.body))}
+
+		// Streamed responses aren't held, the limit doesn't apply
+		esClient, _ := NewClient(mockHTTPClient, "http://localhost:9200", "", "", "", "", WithMaxResponseBytes(10))
+		var ids []string
+
+		resp, err := esClient.SearchEach(map[string]interface{}{}, func(hit *Hit) error {
+			ids = append(ids, hit.ID)
+
+			if hit.ID == scenario.stopAt {
+				return errStop
+			}
+
+			return nil
+		})
+
+		if !reflect.DeepEqual(ids, scenario.ids) {
+			t.Errorf("Expected hits %v to be handed, got %v", scenario.ids, ids)
+		}
+
+		if scenario.err != nil {
+			if !errors.Is(err, scenario.err) {
+				t.Errorf("Expected error '%v', got '%v'", scenario.err, err)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		if resp.ScrollID != "s" || resp.Hits.Total != 3 || len(resp.Hits.Hits) != 0 || resp.Streamed != scenario.streamed {
+			t.Errorf("Unexpected response: %+v", resp)
+		}
+	}
+}
+
 func TestSearch(t *testing.T) {
 	mockHTTPClient := &MockHTTPClient{}
 	successfulResponse := `
@@ -332,6 +450,77 @@ func TestSearch(t *testing.T) {
 	}
 }
 
+func TestSearchWithFields(t *testing.T) {
+	mockHTTPClient := &MockHTTPClient{}
+	response := `
+	{
+		"_shards": { "total": 1, "successful": 1, "failed": 0 },
+		"hits": {
+			"total": 1,
+			"hits": [
+			{ "_id": "id", "fields": { "day_of_week": ["Monday"], "price": [9.5] } }
+			]
+		}
+	}
+	`
+	mockHTTPClient.PostResponse.Response = &http.Response{
+		StatusCode: 200,
+		Body:       ioutil.NopCloser(strings.NewReader(response))}
+
+	esClient, _ := NewClient(mockHTTPClient, "http://localhost:9200", "", "", "", "")
+	resp, err := esClient.Search(map[string]interface{}{"docvalue_fields": []string{"day_of_week", "price"}})
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	hit, _ := json.Marshal(resp.Hits.Hits[0])
+	expected := `{"_id":"id","fields":{"day_of_week":["Monday"],"price":[9.5]}}`
+
+	if string(hit) != expected {
+		t.Errorf("Expected hit to be '%v', got '%s'", expected, hit)
+	}
+}
+
+func TestSearchWithMaxResponseBytes(t *testing.T) {
+	body := `{"_shards":{"total":1,"successful":1,"failed":0},"hits":{"total":1,"hits":[{"_id":"id"}]}}`
+
+	scenarios := []struct {
+		maxResponseBytes int64
+		contentLength    int64
+		err              string
+	}{
+		{0, -1, ""},
+		{int64(len(body)), -1, ""},
+		{int64(len(body)) - 1, -1, "Response exceeds the maximum size (89 bytes)"},
+		{int64(len(body)) - 1, int64(len(body)), "Response exceeds the maximum size (89 bytes)"},
+	}
+
+	for _, scenario := range scenarios {
+		mockHTTPClient := &MockHTTPClient{}
+		mockHTTPClient.PostResponse.Response = &http.Response{
+			StatusCode:    200,
+			ContentLength: scenario.contentLength,
+			Body:          ioutil.NopCloser(strings.NewReader(body))}
+
+		esClient, err := NewClient(mockHTTPClient, "http://localhost:9200", "", "", "", "", WithMaxResponseBytes(scenario.maxResponseBytes))
+
+		if err != nil {
+			t.Fatalf("Failed to create Client: %v", err)
+		}
+
+		_, err = esClient.Search(map[string]interface{}{})
+
+		if scenario.err == "" && err != nil {
+			t.Errorf("Unexpected error with a limit of %v bytes: %v", scenario.maxResponseBytes, err)
+		}
+
+		if scenario.err != "" && (err == nil || err.Error() != scenario.err || !errors.Is(err, ErrResponseTooLarge)) {
+			t.Errorf("Expected error '%v', got '%v'", scenario.err, err)
+		}
+	}
+}
+
 func TestScrollURL(t *testing.T) {
 	mockHTTPClient := &MockHTTPClient{}
 	mockHTTPClient.PostResponse.Response = &http.Response{
@@ -382,6 +571,81 @@ func TestScrollWhenRequestFailed(t *testing.T) {
 	}
 }
 
+func TestScrollWhenSearchContextExpired(t *testing.T) {
+	mockHTTPClient := &MockHTTPClient{}
+	mockHTTPClient.PostResponse.Response = &http.Response{
+		StatusCode: 404,
+		Body: ioutil.NopCloser(strings.NewReader(`{"error":{"root_cause":[{"type":"search_context_missing_exception",` +
+			`"reason":"No search context found for id [42]"}]},"status":404}`))}
+
+	esClient, err := NewClient(mockHTTPClient, "http://localhost:9200", "", "", "", "1m")
+
+	if err != nil {
+		t.Fatalf("Failed to create Client: %v", err)
+	}
+
+	_, err = esClient.Scroll("scroll_id")
+
+	if !errors.Is(err, ErrScrollExpired) {
+		t.Errorf("Expected error to be '%v', got '%v'", ErrScrollExpired, err)
+	}
+}
+
+func TestHTTPStatusError(t *testing.T) {
+	expired := `{"error":{"root_cause":[{"type":"search_context_missing_exception"}]},"status":404}`
+
+	parsing := `{"error":{"root_cause":[],"type":"parsing_exception","reason":"Unknown key [foo]"},"status":400}`
+
+	scenarios := []struct {
+		statusCode      int
+		body            string
+		expectedErr     string
+		expectedUnauth  bool
+		expectedExpired bool
+	}{
+		{500, `{}`, "Unexpected response received: 500", false, false},
+		{400, parsing, "Unexpected response received: 400 (parsing_exception: Unknown key [foo])", false, false},
+		{400, `{"error":"Unknown key [foo]"}`, "Unexpected response received: 400 (Unknown key [foo])", false, false},
+		{502, "<html>Bad Gateway</html>\n", "Unexpected response received: 502 (<html>Bad Gateway</html>)", false, false},
+		{401, `{}`, "Unexpected response received: 401", true, false},
+		{403, `{}`, "Unexpected response received: 403", true, false},
+		{404, `{}`, "Unexpected response received: 404", false, false},
+		{404, expired, "Scroll expired (search context not found)", false, true},
+	}
+
+	for _, scenario := range scenarios {
+		mockHTTPClient := &MockHTTPClient{}
+		mockHTTPClient.PostResponse.Response = &http.Response{
+			StatusCode: scenario.statusCode,
+			Body:       ioutil.NopCloser(strings.NewReader(scenario.body))}
+
+		esClient, _ := NewClient(mockHTTPClient, "http://localhost:9200", "", "", "", "1m")
+		_, err := esClient.Scroll("scroll_id")
+
+		var statusErr *HTTPStatusError
+
+		if !errors.As(err, &statusErr) {
+			t.Fatalf("Expected error to be a HTTPStatusError, got '%v'", err)
+		}
+
+		if statusErr.StatusCode != scenario.statusCode || string(statusErr.Body) != scenario.body {
+			t.Errorf("Expected status and body to be '%v %v', got '%v %s'", scenario.statusCode, scenario.body, statusErr.StatusCode, statusErr.Body)
+		}
+
+		if err.Error() != scenario.expectedErr {
+			t.Errorf("Expected error to be '%v', got '%v'", scenario.expectedErr, err)
+		}
+
+		if errors.Is(err, ErrUnauthorized) != scenario.expectedUnauth {
+			t.Errorf("Expected errors.Is(err, ErrUnauthorized) to be '%v' for status %v", scenario.expectedUnauth, scenario.statusCode)
+		}
+
+		if errors.Is(err, ErrScrollExpired) != scenario.expectedExpired {
+			t.Errorf("Expected errors.Is(err, ErrScrollExpired) to be '%v' for status %v", scenario.expectedExpired, scenario.statusCode)
+		}
+	}
+}
+
 func TestScroll(t *testing.T) {
 	mockHTTPClient := &MockHTTPClient{}
 	successfulResponse := `
@@ -435,3 +699,643 @@ func TestScroll(t *testing.T) {
 		t.Error("Unexpected document returned (field mismatch)")
 	}
 }
+
+func TestSearchWithSearchMethod(t *testing.T) {
+	mockHTTPClient := &MockHTTPClient{}
+	mockHTTPClient.DoResponse.Response = &http.Response{
+		StatusCode: 200,
+		Body: ioutil.NopCloser(strings.NewReader(`
+		{
+			"_scroll_id": "scroll_id",
+			"_shards": { "total": 1, "successful": 1, "failed": 0 },
+			"hits": { "total": 1, "hits": [ { "_id": "id", "_source": {} } ] }
+		}`))}
+
+	esClient, err := NewClient(mockHTTPClient, "http://localhost:9200", "my_index", "", "", "1m", WithSearchMethod(http.MethodGet, "GET"))
+
+	if err != nil {
+		t.Fatalf("Failed to create Client: %v", err)
+	}
+
+	resp, err := esClient.Search(map[string]interface{}{"size": 10})
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	req := mockHTTPClient.DoArgsReceived.Request
+
+	if req.Method != http.MethodGet {
+		t.Errorf("Expected method to be GET, got '%v'", req.Method)
+	}
+
+	if override := req.Header.Get("X-HTTP-Method-Override"); override != "GET" {
+		t.Errorf("Expected X-HTTP-Method-Override to be 'GET', got '%v'", override)
+	}
+
+	if contentType := req.Header.Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("Expected content type to be 'application/json', got '%v'", contentType)
+	}
+
+	body, _ := ioutil.ReadAll(req.Body)
+
+	if string(body) != `{"size":10}` {
+		t.Errorf("Expected the query to be sent as the body, got '%v'", string(body))
+	}
+
+	if resp.ScrollID != "scroll_id" {
+		t.Errorf("Expected scroll id to be 'scroll_id', got '%v'", resp.ScrollID)
+	}
+}
+
+func TestSample(t *testing.T) {
+	mockHTTPClient := &MockHTTPClient{}
+	mockHTTPClient.PostResponse.Response = &http.Response{
+		StatusCode: 200,
+		Body: ioutil.NopCloser(strings.NewReader(`
+		{
+			"_shards": { "total": 1, "successful": 1, "failed": 0 },
+			"hits": { "total": 5, "hits": [ { "_id": "1", "_source": {} }, { "_id": "2", "_source": {} } ] }
+		}`))}
+
+	esClient, err := NewClient(mockHTTPClient, "http://localhost:9200", "my_index", "", "", "1m")
+
+	if err != nil {
+		t.Fatalf("Failed to create Client: %v", err)
+	}
+
+	query := map[string]interface{}{"size": 1000, "query": map[string]interface{}{"match_all": map[string]interface{}{}}}
+	resp, err := esClient.Sample(query, 2)
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expectedURL := "http://localhost:9200/my_index/_search"
+
+	if url := mockHTTPClient.PostArgsReceived.URL; url != expectedURL {
+		t.Errorf("Expected url to be '%v' (without scroll), but got '%v'", expectedURL, url)
+	}
+
+	body, _ := ioutil.ReadAll(mockHTTPClient.PostArgsReceived.Body)
+	expectedBody := `{"query":{"match_all":{}},"size":2}`
+
+	if string(body) != expectedBody {
+		t.Errorf("Expected body to be '%v', got '%v'", expectedBody, string(body))
+	}
+
+	if query["size"] != 1000 {
+		t.Errorf("Expected the query to be left as is, got size %v", query["size"])
+	}
+
+	if len(resp.Hits.Hits) != 2 || resp.Bytes == 0 {
+		t.Errorf("Expected 2 hits and the size of the response, got %v hits and %v bytes", len(resp.Hits.Hits), resp.Bytes)
+	}
+}
+
+func TestStats(t *testing.T) {
+	mockHTTPClient := &MockHTTPClient{}
+	mockHTTPClient.DoResponse.Response = &http.Response{
+		StatusCode: 200,
+		Body: ioutil.NopCloser(strings.NewReader(`
+		{
+			"_all": {
+				"primaries": { "docs": { "count": 10 }, "store": { "size_in_bytes": 2048 } },
+				"total": { "docs": { "count": 20 }, "store": { "size_in_bytes": 4096 } }
+			}
+		}`))}
+
+	esClient, err := NewClient(mockHTTPClient, "http://localhost:9200", "my_index", "my_type", "my_routing", "1m")
+
+	if err != nil {
+		t.Fatalf("Failed to create Client: %v", err)
+	}
+
+	stats, err := esClient.Stats()
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expectedURL := "http://localhost:9200/my_index/_stats/docs,store"
+
+	if url := mockHTTPClient.DoArgsReceived.Request.URL.String(); url != expectedURL {
+		t.Errorf("Expected url to be '%v', but got '%v'", expectedURL, url)
+	}
+
+	if stats.Docs.Count != 10 {
+		t.Errorf("Expected docs count to be %v, got %v", 10, stats.Docs.Count)
+	}
+
+	if stats.Store.SizeInBytes != 2048 {
+		t.Errorf("Expected store size to be %v, got %v", 2048, stats.Store.SizeInBytes)
+	}
+}
+
+func TestCount(t *testing.T) {
+	mockHTTPClient := &MockHTTPClient{}
+	mockHTTPClient.DoResponse.Response = &http.Response{
+		StatusCode: 200,
+		Body:       ioutil.NopCloser(strings.NewReader(`{"count": 42}`))}
+
+	esClient, err := NewClient(mockHTTPClient, "http://localhost:9200", "my_index", "", "my_routing", "1m")
+
+	if err != nil {
+		t.Fatalf("Failed to create Client: %v", err)
+	}
+
+	query := map[string]interface{}{"size": 10, "query": map[string]interface{}{"match_all": map[string]interface{}{}}}
+	count, err := esClient.Count(query)
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	req := mockHTTPClient.DoArgsReceived.Request
+	expectedURL := "http://localhost:9200/my_index/_count?routing=my_routing"
+
+	if req.URL.String() != expectedURL {
+		t.Errorf("Expected url to be '%v', but got '%v'", expectedURL, req.URL.String())
+	}
+
+	expectedBody := `{"query":{"match_all":{}}}`
+	body, _ := ioutil.ReadAll(req.Body)
+
+	if string(body) != expectedBody {
+		t.Errorf("Expected body to be '%v', got '%s'", expectedBody, body)
+	}
+
+	if count != 42 {
+		t.Errorf("Expected count to be %v, got %v", 42, count)
+	}
+}
+
+func TestNumberOfShards(t *testing.T) {
+	mockHTTPClient := &MockHTTPClient{}
+	esClient, err := NewClient(mockHTTPClient, "http://localhost:9200", "my_alias", "", "", "1m")
+
+	if err != nil {
+		t.Fatalf("Failed to create Client: %v", err)
+	}
+
+	scenarios := []struct {
+		response string
+		shards   int
+		err      string
+	}{
+		{`{"index_a":{"settings":{"index":{"number_of_shards":"5"}}}}`, 5, ""},
+		{`{"index_a":{"settings":{"index":{"number_of_shards":"5"}}},"index_b":{"settings":{"index":{"number_of_shards":"3"}}}}`, 3, ""},
+		{`{}`, 0, "No index found to read the number of shards from"},
+	}
+
+	for _, scenario := range scenarios {
+		mockHTTPClient.DoResponse.Response = &http.Response{
+			StatusCode: 200,
+			Body:       ioutil.NopCloser(strings.NewReader(scenario.response))}
+
+		shards, err := esClient.NumberOfShards()
+
+		if scenario.err != "" && (err == nil || err.Error() != scenario.err) {
+			t.Errorf("Expected error '%v', but got '%v'", scenario.err, err)
+		}
+
+		if scenario.err == "" && err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+
+		if shards != scenario.shards {
+			t.Errorf("Expected number of shards to be %v, got %v", scenario.shards, shards)
+		}
+	}
+
+	expectedURL := "http://localhost:9200/my_alias/_settings/index.number_of_shards"
+
+	if url := mockHTTPClient.DoArgsReceived.Request.URL.String(); url != expectedURL {
+		t.Errorf("Expected url to be '%v', but got '%v'", expectedURL, url)
+	}
+}
+
+func TestMapping(t *testing.T) {
+	mockHTTPClient := &MockHTTPClient{}
+	esClient, err := NewClient(mockHTTPClient, "http://localhost:9200", "my_alias", "", "", "1m")
+
+	if err != nil {
+		t.Fatalf("Failed to create Client: %v", err)
+	}
+
+	scenarios := []struct {
+		response string
+		fields   map[string]FieldMapping
+	}{
+		{`{"index_a":{"mappings":{"properties":{"name":{"type":"keyword"},"user":{"properties":{"age":{"type":"long"}}}}}}}`,
+			map[string]FieldMapping{"name": {Type: "keyword"}, "user": {Properties: map[string]FieldMapping{"age": {Type: "long"}}}}},
+		{`{"index_b":{"mappings":{"properties":{"name":{"type":"text"},"user":{"properties":{"id":{"type":"keyword"}}}}}},` +
+			`"index_a":{"mappings":{"properties":{"name":{"type":"keyword"},"user":{"properties":{"age":{"type":"long"}}}}}}}`,
+			map[string]FieldMapping{"name": {Type: "keyword"}, "user": {Properties: map[string]FieldMapping{"age": {Type: "long"}, "id": {Type: "keyword"}}}}},
+		{`{"index_a":{"mappings":{"_doc":{"properties":{"name":{"type":"keyword"}}}}}}`,
+			map[string]FieldMapping{"name": {Type: "keyword"}}},
+	}
+
+	for _, scenario := range scenarios {
+		mockHTTPClient.DoResponse.Response = &http.Response{
+			StatusCode: 200,
+			Body:       ioutil.NopCloser(strings.NewReader(scenario.response))}
+
+		fields, err := esClient.Mapping()
+
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		if !reflect.DeepEqual(fields, scenario.fields) {
+			t.Errorf("Expected fields to be %v, got %v", scenario.fields, fields)
+		}
+	}
+
+	expectedURL := "http://localhost:9200/my_alias/_mapping"
+
+	if url := mockHTTPClient.DoArgsReceived.Request.URL.String(); url != expectedURL {
+		t.Errorf("Expected url to be '%v', but got '%v'", expectedURL, url)
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	mockHTTPClient := &MockHTTPClient{}
+	mockHTTPClient.DoResponse.Response = &http.Response{
+		StatusCode: 200,
+		Body:       ioutil.NopCloser(strings.NewReader(`{"index_a":{"aliases":{"my_alias":{}}}}`))}
+
+	esClient, err := NewClient(mockHTTPClient, "http://localhost:9200", "my_alias", "", "", "1m")
+
+	if err != nil {
+		t.Fatalf("Failed to create Client: %v", err)
+	}
+
+	metadata, err := esClient.Metadata("_alias")
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expectedURL := "http://localhost:9200/my_alias/_alias"
+
+	if url := mockHTTPClient.DoArgsReceived.Request.URL.String(); url != expectedURL {
+		t.Errorf("Expected url to be '%v', but got '%v'", expectedURL, url)
+	}
+
+	if aliases := string(metadata["index_a"]); aliases != `{"aliases":{"my_alias":{}}}` {
+		t.Errorf("Expected the aliases of index_a, got %v", aliases)
+	}
+}
+
+func TestResolveIndex(t *testing.T) {
+	mockHTTPClient := &MockHTTPClient{}
+	mockHTTPClient.DoResponse.Response = &http.Response{
+		StatusCode: 200,
+		Body: ioutil.NopCloser(strings.NewReader(`{
+			"indices":[{"name":"logs-2024.02","aliases":["logs"]},{"name":"logs-2024.01","aliases":["logs"]}],
+			"aliases":[{"name":"logs","indices":["logs-2024.01","logs-2024.02"]}],
+			"data_streams":[{"name":"logs-app","backing_indices":[".ds-logs-app-000001"],"timestamp_field":"@timestamp"}]}`))}
+
+	esClient, err := NewClient(mockHTTPClient, "http://localhost:9200", "logs*", "", "", "1m")
+
+	if err != nil {
+		t.Fatalf("Failed to create Client: %v", err)
+	}
+
+	indices, err := esClient.ResolveIndex()
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expectedURL := "http://localhost:9200/_resolve/index/logs*"
+
+	if url := mockHTTPClient.DoArgsReceived.Request.URL.String(); url != expectedURL {
+		t.Errorf("Expected url to be '%v', but got '%v'", expectedURL, url)
+	}
+
+	expected := []string{".ds-logs-app-000001", "logs-2024.01", "logs-2024.02"}
+
+	if !reflect.DeepEqual(indices, expected) {
+		t.Errorf("Expected indices %v, got %v", expected, indices)
+	}
+}
+
+func TestIndexExists(t *testing.T) {
+	mockHTTPClient := &MockHTTPClient{}
+	esClient, err := NewClient(mockHTTPClient, "http://localhost:9200", "my_index", "", "", "1m")
+
+	if err != nil {
+		t.Fatalf("Failed to create Client: %v", err)
+	}
+
+	scenarios := []struct {
+		statusCode int
+		exists     bool
+		err        error
+	}{
+		{200, true, nil},
+		{404, false, nil},
+		{401, false, ErrUnauthorized},
+	}
+
+	for _, scenario := range scenarios {
+		mockHTTPClient.DoResponse.Response = &http.Response{
+			StatusCode: scenario.statusCode,
+			Body:       ioutil.NopCloser(strings.NewReader(""))}
+
+		exists, err := esClient.IndexExists()
+
+		if exists != scenario.exists {
+			t.Errorf("Expected exists to be %v for status %v, got %v", scenario.exists, scenario.statusCode, exists)
+		}
+
+		if (scenario.err == nil && err != nil) || (scenario.err != nil && !errors.Is(err, scenario.err)) {
+			t.Errorf("Expected error '%v' for status %v, got '%v'", scenario.err, scenario.statusCode, err)
+		}
+	}
+
+	request := mockHTTPClient.DoArgsReceived.Request
+
+	if request.Method != http.MethodHead || request.URL.String() != "http://localhost:9200/my_index" {
+		t.Errorf("Expected HEAD http://localhost:9200/my_index, got %v %v", request.Method, request.URL)
+	}
+}
+
+func TestHealth(t *testing.T) {
+	mockHTTPClient := &MockHTTPClient{}
+	esClient, err := NewClient(mockHTTPClient, "http://localhost:9200", "my_index", "", "", "1m")
+
+	if err != nil {
+		t.Fatalf("Failed to create Client: %v", err)
+	}
+
+	mockHTTPClient.DoResponse.Response = &http.Response{
+		StatusCode: 200,
+		Body:       ioutil.NopCloser(strings.NewReader(`{"cluster_name":"es","status":"red","relocating_shards":1,"initializing_shards":2,"unassigned_shards":3}`))}
+
+	health, err := esClient.Health()
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := ClusterHealth{Status: "red", RelocatingShards: 1, InitializingShards: 2, UnassignedShards: 3}
+
+	if *health != expected {
+		t.Errorf("Expected %+v, got %+v", expected, *health)
+	}
+
+	request := mockHTTPClient.DoArgsReceived.Request
+
+	if request.Method != http.MethodGet || request.URL.String() != "http://localhost:9200/_cluster/health/my_index" {
+		t.Errorf("Expected GET http://localhost:9200/_cluster/health/my_index, got %v %v", request.Method, request.URL)
+	}
+
+	mockHTTPClient.DoResponse.Response = &http.Response{
+		StatusCode: 503,
+		Body:       ioutil.NopCloser(strings.NewReader(`{"error":{"type":"master_not_discovered_exception","reason":"no master"},"status":503}`))}
+
+	if _, err := esClient.Health(); err == nil {
+		t.Error("Expected an error for status 503")
+	}
+}
+
+func TestValidateQuery(t *testing.T) {
+	mockHTTPClient := &MockHTTPClient{}
+	esClient, err := NewClient(mockHTTPClient, "http://localhost:9200", "my_index", "", "", "1m")
+
+	if err != nil {
+		t.Fatalf("Failed to create Client: %v", err)
+	}
+
+	scenarios := []struct {
+		response string
+		reason   string
+	}{
+		{`{"valid":true,"explanations":[{"index":"my_index","valid":true}]}`, ""},
+		{`{"valid":false,"explanations":[{"index":"my_index","valid":false,"error":"failed to parse date field [x]"}]}`, "failed to parse date field [x]"},
+		{`{"valid":false,"error":"ParsingException[unknown query [matc]]"}`, "ParsingException[unknown query [matc]]"},
+	}
+
+	for _, scenario := range scenarios {
+		mockHTTPClient.DoResponse.Response = &http.Response{
+			StatusCode: 200,
+			Body:       ioutil.NopCloser(strings.NewReader(scenario.response))}
+
+		reason, err := esClient.ValidateQuery(map[string]interface{}{"query": map[string]interface{}{"match_all": map[string]interface{}{}}, "size": 100})
+
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		if reason != scenario.reason {
+			t.Errorf("Expected reason '%v', got '%v'", scenario.reason, reason)
+		}
+	}
+
+	expectedURL := "http://localhost:9200/my_index/_validate/query?explain=true"
+
+	if url := mockHTTPClient.DoArgsReceived.Request.URL.String(); url != expectedURL {
+		t.Errorf("Expected url to be '%v', but got '%v'", expectedURL, url)
+	}
+
+	body, _ := ioutil.ReadAll(mockHTTPClient.DoArgsReceived.Request.Body)
+
+	if string(body) != `{"query":{"match_all":{}}}` {
+		t.Errorf("Expected only the query to be validated, got %s", body)
+	}
+}
+
+func TestSearchWithMaxFailedShards(t *testing.T) {
+	scenarios := []struct {
+		maxFailedShards  int
+		shards           string
+		expectedErr      bool
+		expectedPartials int64
+	}{
+		{0, `{ "total": 2, "successful": 2, "failed": 0 }`, false, 0},
+		{0, `{ "total": 2, "successful": 1, "failed": 1 }`, true, 0},
+		{1, `{ "total": 2, "successful": 1, "failed": 1 }`, false, 1},
+		{1, `{ "total": 3, "successful": 1, "failed": 1 }`, true, 0},
+		{1, `{ "total": 3, "successful": 1, "failed": 2 }`, true, 0},
+		{2, `{ "total": 3, "successful": 1, "failed": 0 }`, false, 1},
+		{-1, `{ "total": 5, "successful": 0, "failed": 5 }`, false, 1},
+	}
+
+	for _, scenario := range scenarios {
+		mockHTTPClient := &MockHTTPClient{}
+		mockHTTPClient.PostResponse.Response = &http.Response{
+			StatusCode: 200,
+			Body:       ioutil.NopCloser(strings.NewReader(`{"_shards": ` + scenario.shards + `, "hits": {"total": 0, "hits": []}}`))}
+
+		esClient, _ := NewClient(mockHTTPClient, "http://localhost:9200", "", "", "", "", WithMaxFailedShards(scenario.maxFailedShards))
+		_, err := esClient.Search(map[string]interface{}{})
+
+		if scenario.expectedErr != errors.Is(err, ErrShardFailure) {
+			t.Errorf("Unexpected error with %v and max failed shards %v: %v", scenario.shards, scenario.maxFailedShards, err)
+		}
+
+		if partials := esClient.PartialResponses(); partials != scenario.expectedPartials {
+			t.Errorf("Expected partial responses to be '%v', got '%v'", scenario.expectedPartials, partials)
+		}
+	}
+}
+
+func TestHooks(t *testing.T) {
+	mockHTTPClient := &MockHTTPClient{}
+	mockHTTPClient.PostResponse.Response = &http.Response{
+		StatusCode: 404,
+		Body:       ioutil.NopCloser(strings.NewReader(`{}`))}
+	mockHTTPClient.DoResponse.Err = errors.New("connection refused")
+
+	var requests []RequestInfo
+	var responses []ResponseInfo
+	hooks := Hooks{
+		OnRequest: func(req RequestInfo) { requests = append(requests, req) },
+		OnResponse: func(req RequestInfo, resp ResponseInfo) {
+			if req != requests[len(requests)-1] {
+				t.Errorf("Expected response of '%v', got '%v'", requests[len(requests)-1], req)
+			}
+
+			responses = append(responses, resp)
+		},
+	}
+
+	esClient, err := NewClient(mockHTTPClient, "http://localhost:9200", "my_index", "", "", "1m", WithHooks(hooks))
+
+	if err != nil {
+		t.Fatalf("Failed to create Client: %v", err)
+	}
+
+	esClient.Scroll("aScrollId")
+	esClient.Count(map[string]interface{}{})
+
+	expectedRequests := []RequestInfo{
+		{"scroll", "POST", "http://localhost:9200/_search/scroll"},
+		{"count", "POST", "http://localhost:9200/my_index/_count"},
+	}
+
+	if len(requests) != len(expectedRequests) || requests[0] != expectedRequests[0] || requests[1] != expectedRequests[1] {
+		t.Fatalf("Expected requests to be '%v', got '%v'", expectedRequests, requests)
+	}
+
+	if len(responses) != 2 {
+		t.Fatalf("Expected %v responses, got %v", 2, len(responses))
+	}
+
+	if responses[0].StatusCode != 404 || responses[0].Err != nil {
+		t.Errorf("Expected response with status 404 and no error, got '%v'", responses[0])
+	}
+
+	if responses[1].StatusCode != 0 || responses[1].Err != mockHTTPClient.DoResponse.Err {
+		t.Errorf("Expected response without status and with error '%v', got '%v'", mockHTTPClient.DoResponse.Err, responses[1])
+	}
+}
+
+func TestSearchWithRouting(t *testing.T) {
+	mockHTTPClient := &MockHTTPClient{}
+	mockHTTPClient.PostResponse.Response = &http.Response{
+		StatusCode: 200,
+		Body:       ioutil.NopCloser(strings.NewReader(`{}`))}
+
+	esClient, err := NewClient(mockHTTPClient, "http://localhost:9200", "my_index", "", "my_routing", "1m")
+
+	if err != nil {
+		t.Fatalf("Failed to create Client: %v", err)
+	}
+
+	esClient.SearchWithRouting(map[string]interface{}{}, "a,b")
+	expectedURL := "http://localhost:9200/my_index/_search?routing=a%2Cb&scroll=1m"
+
+	if mockHTTPClient.PostArgsReceived.URL != expectedURL {
+		t.Errorf("Expected url to be '%v', but got '%v'", expectedURL, mockHTTPClient.PostArgsReceived.URL)
+	}
+}
+
+func TestFilterPath(t *testing.T) {
+	mockHTTPClient := &MockHTTPClient{}
+	mockHTTPClient.PostResponse.Response = &http.Response{
+		StatusCode: 200,
+		Body:       ioutil.NopCloser(strings.NewReader(`{}`))}
+
+	esClient, err := NewClient(mockHTTPClient, "http://localhost:9200", "my_index", "", "", "1m", WithFilterPath("_id", "_source"))
+
+	if err != nil {
+		t.Fatalf("Failed to create Client: %v", err)
+	}
+
+	filterPath := "filter_path=_scroll_id%2Ctook%2C_shards%2Chits.total%2Cerror%2Cstatus%2Chits.hits._id%2Chits.hits._source"
+
+	esClient.Search(map[string]interface{}{})
+	expectedURL := "http://localhost:9200/my_index/_search?" + filterPath + "&scroll=1m"
+
+	if mockHTTPClient.PostArgsReceived.URL != expectedURL {
+		t.Errorf("Expected url to be '%v', but got '%v'", expectedURL, mockHTTPClient.PostArgsReceived.URL)
+	}
+
+	mockHTTPClient.PostResponse.Response.Body = ioutil.NopCloser(strings.NewReader(`{}`))
+	esClient.Scroll("aScrollId")
+	expectedURL = "http://localhost:9200/_search/scroll?" + filterPath
+
+	if mockHTTPClient.PostArgsReceived.URL != expectedURL {
+		t.Errorf("Expected url to be '%v', but got '%v'", expectedURL, mockHTTPClient.PostArgsReceived.URL)
+	}
+}
+
+// filterResponse keeps the top level keys of body named by the filter_path of
+// requestURL, as ES does for error responses
+func filterResponse(t *testing.T, requestURL string, body string) string {
+	u, err := url.Parse(requestURL)
+
+	if err != nil {
+		t.Fatalf("Failed to parse url: %v", err)
+	}
+
+	var response map[string]json.RawMessage
+
+	if err := json.Unmarshal([]byte(body), &response); err != nil {
+		t.Fatalf("Failed to decode body: %v", err)
+	}
+
+	filtered := map[string]json.RawMessage{}
+
+	for _, path := range strings.Split(u.Query().Get("filter_path"), ",") {
+		key := strings.Split(path, ".")[0]
+
+		if value, ok := response[key]; ok && key == path {
+			filtered[key] = value
+		}
+	}
+
+	filteredBody, _ := json.Marshal(filtered)
+	return string(filteredBody)
+}
+
+func TestFilterPathKeepsErrors(t *testing.T) {
+	mockHTTPClient := &MockHTTPClient{}
+	mockHTTPClient.PostResponse.Response = &http.Response{
+		StatusCode: 200,
+		Body:       ioutil.NopCloser(strings.NewReader(`{}`))}
+
+	esClient, _ := NewClient(mockHTTPClient, "http://localhost:9200", "my_index", "", "", "1m", WithFilterPath("_id", "_source"))
+	esClient.Scroll("aScrollId")
+
+	body := filterResponse(t, mockHTTPClient.PostArgsReceived.URL, `{"error":{"root_cause":[],"type":"parsing_exception","reason":"Unknown key [foo]"},"status":400}`)
+	mockHTTPClient.PostResponse.Response = &http.Response{
+		StatusCode: 400,
+		Body:       ioutil.NopCloser(strings.NewReader(body))}
+
+	_, err := esClient.Scroll("aScrollId")
+
+	var statusErr *HTTPStatusError
+
+	if !errors.As(err, &statusErr) {
+		t.Fatalf("Expected error to be a HTTPStatusError, got '%v'", err)
+	}
+
+	if statusErr.Type != "parsing_exception" || statusErr.Reason != "Unknown key [foo]" {
+		t.Errorf("Expected the error of the filtered body '%v' to be parsed, got '%v'", body, err)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/alissonsales/esexport/features"
+)
+
+// version is set at build time by goreleaser
+var version = "dev"
+
+// commands are the subcommands accepted as first argument, e.g.
+// "esexport self-update". Without one esexport runs an export. Optional
+// commands add themselves from the init function of their file.
+var commands = map[string]func(args []string) int{
+	"capabilities": capabilities,
+}
+
+// capabilities lists the features compiled into the binary, as JSON with
+// -json so orchestration systems can check a binary supports a job spec
+func capabilities(args []string) int {
+	fs := flag.NewFlagSet("esexport capabilities", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "Print the capabilities as JSON")
+	fs.Parse(args)
+
+	if !*asJSON {
+		printFeatures()
+		return 0
+	}
+
+	report := struct {
+		Version  string             `json:"version"`
+		OS       string             `json:"os"`
+		Arch     string             `json:"arch"`
+		Features []features.Feature `json:"features"`
+	}{version, runtime.GOOS, runtime.GOARCH, features.List()}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+
+	if err := encoder.Encode(report); err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to encode capabilities:", err)
+		return 1
+	}
+
+	return 0
+}
+
+func commandNames() []string {
+	names := make([]string, 0, len(commands))
+
+	for name := range commands {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+func printFeatures() {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+
+	for _, f := range features.List() {
+		fmt.Fprintf(w, "%v\t%v\t%v\n", f.Kind, f.Name, f.Description)
+	}
+
+	w.Flush()
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/alissonsales/esexport/features"
+)
+
+func init() {
+	features.Register("compression", "gzip", "Compresses the output with gzip (-compress gzip)")
+}
+
+// compressor compresses output files, the level 0 standing for the default
+// level of the codec
+type compressor struct {
+	// extension is added to the name of the files written by esexport
+	// (partition files), -output is left as given
+	extension string
+	newWriter func(w io.Writer, level int) (io.WriteCloser, error)
+}
+
+// compressors are the codecs of -compress by name, those with extra
+// dependencies register themselves when compiled in
+var compressors = map[string]compressor{
+	"gzip": {".gz", func(w io.Writer, level int) (io.WriteCloser, error) {
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+
+		return gzip.NewWriterLevel(w, level)
+	}},
+}
+
+// lookupCompressor returns the compressor of -compress, nil meaning no
+// compression
+func lookupCompressor(name string) (*compressor, error) {
+	if name == "" || name == "none" {
+		return nil, nil
+	}
+
+	c, ok := compressors[name]
+
+	if !ok {
+		var names []string
+
+		for n := range compressors {
+			names = append(names, n)
+		}
+
+		sort.Strings(names)
+		return nil, fmt.Errorf("Unknown -compress %v (expected none, %v)", name, strings.Join(names, ", "))
+	}
+
+	return &c, nil
+}
+
+// multiCloser closes every closer in order, returning the first error
+type multiCloser []io.Closer
+
+func (m multiCloser) Close() error {
+	var err error
+
+	for _, c := range m {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,43 @@
+//go:build !minimal
+// +build !minimal
+
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/alissonsales/esexport/features"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+func init() {
+	features.Register("compression", "zstd", "Compresses the output with Zstandard (-compress zstd)")
+	features.Register("compression", "lz4", "Compresses the output with LZ4 (-compress lz4)")
+
+	compressors["zstd"] = compressor{".zst", func(w io.Writer, level int) (io.WriteCloser, error) {
+		if level == 0 {
+			level = 3
+		}
+
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+	}}
+
+	compressors["lz4"] = compressor{".lz4", func(w io.Writer, level int) (io.WriteCloser, error) {
+		levels := []lz4.CompressionLevel{lz4.Fast, lz4.Level1, lz4.Level2, lz4.Level3, lz4.Level4, lz4.Level5,
+			lz4.Level6, lz4.Level7, lz4.Level8, lz4.Level9}
+
+		if level < 0 || level >= len(levels) {
+			return nil, fmt.Errorf("Invalid lz4 level %v (expected 0 to 9)", level)
+		}
+
+		zw := lz4.NewWriter(w)
+
+		if err := zw.Apply(lz4.CompressionLevelOption(levels[level])); err != nil {
+			return nil, err
+		}
+
+		return zw, nil
+	}}
+}
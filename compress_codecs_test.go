@@ -0,0 +1,77 @@
+//go:build !minimal
+// +build !minimal
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+func TestCompressorsRoundTrip(t *testing.T) {
+	content := strings.Repeat("{\"_id\":\"1\",\"_source\":{\"name\":\"esexport\"}}\n", 1000)
+
+	scenarios := []struct {
+		name      string
+		level     int
+		newReader func(r io.Reader) (io.Reader, error)
+	}{
+		{"zstd", 0, func(r io.Reader) (io.Reader, error) { return zstd.NewReader(r) }},
+		{"zstd", 19, func(r io.Reader) (io.Reader, error) { return zstd.NewReader(r) }},
+		{"lz4", 0, func(r io.Reader) (io.Reader, error) { return lz4.NewReader(r), nil }},
+		{"lz4", 9, func(r io.Reader) (io.Reader, error) { return lz4.NewReader(r), nil }},
+	}
+
+	for _, scenario := range scenarios {
+		c, err := lookupCompressor(scenario.name)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var compressed bytes.Buffer
+		w, err := c.newWriter(&compressed, scenario.level)
+
+		if err != nil {
+			t.Fatalf("Failed to create the %v writer (level %v): %v", scenario.name, scenario.level, err)
+		}
+
+		if _, err := io.WriteString(w, content); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if compressed.Len() >= len(content) {
+			t.Errorf("Expected %v (level %v) to compress %v bytes, got %v", scenario.name, scenario.level, len(content), compressed.Len())
+		}
+
+		r, err := scenario.newReader(&compressed)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if b, err := ioutil.ReadAll(r); err != nil || string(b) != content {
+			t.Errorf("Expected %v (level %v) to decompress what was written, got %v bytes and %v", scenario.name, scenario.level, len(b), err)
+		}
+	}
+}
+
+func TestLz4RejectsInvalidLevel(t *testing.T) {
+	c, _ := lookupCompressor("lz4")
+
+	for _, level := range []int{-1, 10} {
+		if _, err := c.newWriter(ioutil.Discard, level); err == nil {
+			t.Errorf("Expected lz4 level %v to be refused", level)
+		}
+	}
+}
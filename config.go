@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/alissonsales/esexport/features"
+	yaml "gopkg.in/yaml.v3"
+)
+
+func init() {
+	features.Register("config", "yaml", "Reads flag values and profiles from a YAML file (-config)")
+}
+
+// configFile holds flag values loaded from a YAML file. Keys are flag names
+// and "profiles" holds named sets of values overriding the top level ones.
+type configFile struct {
+	values   map[string]interface{}
+	profiles map[string]map[string]interface{}
+}
+
+func loadConfigFile(path string) (*configFile, error) {
+	content, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var raw map[string]interface{}
+
+	if err := yaml.Unmarshal(content, &raw); err != nil {
+		return nil, fmt.Errorf("Error parsing config file %v: %v", path, err)
+	}
+
+	cfg := &configFile{values: raw, profiles: map[string]map[string]interface{}{}}
+
+	if profiles, ok := raw["profiles"]; ok {
+		delete(raw, "profiles")
+		p, ok := profiles.(map[string]interface{})
+
+		if !ok {
+			return nil, fmt.Errorf("Error parsing config file %v: profiles must be a mapping", path)
+		}
+
+		for name, values := range p {
+			v, ok := values.(map[string]interface{})
+
+			if !ok {
+				return nil, fmt.Errorf("Error parsing config file %v: profile %v must be a mapping", path, name)
+			}
+
+			cfg.profiles[name] = v
+		}
+	}
+
+	return cfg, nil
+}
+
+// apply sets every flag present in the config file (and in the given
+// profile) that was not explicitly passed on the command line.
+func (c *configFile) apply(fs *flag.FlagSet, profile string) error {
+	values := map[string]interface{}{}
+
+	for k, v := range c.values {
+		values[k] = v
+	}
+
+	if profile != "" {
+		p, ok := c.profiles[profile]
+
+		if !ok {
+			return fmt.Errorf("Profile %v not found in config file", profile)
+		}
+
+		for k, v := range p {
+			values[k] = v
+		}
+	}
+
+	explicit := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	for name, value := range values {
+		if fs.Lookup(name) == nil || name == "config" || name == "profile" {
+			return fmt.Errorf("Unknown config option: %v", name)
+		}
+
+		if explicit[name] {
+			continue
+		}
+
+		if items, ok := value.([]interface{}); ok {
+			if _, isList := fs.Lookup(name).Value.(*stringList); isList {
+				for _, item := range items {
+					s, err := configValue(item)
+
+					if err == nil {
+						err = fs.Set(name, s)
+					}
+
+					if err != nil {
+						return fmt.Errorf("Invalid value for %v: %v", name, err)
+					}
+				}
+
+				continue
+			}
+		}
+
+		s, err := configValue(value)
+
+		if err != nil {
+			return fmt.Errorf("Invalid value for %v: %v", name, err)
+		}
+
+		if err := fs.Set(name, s); err != nil {
+			return fmt.Errorf("Invalid value for %v: %v", name, err)
+		}
+	}
+
+	return nil
+}
+
+// configValue converts a YAML value to its flag representation. Mappings and
+// sequences (e.g. a query written as YAML) are converted to JSON.
+func configValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case map[string]interface{}, []interface{}:
+		j, err := json.Marshal(v)
+		return string(j), err
+	case map[interface{}]interface{}:
+		// Mappings with keys other than strings, e.g. slice ids
+		m := make(map[string]interface{}, len(v))
+
+		for k, item := range v {
+			m[fmt.Sprint(k)] = item
+		}
+
+		return configValue(m)
+	case nil:
+		return "", nil
+	default:
+		return fmt.Sprint(v), nil
+	}
+}
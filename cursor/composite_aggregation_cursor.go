@@ -0,0 +1,103 @@
+package cursor
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/alissonsales/esexport/client"
+)
+
+// ErrInvalidAggregation is returned when the query doesn't have a single
+// composite aggregation at the top level
+var ErrInvalidAggregation = errors.New("Query must have a single composite aggregation")
+
+// AggregationClient is used to run aggregations on Elasticsearch
+type AggregationClient interface {
+	Aggregate(searchBody map[string]interface{}) (*client.ESAggregationResponse, error)
+}
+
+// CompositeAggregationCursor pages through the buckets of a composite
+// aggregation, requesting every page after the after_key of the previous one
+type CompositeAggregationCursor struct {
+	client     AggregationClient
+	query      map[string]interface{}
+	name       string
+	composite  map[string]interface{}
+	done       bool
+	NumBuckets int
+}
+
+// NewCompositeAggregationCursor returns a CompositeAggregationCursor over the
+// composite aggregation of the query, which no hits are requested for
+func NewCompositeAggregationCursor(client AggregationClient, query map[string]interface{}) (*CompositeAggregationCursor, error) {
+	aggs, ok := query["aggs"].(map[string]interface{})
+
+	if !ok {
+		aggs, ok = query["aggregations"].(map[string]interface{})
+	}
+
+	if !ok || len(aggs) != 1 {
+		return nil, ErrInvalidAggregation
+	}
+
+	for name, agg := range aggs {
+		a, _ := agg.(map[string]interface{})
+		composite, ok := a["composite"].(map[string]interface{})
+
+		if !ok {
+			return nil, ErrInvalidAggregation
+		}
+
+		query["size"] = 0
+		return &CompositeAggregationCursor{client: client, query: query, name: name, composite: composite}, nil
+	}
+
+	return nil, ErrInvalidAggregation
+}
+
+// Next returns the next page of buckets
+//
+// Returns an empty array if there are no more buckets to be returned
+func (cac *CompositeAggregationCursor) Next() ([]map[string]interface{}, error) {
+	if cac.done {
+		return nil, nil
+	}
+
+	resp, err := cac.client.Aggregate(cac.query)
+
+	if err != nil {
+		return nil, err
+	}
+
+	raw, ok := resp.Aggregations[cac.name]
+
+	if !ok {
+		return nil, fmt.Errorf("Aggregation %v missing from the response", cac.name)
+	}
+
+	var agg struct {
+		AfterKey map[string]interface{}   `json:"after_key"`
+		Buckets  []map[string]interface{} `json:"buckets"`
+	}
+
+	// Numbers are kept as they were returned, keys can be longs too large
+	// for a float64
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+
+	if err := decoder.Decode(&agg); err != nil {
+		return nil, fmt.Errorf("Error decoding aggregation %v: %v", cac.name, err)
+	}
+
+	cac.NumBuckets += len(agg.Buckets)
+
+	if len(agg.Buckets) == 0 || agg.AfterKey == nil {
+		cac.done = true
+	} else {
+		cac.composite["after"] = agg.AfterKey
+	}
+
+	return agg.Buckets, nil
+}
@@ -0,0 +1,113 @@
+package cursor
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/alissonsales/esexport/client"
+)
+
+// MockAggregationClient returns the aggregation responses in order,
+// recording the after key of every request
+type MockAggregationClient struct {
+	Responses         []string
+	AfterKeysReceived []string
+}
+
+func (m *MockAggregationClient) Aggregate(searchBody map[string]interface{}) (*client.ESAggregationResponse, error) {
+	composite := searchBody["aggs"].(map[string]interface{})["by_day"].(map[string]interface{})["composite"].(map[string]interface{})
+	after, _ := json.Marshal(composite["after"])
+	m.AfterKeysReceived = append(m.AfterKeysReceived, string(after))
+
+	if len(m.Responses) == 0 {
+		return nil, errors.New("No more responses")
+	}
+
+	var resp client.ESAggregationResponse
+	err := json.NewDecoder(strings.NewReader(m.Responses[0])).Decode(&resp)
+	m.Responses = m.Responses[1:]
+
+	return &resp, err
+}
+
+func TestNewCompositeAggregationCursorWithInvalidQuery(t *testing.T) {
+	scenarios := []struct {
+		query string
+		err   error
+	}{
+		{`{"aggs":{"by_day":{"composite":{"sources":[]}}}}`, nil},
+		{`{"aggregations":{"by_day":{"composite":{"sources":[]}}}}`, nil},
+		{`{}`, ErrInvalidAggregation},
+		{`{"aggs":{"by_day":{"terms":{"field":"day"}}}}`, ErrInvalidAggregation},
+		{`{"aggs":{"a":{"composite":{}},"b":{"composite":{}}}}`, ErrInvalidAggregation},
+	}
+
+	for _, scenario := range scenarios {
+		var query map[string]interface{}
+		json.Unmarshal([]byte(scenario.query), &query)
+
+		_, err := NewCompositeAggregationCursor(&MockAggregationClient{}, query)
+
+		if !errors.Is(err, scenario.err) {
+			t.Errorf("Expected error for %v to be '%v', got '%v'", scenario.query, scenario.err, err)
+		}
+	}
+}
+
+func TestCompositeAggregationNext(t *testing.T) {
+	mockClient := &MockAggregationClient{Responses: []string{
+		`{"aggregations":{"by_day":{"after_key":{"day":1},"buckets":[{"key":{"day":1},"doc_count":3}]}}}`,
+		`{"aggregations":{"by_day":{"after_key":{"day":9007199254740993},"buckets":[{"key":{"day":9007199254740993},"doc_count":1}]}}}`,
+		`{"aggregations":{"by_day":{"buckets":[]}}}`,
+	}}
+
+	var query map[string]interface{}
+	json.Unmarshal([]byte(`{"aggs":{"by_day":{"composite":{"sources":[{"day":{"terms":{"field":"day"}}}]}}}}`), &query)
+
+	cac, err := NewCompositeAggregationCursor(mockClient, query)
+
+	if err != nil {
+		t.Fatalf("Failed to create CompositeAggregationCursor: %v", err)
+	}
+
+	var buckets []string
+
+	for {
+		page, err := cac.Next()
+
+		if err != nil {
+			t.Fatalf("Failed to retrieve next page of buckets: %v", err)
+		}
+
+		if len(page) == 0 {
+			break
+		}
+
+		for _, bucket := range page {
+			b, _ := json.Marshal(bucket)
+			buckets = append(buckets, string(b))
+		}
+	}
+
+	expectedBuckets := `{"doc_count":3,"key":{"day":1}} {"doc_count":1,"key":{"day":9007199254740993}}`
+
+	if strings.Join(buckets, " ") != expectedBuckets {
+		t.Errorf("Expected buckets to be '%v', got '%v'", expectedBuckets, strings.Join(buckets, " "))
+	}
+
+	expectedAfterKeys := `null {"day":1} {"day":9007199254740993}`
+
+	if strings.Join(mockClient.AfterKeysReceived, " ") != expectedAfterKeys {
+		t.Errorf("Expected after keys to be '%v', got '%v'", expectedAfterKeys, strings.Join(mockClient.AfterKeysReceived, " "))
+	}
+
+	if cac.NumBuckets != 2 {
+		t.Errorf("Expected number of buckets to be %v, got %v", 2, cac.NumBuckets)
+	}
+
+	if query["size"] != 0 {
+		t.Errorf("Expected query size to be 0, got '%v'", query["size"])
+	}
+}
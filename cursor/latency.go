@@ -0,0 +1,102 @@
+package cursor
+
+import (
+	"math"
+	"time"
+)
+
+// latencyBucketsPerDoubling is the resolution of the latency histogram, each
+// bucket being about 9% wider than the previous one
+const latencyBucketsPerDoubling = 8
+
+// latencyHistogram keeps the statistics of request latencies in constant
+// space, however many pages a cursor returns. Percentiles are approximated
+// by the upper bound of their bucket.
+type latencyHistogram struct {
+	count   int
+	sum     time.Duration
+	min     time.Duration
+	max     time.Duration
+	buckets [latencyBucketsPerDoubling*40 + 2]int
+}
+
+func (h *latencyHistogram) add(latency time.Duration) {
+	if h.count == 0 || latency < h.min {
+		h.min = latency
+	}
+
+	if latency > h.max {
+		h.max = latency
+	}
+
+	h.count++
+	h.sum += latency
+	h.buckets[h.bucket(latency)]++
+}
+
+// merge adds the latencies of o
+func (h *latencyHistogram) merge(o *latencyHistogram) {
+	if o.count == 0 {
+		return
+	}
+
+	if h.count == 0 || o.min < h.min {
+		h.min = o.min
+	}
+
+	if o.max > h.max {
+		h.max = o.max
+	}
+
+	h.count += o.count
+	h.sum += o.sum
+
+	for i, n := range o.buckets {
+		h.buckets[i] += n
+	}
+}
+
+// bucket returns the bucket of a latency, the first holding those under a
+// microsecond and the last those over about 12 days
+func (h *latencyHistogram) bucket(latency time.Duration) int {
+	us := float64(latency) / float64(time.Microsecond)
+
+	if us < 1 {
+		return 0
+	}
+
+	return int(math.Min(math.Log2(us)*latencyBucketsPerDoubling+1, float64(len(h.buckets)-1)))
+}
+
+// upperBound returns the latency the bucket holds latencies up to, rounded
+// up to the nanosecond
+func (h *latencyHistogram) upperBound(bucket int) time.Duration {
+	return time.Duration(math.Ceil(math.Exp2(float64(bucket)/latencyBucketsPerDoubling) * float64(time.Microsecond)))
+}
+
+func (h *latencyHistogram) avg() time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+
+	return h.sum / time.Duration(h.count)
+}
+
+// percentile returns the nearest-rank percentile p (0 < p <= 100) of the
+// latencies, at most the highest latency
+func (h *latencyHistogram) percentile(p float64) time.Duration {
+	rank := int(math.Ceil(p / 100 * float64(h.count)))
+	seen := 0
+
+	for bucket, n := range h.buckets {
+		if seen += n; seen >= rank && n > 0 {
+			if bound := h.upperBound(bucket); bound < h.max {
+				return bound
+			}
+
+			break
+		}
+	}
+
+	return h.max
+}
@@ -0,0 +1,79 @@
+package cursor
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLatencyHistogram(t *testing.T) {
+	scenarios := []struct {
+		latencies []time.Duration
+		min       time.Duration
+		avg       time.Duration
+		p99       time.Duration
+	}{
+		{[]time.Duration{time.Second}, time.Second, time.Second, time.Second},
+		{[]time.Duration{10 * time.Millisecond, 30 * time.Millisecond}, 10 * time.Millisecond, 20 * time.Millisecond, 30 * time.Millisecond},
+		{[]time.Duration{0, 0, 0}, 0, 0, 0},
+		{[]time.Duration{4446, 4446}, 4446, 4446, 4446},
+		{[]time.Duration{540, 1872}, 540, 1206, 1872},
+	}
+
+	for _, scenario := range scenarios {
+		var h latencyHistogram
+
+		for _, l := range scenario.latencies {
+			h.add(l)
+		}
+
+		if h.min != scenario.min || h.avg() != scenario.avg || h.percentile(99) != scenario.p99 {
+			t.Errorf("Expected %v to have min %v, avg %v and p99 %v, got %v, %v and %v",
+				scenario.latencies, scenario.min, scenario.avg, scenario.p99, h.min, h.avg(), h.percentile(99))
+		}
+	}
+}
+
+func TestLatencyHistogramPercentileRank(t *testing.T) {
+	var h latencyHistogram
+
+	// 99 fast pages and a slow one: the slow one is the 100th percentile,
+	// the 99th is among the fast ones (nearest rank ceil(0.99*100) = 99)
+	for i := 0; i < 99; i++ {
+		h.add(10 * time.Millisecond)
+	}
+
+	h.add(10 * time.Second)
+
+	if p99 := h.percentile(99); p99 < 10*time.Millisecond || p99 > 11*time.Millisecond {
+		t.Errorf("Expected p99 to be about 10ms, got %v", p99)
+	}
+
+	h.add(10 * time.Second)
+
+	// With 101 pages, ceil(0.99*101) = 100 is a slow one
+	if p99 := h.percentile(99); p99 != 10*time.Second {
+		t.Errorf("Expected p99 to be 10s, got %v", p99)
+	}
+}
+
+func TestLatencyHistogramMerge(t *testing.T) {
+	var all, first, second latencyHistogram
+
+	for i, l := range []time.Duration{30 * time.Millisecond, 10 * time.Millisecond, time.Second, 20 * time.Millisecond} {
+		all.add(l)
+
+		if i < 2 {
+			first.add(l)
+		} else {
+			second.add(l)
+		}
+	}
+
+	var merged latencyHistogram
+	merged.merge(&first)
+	merged.merge(&second)
+
+	if merged != all {
+		t.Errorf("Expected the merged latencies to be %+v, got %+v", all, merged)
+	}
+}
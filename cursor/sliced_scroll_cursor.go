@@ -4,97 +4,371 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"os"
+	"sync"
+	"time"
 
 	"github.com/alissonsales/esexport/client"
 	"github.com/alissonsales/esexport/debug"
 )
 
+// ErrInvalidSlice is returned when the slice id isn't lower than the number
+// of slices
+var ErrInvalidSlice = errors.New("Max must be greater than id")
+
 // ElasticsearchClient is used to search and scroll documents from Elasticsearch
 type ElasticsearchClient interface {
 	Scroll(scrollID string) (*client.ESSearchResponse, error)
 	Search(searchBody map[string]interface{}) (*client.ESSearchResponse, error)
 }
 
+// StreamingClient is an ElasticsearchClient able to hand the hits of a page
+// one at a time, as they are decoded, instead of returning them together
+// (see client.Client.SearchEach)
+type StreamingClient interface {
+	ElasticsearchClient
+	SearchEach(searchBody map[string]interface{}, hit func(*client.Hit) error) (*client.ESSearchResponse, error)
+	ScrollEach(scrollID string, hit func(*client.Hit) error) (*client.ESSearchResponse, error)
+}
+
+// SearchEach searches with c, handing the hits to hit one at a time. Clients
+// which aren't StreamingClients return the whole page first.
+func SearchEach(c ElasticsearchClient, searchBody map[string]interface{}, hit func(*client.Hit) error) (*client.ESSearchResponse, error) {
+	if sc, ok := c.(StreamingClient); ok {
+		return sc.SearchEach(searchBody, hit)
+	}
+
+	resp, err := c.Search(searchBody)
+	return handEach(resp, err, hit)
+}
+
+// ScrollEach scrolls with c, handing the hits to hit one at a time like
+// SearchEach
+func ScrollEach(c ElasticsearchClient, scrollID string, hit func(*client.Hit) error) (*client.ESSearchResponse, error) {
+	if sc, ok := c.(StreamingClient); ok {
+		return sc.ScrollEach(scrollID, hit)
+	}
+
+	resp, err := c.Scroll(scrollID)
+	return handEach(resp, err, hit)
+}
+
+// handEach hands the hits of a whole page to hit, the response counting them
+// as streamed
+func handEach(resp *client.ESSearchResponse, err error, hit func(*client.Hit) error) (*client.ESSearchResponse, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range resp.Hits.Hits {
+		if err := hit(&resp.Hits.Hits[i]); err != nil {
+			return nil, err
+		}
+
+		resp.Streamed++
+	}
+
+	resp.Hits.Hits = nil
+	return resp, nil
+}
+
 // SlicedScrollCursor implements a way to search and scroll documents from Elasticsearch using slices
 type SlicedScrollCursor struct {
-	client           ElasticsearchClient
-	query            map[string]interface{}
-	sliceID          int
-	sliceMax         int
-	sliceField       string
-	Total            *int
-	NumDocsRetrieved *int
-	lastScrollID     string
+	client       ElasticsearchClient
+	query        map[string]interface{}
+	sliceID      int
+	sliceMax     int
+	sliceField   string
+	lastScrollID string
+	// pageSize overrides the query size after a response was too large
+	pageSize int
+
+	// mu guards the statistics, read by Stats while Next runs
+	mu        sync.Mutex
+	started   bool
+	total     int
+	retrieved int
+	exhausted bool
+	bytes     int64
+	took      time.Duration
+	retries   int
+	latencies latencyHistogram
+	lastErr   error
+}
+
+// Stats describes the progress of a cursor
+type Stats struct {
+	// Expected is the number of documents the search reported as matching
+	Expected int
+	// Retrieved is the number of documents returned so far. It may end up
+	// different from Expected when documents are added or deleted while
+	// exporting.
+	Retrieved int
+	// Done is set once the scroll returned no more documents
+	Done bool
+	// Pages is the number of pages returned (the search and the scrolls).
+	// Like the statistics below, it includes the requests of the cursors
+	// the cursor continues (see Continue).
+	Pages int
+	// Bytes is the size of the responses (decompressed)
+	Bytes int64
+	// Took is the time ES reports having spent on the pages
+	Took time.Duration
+	// Retries is the number of requests sent again, the first page being
+	// requested again with a smaller size when too large
+	Retries int
+	// MinLatency, AvgLatency and P99Latency are the durations of the
+	// requests of the pages returned, P99Latency being rounded up by at
+	// most about 9%
+	MinLatency time.Duration
+	AvgLatency time.Duration
+	P99Latency time.Duration
+	// LastError is the error of the last request that failed, if any
+	LastError error
 }
 
 // NewSlicedScrollCursor returns a SliceScrollCursor
 func NewSlicedScrollCursor(client ElasticsearchClient, id, max int, field string, query map[string]interface{}) (*SlicedScrollCursor, error) {
 	if max >= 2 && id >= max {
-		return nil, errors.New("Max must be greater than id")
+		return nil, ErrInvalidSlice
 	}
 
 	return &SlicedScrollCursor{client: client, query: query, sliceID: id, sliceMax: max, sliceField: field}, nil
 }
 
+// ResumeSlicedScrollCursor returns a SlicedScrollCursor continuing the scroll
+// of slice id, which retrieved documents so far out of total, when an
+// export died while ES still keeps the scroll. A total of 0 is taken from
+// the first page.
+func ResumeSlicedScrollCursor(client ElasticsearchClient, id int, scrollID string, retrieved, total int) *SlicedScrollCursor {
+	return &SlicedScrollCursor{client: client, sliceID: id, lastScrollID: scrollID, started: true, retrieved: retrieved, total: total}
+}
+
+// Continue adds the statistics of the requests of previous, a cursor of the
+// same slice which failed, to those of ssc searching the slice again. The
+// documents expected and retrieved are those of ssc alone.
+func (ssc *SlicedScrollCursor) Continue(previous *SlicedScrollCursor) {
+	previous.mu.Lock()
+	bytes, took, retries, latencies := previous.bytes, previous.took, previous.retries, previous.latencies
+	previous.mu.Unlock()
+
+	ssc.mu.Lock()
+	defer ssc.mu.Unlock()
+
+	ssc.bytes += bytes
+	ssc.took += took
+	ssc.retries += retries
+	ssc.latencies.merge(&latencies)
+}
+
 // Next returns the next batch of results for the given query
 //
-// Returns an empty array if there are no more documents to be returned
+// Returns an empty array if there are no more documents to be returned,
+// which is known once a page comes back empty (the total reported by the
+// search isn't reliable when the index changes during the export)
 func (ssc *SlicedScrollCursor) Next() (hits []client.Hit, err error) {
-	if ssc.Total == nil {
+	resp, err := ssc.next(nil)
+
+	if resp == nil {
+		return nil, err
+	}
+
+	return resp.Hits.Hits, err
+}
+
+// NextEach hands the hits of the next batch to hit one at a time, as they are
+// decoded when the client is a StreamingClient, and returns their number,
+// 0 once there are no more documents like Next. The error of hit stops the
+// batch and is returned: the cursor can't go on from there, the rest of the
+// batch being lost.
+func (ssc *SlicedScrollCursor) NextEach(hit func(*client.Hit) error) (int, error) {
+	n := 0
+
+	_, err := ssc.next(func(h *client.Hit) error {
+		if err := hit(h); err != nil {
+			return err
+		}
+
+		n++
+		return nil
+	})
+
+	return n, err
+}
+
+// next requests the next batch, the hits being handed to hit if not nil
+func (ssc *SlicedScrollCursor) next(hit func(*client.Hit) error) (resp *client.ESSearchResponse, err error) {
+	ssc.mu.Lock()
+	started, done := ssc.started, ssc.exhausted
+	ssc.mu.Unlock()
+
+	// hitErr is the error of hit, which isn't a failure of the request
+	var hitErr error
+	each := hit
+
+	if hit != nil {
+		each = func(h *client.Hit) error {
+			hitErr = hit(h)
+			return hitErr
+		}
+	}
+
+	if !started {
 		debug.Debug(func() {
 			if jsonBody, err := json.Marshal(ssc.searchQuery()); err == nil {
-				fmt.Printf("Slice %v query: %s\n", ssc.sliceID, jsonBody)
+				fmt.Fprintf(os.Stderr, "Slice %v query: %s\n", ssc.sliceID, jsonBody)
 			}
 		})
-		hits, err = ssc.search()
+		resp, err = ssc.search(each)
 
-		if ssc.Total != nil {
+		if err == nil {
 			debug.Debug(func() {
-				fmt.Printf("Slice %v total: %v\n", ssc.sliceID, *ssc.Total)
+				fmt.Fprintf(os.Stderr, "Slice %v total: %v\n", ssc.sliceID, ssc.Stats().Expected)
 			})
 		}
-	} else if !ssc.done() {
-		hits, err = ssc.scroll(ssc.lastScrollID)
+	} else if !done {
+		resp, err = ssc.scroll(ssc.lastScrollID, each)
 	}
 
-	return hits, err
+	if err != nil {
+		if hitErr == nil {
+			ssc.failed(err)
+		}
+
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// ScrollID returns the scroll id continuing after the last batch returned
+func (ssc *SlicedScrollCursor) ScrollID() string {
+	return ssc.lastScrollID
 }
 
-func (ssc *SlicedScrollCursor) search() (hits []client.Hit, err error) {
-	resp, err := ssc.client.Search(ssc.searchQuery())
+func (ssc *SlicedScrollCursor) search(hit func(*client.Hit) error) (*client.ESSearchResponse, error) {
+	start := time.Now()
+	resp, err := ssc.request(ssc.searchQuery(), hit)
+
+	// The page size is fixed once the scroll starts, so only the first page
+	// can be requested again with a smaller size. Streamed responses aren't
+	// held nor limited (esexport refuses -maxResponseBytes without prefetching).
+	for errors.Is(err, client.ErrResponseTooLarge) && ssc.currentPageSize() > 1 {
+		ssc.pageSize = ssc.currentPageSize() / 2
+		fmt.Fprintf(os.Stderr, "Slice %v: %v, retrying with size %v\n", ssc.sliceID, err, ssc.pageSize)
+
+		ssc.mu.Lock()
+		ssc.retries++
+		ssc.mu.Unlock()
+
+		start = time.Now()
+		resp, err = ssc.request(ssc.searchQuery(), hit)
+	}
 
 	if err != nil {
 		return nil, err
 	}
 
-	ssc.Total = &resp.Hits.Total
-	totalReturned := len(resp.Hits.Hits)
-	ssc.NumDocsRetrieved = &totalReturned
-	ssc.lastScrollID = resp.ScrollID
+	ssc.mu.Lock()
+	ssc.started = true
+	ssc.total = resp.Hits.Total
+	ssc.mu.Unlock()
 
-	return resp.Hits.Hits, err
+	ssc.page(resp, time.Since(start))
+	return resp, nil
+}
+
+// request sends the search, handing the hits to hit if not nil
+func (ssc *SlicedScrollCursor) request(query map[string]interface{}, hit func(*client.Hit) error) (*client.ESSearchResponse, error) {
+	if hit == nil {
+		return ssc.client.Search(query)
+	}
+
+	return SearchEach(ssc.client, query, hit)
 }
 
-func (ssc *SlicedScrollCursor) scroll(id string) (hits []client.Hit, err error) {
-	resp, err := ssc.client.Scroll(id)
+func (ssc *SlicedScrollCursor) scroll(id string, hit func(*client.Hit) error) (resp *client.ESSearchResponse, err error) {
+	start := time.Now()
+
+	if hit == nil {
+		resp, err = ssc.client.Scroll(id)
+	} else {
+		resp, err = ScrollEach(ssc.client, id, hit)
+	}
 
 	if err != nil {
 		return nil, err
 	}
 
-	updatedTotal := len(resp.Hits.Hits) + *ssc.NumDocsRetrieved
-	ssc.NumDocsRetrieved = &updatedTotal
+	ssc.page(resp, time.Since(start))
+	return resp, nil
+}
+
+// page records the page of the response, returned after the latency
+func (ssc *SlicedScrollCursor) page(resp *client.ESSearchResponse, latency time.Duration) {
 	ssc.lastScrollID = resp.ScrollID
 
-	return resp.Hits.Hits, err
+	ssc.mu.Lock()
+	defer ssc.mu.Unlock()
+
+	hits := len(resp.Hits.Hits) + resp.Streamed
+	ssc.retrieved += hits
+
+	// Resumed scrolls may not know their total before the first page
+	if ssc.total == 0 {
+		ssc.total = resp.Hits.Total
+	}
+
+	ssc.exhausted = hits == 0
+	ssc.bytes += resp.Bytes
+	ssc.took += time.Duration(resp.Took) * time.Millisecond
+	ssc.latencies.add(latency)
+}
+
+func (ssc *SlicedScrollCursor) failed(err error) {
+	ssc.mu.Lock()
+	ssc.lastErr = err
+	ssc.mu.Unlock()
 }
 
-func (ssc *SlicedScrollCursor) done() bool {
-	if *ssc.NumDocsRetrieved == *ssc.Total {
-		return true
+// Stats returns the progress of the cursor and the statistics of its
+// requests. It's safe to call while Next runs.
+func (ssc *SlicedScrollCursor) Stats() Stats {
+	ssc.mu.Lock()
+	defer ssc.mu.Unlock()
+
+	stats := Stats{
+		Expected:  ssc.total,
+		Retrieved: ssc.retrieved,
+		Done:      ssc.exhausted,
+		Pages:     ssc.latencies.count,
+		Bytes:     ssc.bytes,
+		Took:      ssc.took,
+		Retries:   ssc.retries,
+		LastError: ssc.lastErr,
+	}
+
+	if ssc.latencies.count > 0 {
+		stats.MinLatency = ssc.latencies.min
+		stats.AvgLatency = ssc.latencies.avg()
+		stats.P99Latency = ssc.latencies.percentile(99)
 	}
 
-	return false
+	return stats
+}
+
+// currentPageSize returns the number of hits requested per page, which
+// defaults to 10 on ES when the query doesn't set it
+func (ssc *SlicedScrollCursor) currentPageSize() int {
+	if ssc.pageSize > 0 {
+		return ssc.pageSize
+	}
+
+	if size, ok := ssc.query["size"].(float64); ok {
+		return int(size)
+	}
+
+	return 10
 }
 
 func (ssc *SlicedScrollCursor) searchQuery() map[string]interface{} {
@@ -104,6 +378,10 @@ func (ssc *SlicedScrollCursor) searchQuery() map[string]interface{} {
 		query[k] = v
 	}
 
+	if ssc.pageSize > 0 {
+		query["size"] = ssc.pageSize
+	}
+
 	if ssc.sliceMax > 1 {
 		slice := map[string]interface{}{}
 		slice["id"] = ssc.sliceID
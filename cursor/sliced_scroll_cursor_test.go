@@ -25,10 +25,19 @@ type MockElasticSearchClient struct {
 		Response *client.ESSearchResponse
 		Err      error
 	}
+	// ScrollReturns are returned in order before ScrollReturn
+	ScrollReturns []*client.ESSearchResponse
 }
 
 func (m *MockElasticSearchClient) Scroll(scrollID string) (*client.ESSearchResponse, error) {
 	m.ScrollArgsReceived.ScrollID = scrollID
+
+	if len(m.ScrollReturns) > 0 {
+		resp := m.ScrollReturns[0]
+		m.ScrollReturns = m.ScrollReturns[1:]
+		return resp, nil
+	}
+
 	return m.ScrollReturn.Response, m.ScrollReturn.Err
 }
 
@@ -46,14 +55,14 @@ func TestNewSlicedScrollCursorWithInvalidArgs(t *testing.T) {
 	}{
 		{0, 0, nil},
 		{0, 1, nil},
-		{2, 2, errors.New("Max must be greater than id")},
-		{3, 2, errors.New("Max must be greater than id")},
+		{2, 2, ErrInvalidSlice},
+		{3, 2, ErrInvalidSlice},
 	}
 
 	for _, scenario := range scenarios {
 		_, err := NewSlicedScrollCursor(mockClient, scenario.id, scenario.max, "", map[string]interface{}{})
 
-		if err != nil && err.Error() != scenario.err.Error() {
+		if !errors.Is(err, scenario.err) {
 			t.Errorf("Expected error to be '%v', got '%v'", scenario.err, err)
 		}
 	}
@@ -118,73 +127,234 @@ func TestNextSliceBody(t *testing.T) {
 }
 
 func TestNext(t *testing.T) {
-	mockClient := &MockElasticSearchClient{}
-	searchResponse := &client.ESSearchResponse{
-		ScrollID: "aScrollId",
-		Hits: client.Hits{
-			Total: 1,
-			Hits:  []client.Hit{client.Hit{ID: "docId", Source: map[string]interface{}{}}},
-		},
-	}
-	mockClient.SearchReturn.Response = searchResponse
+	response := func(total, numHits int) *client.ESSearchResponse {
+		hits := make([]client.Hit, numHits)
 
-	ssc, err := NewSlicedScrollCursor(mockClient, 1, 2, "", map[string]interface{}{})
+		for i := range hits {
+			hits[i] = client.Hit{ID: "docId", Source: map[string]interface{}{}}
+		}
 
-	if err != nil {
-		t.Fatalf("Failed to create SlicedScrollCursor: %v", err)
+		return &client.ESSearchResponse{ScrollID: "aScrollId", Hits: client.Hits{Total: total, Hits: hits}}
+	}
+
+	scenarios := []struct {
+		total         int
+		pages         []int
+		expectedCalls int
+		expectedHits  int
+	}{
+		{0, []int{0}, 0, 0},
+		{1, []int{1, 0}, 1, 1},
+		{2, []int{1, 1, 0}, 2, 2},
+		{3, []int{1, 1, 1, 0}, 3, 3},
+		// Documents added while exporting
+		{2, []int{1, 1, 1, 0}, 3, 3},
+		// Documents deleted while exporting
+		{3, []int{1, 0}, 1, 1},
 	}
 
-	exhaustCursor := func(cursor *SlicedScrollCursor) (numCalls, numHits int) {
+	for _, scenario := range scenarios {
+		mockClient := &MockElasticSearchClient{}
+		mockClient.SearchReturn.Response = response(scenario.total, scenario.pages[0])
+
+		for _, numHits := range scenario.pages[1:] {
+			mockClient.ScrollReturns = append(mockClient.ScrollReturns, response(scenario.total, numHits))
+		}
+
+		ssc, err := NewSlicedScrollCursor(mockClient, 1, 2, "", map[string]interface{}{})
+
+		if err != nil {
+			t.Fatalf("Failed to create SlicedScrollCursor: %v", err)
+		}
+
+		numCalls, numHits := 0, 0
+
 		for {
 			hits, err := ssc.Next()
 
 			if err != nil {
-				t.Errorf("Failed to retrieve next batch of hits: %v", err)
+				t.Fatalf("Failed to retrieve next batch of hits: %v", err)
 			}
 
-			if len(hits) > 0 {
-				numCalls++
-				numHits += len(hits)
-			} else {
+			if len(hits) == 0 {
 				break
 			}
+
+			numCalls++
+			numHits += len(hits)
+		}
+
+		if numCalls != scenario.expectedCalls {
+			t.Errorf("Expected number of iteractions to be %v, performed %v", scenario.expectedCalls, numCalls)
+		}
+
+		if numHits != scenario.expectedHits {
+			t.Errorf("Expected number of total hits to be %v, got %v", scenario.expectedHits, numHits)
+		}
+
+		if len(mockClient.ScrollReturns) != 0 {
+			t.Errorf("Expected every page to be scrolled, %v left", len(mockClient.ScrollReturns))
+		}
+
+		expectedStats := Stats{Expected: scenario.total, Retrieved: scenario.expectedHits, Done: true, Pages: scenario.expectedCalls + 1}
+		stats := ssc.Stats()
+
+		if stats.MinLatency > stats.AvgLatency || stats.AvgLatency > stats.P99Latency {
+			t.Errorf("Expected min <= avg <= p99 latencies, got '%+v'", stats)
+		}
+
+		stats.MinLatency, stats.AvgLatency, stats.P99Latency = 0, 0, 0
+
+		if stats != expectedStats {
+			t.Errorf("Expected stats to be '%+v', got '%+v'", expectedStats, stats)
+		}
+	}
+}
+
+// MockSizeLimitedClient fails searches asking for more than MaxSize hits
+type MockSizeLimitedClient struct {
+	MockElasticSearchClient
+	MaxSize       int
+	SizesReceived []interface{}
+}
+
+func (m *MockSizeLimitedClient) Search(searchBody map[string]interface{}) (*client.ESSearchResponse, error) {
+	m.SizesReceived = append(m.SizesReceived, searchBody["size"])
+
+	size, ok := searchBody["size"].(int)
+
+	if !ok {
+		size = 10
+	}
+
+	if size > m.MaxSize {
+		return nil, fmt.Errorf("%w (100 bytes)", client.ErrResponseTooLarge)
+	}
+
+	return &client.ESSearchResponse{Hits: client.Hits{Total: 1, Hits: []client.Hit{{ID: "id"}}}}, nil
+}
+
+func TestNextWithResponseTooLarge(t *testing.T) {
+	scenarios := []struct {
+		maxSize       int
+		expectedSizes string
+		expectedErr   bool
+	}{
+		{10, `[null]`, false},
+		{5, `[null,5]`, false},
+		{2, `[null,5,2]`, false},
+		{0, `[null,5,2,1]`, true},
+	}
+
+	for _, scenario := range scenarios {
+		mockClient := &MockSizeLimitedClient{MaxSize: scenario.maxSize}
+		ssc, _ := NewSlicedScrollCursor(mockClient, 0, 0, "", map[string]interface{}{})
+
+		_, err := ssc.Next()
+
+		if scenario.expectedErr != errors.Is(err, client.ErrResponseTooLarge) {
+			t.Errorf("Unexpected error with max size %v: %v", scenario.maxSize, err)
 		}
-		return
+
+		sizes, _ := json.Marshal(mockClient.SizesReceived)
+
+		if string(sizes) != scenario.expectedSizes {
+			t.Errorf("Expected sizes requested to be '%v', got '%s'", scenario.expectedSizes, sizes)
+		}
+
+		stats := ssc.Stats()
+
+		if stats.Retries != len(mockClient.SizesReceived)-1 {
+			t.Errorf("Expected %v retries, got %v", len(mockClient.SizesReceived)-1, stats.Retries)
+		}
+
+		if stats.LastError != err {
+			t.Errorf("Expected the last error to be '%v', got '%v'", err, stats.LastError)
+		}
+	}
+}
+
+func TestStatsWhileScrolling(t *testing.T) {
+	mockClient := &MockElasticSearchClient{}
+	page := &client.ESSearchResponse{ScrollID: "aScrollId", Hits: client.Hits{Total: 100, Hits: []client.Hit{{ID: "docId"}}}}
+	mockClient.SearchReturn.Response = page
+
+	for i := 0; i < 99; i++ {
+		mockClient.ScrollReturns = append(mockClient.ScrollReturns, page)
 	}
 
-	numCalls, numHits := exhaustCursor(ssc)
+	mockClient.ScrollReturn.Response = &client.ESSearchResponse{ScrollID: "aScrollId", Hits: client.Hits{Total: 100}}
+	ssc, _ := NewSlicedScrollCursor(mockClient, 0, 0, "", map[string]interface{}{})
+	done := make(chan struct{})
+
+	// Progress is read by another goroutine than the one scrolling, which
+	// -race checks
+	go func() {
+		defer close(done)
+
+		for {
+			if hits, err := ssc.Next(); err != nil || len(hits) == 0 {
+				return
+			}
+		}
+	}()
+
+	last := 0
+
+	for finished := false; !finished; {
+		select {
+		case <-done:
+			finished = true
+		default:
+		}
+
+		stats := ssc.Stats()
 
-	if numCalls != 1 {
-		t.Errorf("Expected number of iteractions to be 1, performed %v", numCalls)
+		if stats.Retrieved < last {
+			t.Fatalf("Expected the documents retrieved to only grow, got %v after %v", stats.Retrieved, last)
+		}
+
+		last = stats.Retrieved
+	}
+
+	if stats := ssc.Stats(); stats.Retrieved != 100 || !stats.Done {
+		t.Errorf("Expected 100 documents retrieved and the cursor done, got '%+v'", stats)
 	}
+}
 
-	if numHits != 1 {
-		t.Errorf("Expected number of total hits to be 1, got %v", numHits)
+func TestResumeSlicedScrollCursor(t *testing.T) {
+	mockClient := &MockElasticSearchClient{}
+	mockClient.ScrollReturns = []*client.ESSearchResponse{
+		{ScrollID: "nextScrollId", Hits: client.Hits{Total: 5, Hits: []client.Hit{{ID: "4"}, {ID: "5"}}}},
+		{ScrollID: "nextScrollId", Hits: client.Hits{Total: 5}},
 	}
 
-	searchResponse.Hits.Total = 2
-	mockClient.ScrollReturn.Response = searchResponse
-	ssc, err = NewSlicedScrollCursor(mockClient, 1, 2, "", map[string]interface{}{})
-	numCalls, numHits = exhaustCursor(ssc)
+	ssc := ResumeSlicedScrollCursor(mockClient, 3, "aScrollId", 3, 0)
+	hits, err := ssc.Next()
 
-	if numCalls != 2 {
-		t.Errorf("Expected number of iteractions to be 2, performed %v", numCalls)
+	if err != nil {
+		t.Fatalf("Failed to retrieve next batch of hits: %v", err)
 	}
 
-	if numHits != 2 {
-		t.Errorf("Expected number of total hits to be 2, got %v", numHits)
+	if mockClient.ScrollArgsReceived.ScrollID != "aScrollId" {
+		t.Errorf("Expected the scroll to continue from 'aScrollId', got '%v'", mockClient.ScrollArgsReceived.ScrollID)
 	}
 
-	searchResponse.Hits.Total = 3
-	mockClient.ScrollReturn.Response = searchResponse
-	ssc, err = NewSlicedScrollCursor(mockClient, 1, 2, "", map[string]interface{}{})
-	numCalls, numHits = exhaustCursor(ssc)
+	if mockClient.SearchArgsReceived.SearchBody != nil {
+		t.Errorf("Expected no search, got '%v'", mockClient.SearchArgsReceived.SearchBody)
+	}
 
-	if numCalls != 3 {
-		t.Errorf("Expected number of iteractions to be 3, performed %v", numCalls)
+	if len(hits) != 2 {
+		t.Errorf("Expected 2 hits, got %v", len(hits))
 	}
 
-	if numHits != 3 {
-		t.Errorf("Expected number of total hits to be 3, got %v", numHits)
+	if hits, _ = ssc.Next(); len(hits) != 0 {
+		t.Errorf("Expected the scroll to be done, got %v hits", len(hits))
+	}
+
+	stats := ssc.Stats()
+
+	if stats.Expected != 5 || stats.Retrieved != 5 || !stats.Done {
+		t.Errorf("Expected 5 of 5 documents retrieved, got '%+v'", stats)
 	}
 }
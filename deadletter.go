@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/alissonsales/esexport/client"
+	"github.com/alissonsales/esexport/features"
+)
+
+func init() {
+	features.Register("output", "dead-letter", "Writes the documents that can't be transformed or partitioned to a separate file (-deadLetter)")
+}
+
+// deadLetterFile collects the documents that failed to be exported on their
+// own (a transformation, serialization or partitioning error), so the rest
+// of the export can go on. Every line holds the document along with the
+// stage that failed and the error.
+type deadLetterFile struct {
+	mu    sync.Mutex
+	path  string
+	f     io.WriteCloser
+	count int
+}
+
+type deadLetter struct {
+	Time  time.Time   `json:"time"`
+	Stage string      `json:"stage"`
+	Error string      `json:"error"`
+	Hit   *client.Hit `json:"hit"`
+}
+
+// openDeadLetterFile creates the file, encrypted like the output when encrypt
+// is set since it holds documents as well
+func openDeadLetterFile(path string, encrypt encryptor) (*deadLetterFile, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if encrypt == nil {
+		return &deadLetterFile{path: path, f: f}, nil
+	}
+
+	ew, err := encrypt(f)
+
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &deadLetterFile{path: path, f: writeCloser{ew, multiCloser{ew, f}}}, nil
+}
+
+// add writes the hit that failed at stage with err
+func (d *deadLetterFile) add(hit *client.Hit, stage string, err error) error {
+	line, merr := json.Marshal(deadLetter{time.Now().UTC(), stage, err.Error(), hit})
+
+	if merr != nil {
+		// The hit itself can't be serialized, keep what identifies it
+		line, _ = json.Marshal(deadLetter{time.Now().UTC(), stage, err.Error(), &client.Hit{ID: hit.ID}})
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, err := d.f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+
+	d.count++
+	return nil
+}
+
+// len returns the number of documents written to the file
+func (d *deadLetterFile) len() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.count
+}
+
+// Close closes the file, removing it when no document failed so a file left
+// by a previous run isn't mistaken for failures of this one
+func (d *deadLetterFile) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	err := d.f.Close()
+
+	if err == nil && d.count == 0 {
+		err = os.Remove(d.path)
+	}
+
+	return err
+}
@@ -4,18 +4,24 @@ import "os"
 
 type debugFunc func()
 
-var shouldDebug bool
+// level is the verbosity, the debug information being printed from 1
+var level int
 
 // Init turn the debug on if the given env variable is set
 func Init(envVar string) {
-	if os.Getenv(envVar) != "" {
-		shouldDebug = true
+	if os.Getenv(envVar) != "" && level == 0 {
+		level = 1
 	}
 }
 
-// Debug calls the given function if the debug environment variable is set
+// SetLevel sets the verbosity, 0 turning the debug off
+func SetLevel(l int) {
+	level = l
+}
+
+// Debug calls the given function if the debug is on
 func Debug(f debugFunc) {
-	if shouldDebug {
+	if level >= 1 {
 		f()
 	}
 }
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/alissonsales/esexport/client"
+)
+
+// spaceCheckInterval is how often the free space is checked while writing
+const spaceCheckInterval = 5 * time.Second
+
+// spaceSampleSize is the number of documents fetched to estimate the size
+// of the export with -spaceEstimate sample
+const spaceSampleSize = 100
+
+// checkDiskSpace estimates the size of the export and fails if the
+// filesystem holding the output can't fit it. The estimate is the store size
+// of the index scaled by the share of documents matching the query, or with
+// sample the average size of the first documents of the query times their
+// count, scaled by the given size ratio.
+//
+// Failures to estimate are only reported, they don't prevent the export.
+func checkDiskSpace(esClient *client.Client, query map[string]interface{}, path, estimate string, ratio float64) error {
+	free, err := freeSpace(filepath.Dir(path))
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Skipping disk space check:", err)
+		return nil
+	}
+
+	var size float64
+
+	if estimate == "sample" {
+		size, err = sampledSize(esClient, query)
+	} else {
+		size, err = storeSize(esClient, query)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Skipping disk space check,", err)
+		return nil
+	}
+
+	if size *= ratio; uint64(size) > free {
+		return fmt.Errorf("Not enough disk space for %v: export estimated at %v, %v available (use -skipSpaceCheck to export anyway)",
+			path, formatBytes(uint64(size)), formatBytes(free))
+	}
+
+	return nil
+}
+
+// storeSize estimates the size of the export from the store size of the
+// index
+func storeSize(esClient *client.Client, query map[string]interface{}) (float64, error) {
+	stats, err := esClient.Stats()
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to retrieve index stats: %v", err)
+	}
+
+	size := float64(stats.Store.SizeInBytes)
+
+	if stats.Docs.Count > 0 {
+		count, err := esClient.Count(query)
+
+		if err != nil {
+			return 0, fmt.Errorf("failed to count documents: %v", err)
+		}
+
+		size = size * float64(count) / float64(stats.Docs.Count)
+	}
+
+	return size, nil
+}
+
+// sampledSize estimates the size of the export from the size of the
+// response of the first documents of the query
+func sampledSize(esClient *client.Client, query map[string]interface{}) (float64, error) {
+	resp, err := esClient.Sample(query, spaceSampleSize)
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to sample documents: %v", err)
+	}
+
+	if len(resp.Hits.Hits) == 0 {
+		return 0, nil
+	}
+
+	count, err := esClient.Count(query)
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to count documents: %v", err)
+	}
+
+	return float64(resp.Bytes) / float64(len(resp.Hits.Hits)) * float64(count), nil
+}
+
+// spaceMonitor watches the free space of the filesystem holding dir
+type spaceMonitor struct {
+	dir          string
+	minFreeSpace uint64
+
+	mu        sync.Mutex
+	lastCheck time.Time
+	paused    bool
+}
+
+// low returns whether the free space is below the configured minimum,
+// checking it once every spaceCheckInterval
+func (m *spaceMonitor) low() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if time.Since(m.lastCheck) < spaceCheckInterval {
+		return m.paused
+	}
+
+	m.lastCheck = time.Now()
+	free, err := freeSpace(m.dir)
+	wasPaused := m.paused
+	m.paused = err == nil && free < m.minFreeSpace
+
+	if m.paused && !wasPaused {
+		fmt.Fprintf(os.Stderr, "\nLow disk space on %v (%v available), export paused until %v are free\n",
+			m.dir, formatBytes(free), formatBytes(m.minFreeSpace))
+	} else if !m.paused && wasPaused {
+		fmt.Fprintf(os.Stderr, "\nFree space available on %v again, resuming export\n", m.dir)
+	}
+
+	return m.paused
+}
+
+// wait blocks while the free space of the output filesystem is below the
+// configured minimum, so the export pauses instead of failing with ENOSPC and
+// leaving a partial file behind. It returns ctx's error when canceled while
+// paused.
+func (m *spaceMonitor) wait(ctx context.Context) error {
+	if m.minFreeSpace == 0 {
+		return nil
+	}
+
+	for m.low() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(spaceCheckInterval):
+		}
+	}
+
+	return nil
+}
+
+func formatBytes(b uint64) string {
+	const unit = 1024
+
+	if b < unit {
+		return fmt.Sprintf("%dB", b)
+	}
+
+	div, exp := uint64(unit), 0
+
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f%ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
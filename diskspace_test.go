@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSpaceMonitorWaitIsInterrupted(t *testing.T) {
+	if _, err := freeSpace(os.TempDir()); err != nil {
+		t.Skip(err)
+	}
+
+	m := &spaceMonitor{dir: os.TempDir(), minFreeSpace: 1 << 62}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+
+	go func() {
+		done <- m.wait(ctx)
+	}()
+
+	cancel()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected the wait to fail with context.Canceled, got %v", err)
+		}
+	case <-time.After(spaceCheckInterval / 2):
+		t.Fatal("Expected the wait to stop once canceled")
+	}
+}
+
+func TestStdoutIgnoresMinFreeSpace(t *testing.T) {
+	for _, path := range []string{"", "-"} {
+		o, err := openOutput(&cmdOpts{output: path, minFreeSpaceMB: 1 << 40}, 0)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if o.space.minFreeSpace != 0 {
+			t.Errorf("Expected output %q not to wait for free space, got a minimum of %v", path, o.space.minFreeSpace)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+)
+
+// dumpTransport writes every request sent to ES and its response to
+// numbered files of -debugDump (000001-request.txt, 000001-response.txt...),
+// the bodies truncated to -debugDumpBytes. Responses are written as they are
+// read, decompressed, and credentials are left out.
+type dumpTransport struct {
+	// count is first to be 64-bit aligned for atomic on 32-bit platforms
+	count int64
+	dir   string
+	limit int64
+	next  http.RoundTripper
+	warn  sync.Once
+}
+
+func newDumpTransport(dir string, limit int64, next http.RoundTripper) (*dumpTransport, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("Failed to create -debugDump %v: %v", dir, err)
+	}
+
+	// Bodies are written whole without a limit
+	if limit <= 0 {
+		limit = -1
+	}
+
+	return &dumpTransport{dir: dir, limit: limit, next: next}, nil
+}
+
+func (t *dumpTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	prefix := filepath.Join(t.dir, fmt.Sprintf("%06d", atomic.AddInt64(&t.count, 1)))
+	t.dumpRequest(prefix+"-request.txt", req)
+
+	resp, err := t.next.RoundTrip(req)
+
+	if err != nil {
+		t.failed(ioutil.WriteFile(prefix+"-response.txt", []byte(err.Error()+"\n"), 0600))
+		return resp, err
+	}
+
+	f, ferr := os.OpenFile(prefix+"-response.txt", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+
+	if ferr != nil {
+		t.failed(ferr)
+		return resp, nil
+	}
+
+	fmt.Fprintf(f, "%v %v\n", resp.Proto, resp.Status)
+	resp.Header.Write(f)
+	fmt.Fprintln(f)
+
+	resp.Body = &dumpBody{ReadCloser: resp.Body, file: f, w: &truncatingWriter{w: f, left: t.limit}}
+	return resp, nil
+}
+
+// dumpRequest writes the request line, headers and body of the request
+func (t *dumpTransport) dumpRequest(path string, req *http.Request) {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%v %v %v\n", req.Method, req.URL, req.Proto)
+
+	header := req.Header.Clone()
+	header.Del("Authorization")
+	header.Write(&b)
+	fmt.Fprintln(&b)
+
+	// GetBody returns a copy of the body, the request still has to send it
+	if req.GetBody != nil && req.Body != nil && req.Body != http.NoBody {
+		body, err := req.GetBody()
+
+		if err != nil {
+			t.failed(err)
+			return
+		}
+
+		w := &truncatingWriter{w: &b, left: t.limit}
+		io.Copy(w, body)
+		body.Close()
+		w.end()
+	}
+
+	t.failed(ioutil.WriteFile(path, b.Bytes(), 0600))
+}
+
+// failed warns once about the requests that couldn't be written
+func (t *dumpTransport) failed(err error) {
+	if err == nil {
+		return
+	}
+
+	t.warn.Do(func() {
+		fmt.Fprintf(os.Stderr, "\nWarning: failed to write to -debugDump %v, requests may be missing: %v\n", t.dir, err)
+	})
+}
+
+// dumpBody copies the body of the response to its file as it is read
+type dumpBody struct {
+	io.ReadCloser
+	file *os.File
+	w    *truncatingWriter
+}
+
+func (b *dumpBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.w.Write(p[:n])
+
+	return n, err
+}
+
+func (b *dumpBody) Close() error {
+	b.w.end()
+	b.file.Close()
+
+	return b.ReadCloser.Close()
+}
+
+// truncatingWriter writes up to left bytes (all of them when negative),
+// dropping the rest
+type truncatingWriter struct {
+	w         io.Writer
+	left      int64
+	truncated bool
+}
+
+func (w *truncatingWriter) Write(p []byte) (int, error) {
+	n := len(p)
+
+	if w.left >= 0 {
+		if int64(len(p)) > w.left {
+			p, w.truncated = p[:w.left], true
+		}
+
+		w.left -= int64(len(p))
+	}
+
+	w.w.Write(p)
+	return n, nil
+}
+
+// end marks a truncated body as such
+func (w *truncatingWriter) end() {
+	if w.truncated {
+		fmt.Fprintf(w.w, "\n[truncated]")
+	}
+
+	fmt.Fprintln(w.w)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/alissonsales/esexport/features"
+)
+
+func init() {
+	features.Register("network", "egress-allowlist", "Refuses connections to hosts not explicitly allowed (-egressAllow)")
+}
+
+// dialFunc has the signature of net.Dialer.DialContext
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// egressAllowlist holds the hosts esexport may connect to, given as host (any
+// port) or host:port. An empty list allows every host.
+type egressAllowlist []string
+
+func (a egressAllowlist) allows(addr string) bool {
+	if len(a) == 0 {
+		return true
+	}
+
+	host, _, err := net.SplitHostPort(addr)
+
+	if err != nil {
+		host = addr
+	}
+
+	for _, allowed := range a {
+		if strings.EqualFold(allowed, addr) || strings.EqualFold(allowed, host) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// egressDial returns the dial function of the connections esexport opens,
+// refusing hosts not in -egressAllow
+func egressDial(opts *cmdOpts) dialFunc {
+	dialer := &net.Dialer{Timeout: opts.connectTimeout, KeepAlive: opts.keepAlive}
+	return egressAllowlist(splitList(opts.egressAllow)).dialer(dialer.DialContext)
+}
+
+// egressTransport returns the transport of the HTTP requests not sent to ES
+// (outputs, notifications, traces), subject to -egressAllow like the one of
+// ES
+func egressTransport(opts *cmdOpts) http.RoundTripper {
+	return egressAllowlist(splitList(opts.egressAllow)).transport(dialingTransport(opts))
+}
+
+// dialingTransport returns a transport dialing with egressDial. It starts from
+// http.DefaultTransport to honor the proxy settings of the environment.
+func dialingTransport(opts *cmdOpts) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = egressDial(opts)
+	return transport
+}
+
+// dialer wraps dial refusing connections to hosts not in the list. Addresses
+// are checked before being resolved, so allowing a hostname doesn't allow
+// whatever else shares its IP. Connections through a proxy dial the proxy,
+// which must be allowed as well: the host the requests are for is checked by
+// transport.
+func (a egressAllowlist) dialer(dial dialFunc) dialFunc {
+	if len(a) == 0 {
+		return dial
+	}
+
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if !a.allows(addr) {
+			return nil, fmt.Errorf("Connection to %v refused: host not in -egressAllow", addr)
+		}
+
+		return dial(ctx, network, addr)
+	}
+}
+
+// transport wraps next refusing the requests to hosts not in the list. The
+// dialer only sees the proxy the requests go through, if any, which would
+// reach any host once allowed.
+func (a egressAllowlist) transport(next http.RoundTripper) http.RoundTripper {
+	if len(a) == 0 {
+		return next
+	}
+
+	return &egressTransportChecker{allowlist: a, next: next}
+}
+
+// egressTransportChecker refuses the requests to hosts not in allowlist
+type egressTransportChecker struct {
+	allowlist egressAllowlist
+	next      http.RoundTripper
+}
+
+func (t *egressTransportChecker) RoundTrip(req *http.Request) (*http.Response, error) {
+	port := req.URL.Port()
+
+	if port == "" {
+		port = "80"
+
+		if req.URL.Scheme == "https" {
+			port = "443"
+		}
+	}
+
+	if addr := net.JoinHostPort(req.URL.Hostname(), port); !t.allowlist.allows(addr) {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+
+		return nil, fmt.Errorf("Request to %v refused: host not in -egressAllow", addr)
+	}
+
+	return t.next.RoundTrip(req)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestEgressAllowThroughProxy(t *testing.T) {
+	// Answers every request as a forward proxy would, whatever its host
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer proxy.Close()
+
+	proxyURL, _ := url.Parse(proxy.URL)
+	opts := &cmdOpts{proxy: proxy.URL, egressAllow: proxyURL.Host + ",es.internal:9200"}
+	transport, err := newTransport(opts)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scenarios := []struct {
+		url string
+		err string
+	}{
+		{"http://es.internal:9200/_search", ""},
+		{"http://elsewhere.example/_search", "Request to elsewhere.example:80 refused: host not in -egressAllow"},
+		{"https://es.internal/_search", "Request to es.internal:443 refused: host not in -egressAllow"},
+	}
+
+	for _, scenario := range scenarios {
+		req, _ := http.NewRequest(http.MethodGet, scenario.url, nil)
+		resp, err := transport.RoundTrip(req)
+
+		if scenario.err == "" {
+			if err != nil {
+				t.Errorf("Expected %v to be allowed through the proxy, got %v", scenario.url, err)
+			} else {
+				resp.Body.Close()
+			}
+
+			continue
+		}
+
+		if err == nil || !strings.Contains(err.Error(), scenario.err) {
+			t.Errorf("Expected %v to be refused with '%v', got %v", scenario.url, scenario.err, err)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// encryptor encrypts what is written to the returned writer into w, until
+// it's closed
+type encryptor func(w io.Writer) (io.WriteCloser, error)
+
+// encryptionSchemes parse the argument of -encrypt scheme:argument into an
+// encryptor, by scheme. They register themselves when compiled in.
+var encryptionSchemes = map[string]func(arg string) (encryptor, error){}
+
+// parseEncrypt returns the encryptor of -encrypt, nil meaning no encryption
+func parseEncrypt(value string) (encryptor, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	var schemes []string
+
+	for s := range encryptionSchemes {
+		schemes = append(schemes, s+":...")
+	}
+
+	if len(schemes) == 0 {
+		return nil, fmt.Errorf("-encrypt isn't available in minimal builds")
+	}
+
+	sort.Strings(schemes)
+	scheme, arg, _ := cut(value, ":")
+	parse, ok := encryptionSchemes[scheme]
+
+	if !ok || arg == "" {
+		return nil, fmt.Errorf("Invalid -encrypt %v (expected %v)", value, strings.Join(schemes, " or "))
+	}
+
+	return parse(arg)
+}
+
+// writeCloser writes to a writer and closes the closer
+type writeCloser struct {
+	io.Writer
+	io.Closer
+}
+
+// fileEncoding is how output files are encoded: compressed, then encrypted
+type fileEncoding struct {
+	compressor *compressor
+	level      int
+	encrypt    encryptor
+}
+
+func newFileEncoding(opts *cmdOpts) (*fileEncoding, error) {
+	comp, err := lookupCompressor(opts.compress)
+
+	if err != nil {
+		return nil, err
+	}
+
+	encrypt, err := parseEncrypt(opts.encrypt)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &fileEncoding{comp, opts.compressLevel, encrypt}, nil
+}
+
+// extension returns what is added to the names of the files esexport names
+// itself (partition files), e.g. .zst.age
+func (e *fileEncoding) extension() string {
+	ext := ""
+
+	if e.compressor != nil {
+		ext += e.compressor.extension
+	}
+
+	if e.encrypt != nil {
+		ext += ".age"
+	}
+
+	return ext
+}
+
+// appendable tells whether a file written with the encoding can be reopened
+// to append to it. Compressed streams can follow each other, encrypted ones
+// can't.
+func (e *fileEncoding) appendable() bool {
+	return e.encrypt == nil
+}
@@ -0,0 +1,80 @@
+//go:build !minimal
+// +build !minimal
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/alissonsales/esexport/features"
+)
+
+func init() {
+	features.Register("encryption", "age", "Encrypts the output for age recipients or with a passphrase (-encrypt)")
+
+	encryptionSchemes["age"] = ageRecipients
+	encryptionSchemes["passphrase"] = agePassphrase
+}
+
+// ageRecipients encrypts for an age public key (age1...), or the recipients
+// listed in a file (one per line)
+func ageRecipients(arg string) (encryptor, error) {
+	var recipients []age.Recipient
+
+	if strings.HasPrefix(arg, "age1") {
+		r, err := age.ParseX25519Recipient(arg)
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid -encrypt recipient: %v", err)
+		}
+
+		recipients = append(recipients, r)
+	} else {
+		f, err := os.Open(arg)
+
+		if err != nil {
+			return nil, err
+		}
+
+		defer f.Close()
+
+		if recipients, err = age.ParseRecipients(f); err != nil {
+			return nil, fmt.Errorf("Invalid -encrypt recipients file %v: %v", arg, err)
+		}
+	}
+
+	return func(w io.Writer) (io.WriteCloser, error) {
+		return age.Encrypt(w, recipients...)
+	}, nil
+}
+
+// agePassphrase encrypts with the passphrase held by a file, which keeps it
+// out of the command line
+func agePassphrase(path string) (encryptor, error) {
+	content, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	passphrase := strings.TrimRight(string(content), "\r\n")
+
+	if passphrase == "" {
+		return nil, fmt.Errorf("Empty passphrase in %v", path)
+	}
+
+	r, err := age.NewScryptRecipient(passphrase)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return func(w io.Writer) (io.WriteCloser, error) {
+		return age.Encrypt(w, r)
+	}, nil
+}
@@ -0,0 +1,100 @@
+//go:build !minimal
+// +build !minimal
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+)
+
+// encryptWith encrypts content with the -encrypt of value
+func encryptWith(t *testing.T, value, content string) []byte {
+	t.Helper()
+	encrypt, err := parseEncrypt(value)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var encrypted bytes.Buffer
+	w, err := encrypt(&encrypted)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := io.WriteString(w, content); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Contains(encrypted.Bytes(), []byte(content)) {
+		t.Errorf("Expected -encrypt %v not to write the content in clear", value)
+	}
+
+	return encrypted.Bytes()
+}
+
+func TestAgeRoundTrip(t *testing.T) {
+	content := "{\"_id\":\"1\",\"_source\":{\"ssn\":\"123-45-6789\"}}\n"
+	identity, err := age.GenerateX25519Identity()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	recipients := filepath.Join(dir, "recipients.txt")
+	passphrase := filepath.Join(dir, "passphrase")
+	ioutil.WriteFile(recipients, []byte("# backups\n"+identity.Recipient().String()+"\n"), 0600)
+	ioutil.WriteFile(passphrase, []byte("correct horse battery staple\n"), 0600)
+
+	scrypt, err := age.NewScryptIdentity("correct horse battery staple")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scenarios := []struct {
+		encrypt  string
+		identity age.Identity
+	}{
+		{"age:" + identity.Recipient().String(), identity},
+		{"age:" + recipients, identity},
+		{"passphrase:" + passphrase, scrypt},
+	}
+
+	for _, scenario := range scenarios {
+		encrypted := encryptWith(t, scenario.encrypt, content)
+		r, err := age.Decrypt(bytes.NewReader(encrypted), scenario.identity)
+
+		if err != nil {
+			t.Errorf("Expected what -encrypt %v wrote to decrypt, got %v", scenario.encrypt, err)
+			continue
+		}
+
+		if b, err := ioutil.ReadAll(r); err != nil || string(b) != content {
+			t.Errorf("Expected -encrypt %v to decrypt to %q, got %q and %v", scenario.encrypt, content, b, err)
+		}
+	}
+}
+
+func TestAgeRejectsInvalidArguments(t *testing.T) {
+	empty := filepath.Join(t.TempDir(), "passphrase")
+	ioutil.WriteFile(empty, []byte("\n"), 0600)
+
+	for _, value := range []string{"age:age1invalid", "passphrase:" + empty, "age:/nonexistent/recipients"} {
+		if _, err := parseEncrypt(value); err == nil {
+			t.Errorf("Expected -encrypt %v to be refused", value)
+		}
+	}
+}
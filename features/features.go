@@ -0,0 +1,61 @@
+// Package features keeps track of the optional features compiled into the
+// binary, so users can find out what a given build supports
+package features
+
+import (
+	"sort"
+	"sync"
+)
+
+// Feature describes something a build supports, e.g. an output destination
+type Feature struct {
+	Kind        string `json:"kind"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+var (
+	mu       sync.Mutex
+	registry = map[string]Feature{}
+)
+
+// Register records a feature as available. It's meant to be called from the
+// init function of the file implementing it, so features excluded by build
+// tags are never registered.
+func Register(kind, name, description string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	registry[kind+"/"+name] = Feature{kind, name, description}
+}
+
+// Has reports whether the given feature is available
+func Has(kind, name string) bool {
+	mu.Lock()
+	defer mu.Unlock()
+
+	_, ok := registry[kind+"/"+name]
+	return ok
+}
+
+// List returns the available features sorted by kind and name
+func List() []Feature {
+	mu.Lock()
+	defer mu.Unlock()
+
+	list := make([]Feature, 0, len(registry))
+
+	for _, f := range registry {
+		list = append(list, f)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Kind != list[j].Kind {
+			return list[i].Kind < list[j].Kind
+		}
+
+		return list[i].Name < list[j].Name
+	})
+
+	return list
+}
@@ -0,0 +1,35 @@
+package features
+
+import "testing"
+
+func TestRegister(t *testing.T) {
+	Register("output", "stdout", "Standard output")
+	Register("command", "self-update", "Updates the binary")
+	Register("output", "file", "Local file")
+	Register("output", "file", "Local file (registered twice)")
+
+	if !Has("output", "file") {
+		t.Error("Expected output/file to be registered")
+	}
+
+	if Has("output", "s3") {
+		t.Error("Expected output/s3 not to be registered")
+	}
+
+	list := List()
+	expected := []string{"command/self-update", "output/file", "output/stdout"}
+
+	if len(list) != len(expected) {
+		t.Fatalf("Expected %v features, got %v", len(expected), len(list))
+	}
+
+	for i, f := range list {
+		if f.Kind+"/"+f.Name != expected[i] {
+			t.Errorf("Expected feature %v to be '%v', got '%v'", i, expected[i], f.Kind+"/"+f.Name)
+		}
+	}
+
+	if list[1].Description != "Local file (registered twice)" {
+		t.Errorf("Expected the last registration to win, got '%v'", list[1].Description)
+	}
+}
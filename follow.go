@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/alissonsales/esexport/client"
+	"github.com/alissonsales/esexport/cursor"
+	"github.com/alissonsales/esexport/features"
+	"github.com/alissonsales/esexport/transform"
+)
+
+func init() {
+	features.Register("strategy", "follow", "Keeps exporting the documents newer than the last one exported, like tail -f (-follow)")
+}
+
+// watermark is the latest timestamp of the documents exported, read from
+// their _source before they are transformed. Following continues from lag
+// before it, for the documents with the same timestamp or not searchable yet,
+// remembering those exported since then so they aren't exported twice.
+type watermark struct {
+	mu     sync.Mutex
+	field  string
+	lag    time.Duration
+	latest time.Time
+	seen   bool
+	// recent holds the timestamps of the documents exported within lag of
+	// latest, by index and id
+	recent map[string]time.Time
+}
+
+// observe moves the watermark with the timestamps of hits, returning the hits
+// which weren't exported already
+func (m *watermark) observe(hits []client.Hit) []client.Hit {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.recent == nil {
+		m.recent = map[string]time.Time{}
+	}
+
+	fresh := hits[:0]
+
+	for i := range hits {
+		value, ok := transform.Get(hits[i].Source, m.field)
+
+		// Documents without a valid timestamp are exported anyway, they just
+		// can't move the watermark
+		t, err := parseDate(value)
+
+		if !ok || err != nil {
+			fresh = append(fresh, hits[i])
+			continue
+		}
+
+		key := hits[i].Index + "/" + hits[i].ID
+
+		if _, exported := m.recent[key]; exported {
+			continue
+		}
+
+		fresh = append(fresh, hits[i])
+
+		if !m.seen || t.After(m.latest) {
+			m.latest, m.seen = t, true
+		}
+
+		if !t.Before(m.latest.Add(-m.lag)) {
+			m.recent[key] = t
+		}
+	}
+
+	for key, t := range m.recent {
+		if t.Before(m.latest.Add(-m.lag)) {
+			delete(m.recent, key)
+		}
+	}
+
+	return fresh
+}
+
+// after returns the timestamp to poll from: lag before the latest timestamp
+// seen, or since when none was
+func (m *watermark) after(since time.Time) time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.seen {
+		return since
+	}
+
+	return m.latest.Add(-m.lag)
+}
+
+// followQuery returns the query restricted to the documents with a timestamp
+// from the given one
+func followQuery(query map[string]interface{}, field string, from time.Time) (map[string]interface{}, error) {
+	return filteredQuery(query, map[string]interface{}{"range": map[string]interface{}{
+		field: map[string]interface{}{"gte": from.UnixNano() / int64(time.Millisecond), "format": "epoch_millis"},
+	}})
+}
+
+// follow polls for the documents newer than the watermark every
+// -pollInterval, writing them like the initial export did, until ctx is
+// canceled. It returns the slices of the polls that found documents, with ids
+// starting at firstID.
+func follow(ctx context.Context, opts *cmdOpts, esClient cursor.ElasticsearchClient, query map[string]interface{},
+	w *hitWriter, out exportOutput, firstID int, since time.Time, ttl time.Duration, tracer *spanTracer) ([]*slice, error) {
+	var polls []*slice
+
+	if err := out.flush(); err != nil {
+		return nil, err
+	}
+
+	// Ends the progress line of the initial export
+	fmt.Fprintln(os.Stderr)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return polls, nil
+		case <-time.After(opts.pollInterval):
+		}
+
+		q, err := followQuery(query, opts.timestampField, w.watermark.after(since))
+
+		if err != nil {
+			return polls, err
+		}
+
+		ssc, err := cursor.NewSlicedScrollCursor(esClient, 0, 0, "", q)
+
+		if err != nil {
+			return polls, err
+		}
+
+		s := &slice{id: firstID + len(polls), cursor: ssc, ttl: ttl, tracer: tracer}
+		err = s.process(ctx, w, 0)
+
+		if docs := s.position().Docs; docs > 0 {
+			polls = append(polls, s)
+			fmt.Fprintf(os.Stderr, "Following: %v new documents\n", docs)
+		}
+
+		if ctx.Err() != nil {
+			return polls, nil
+		}
+
+		if err != nil {
+			return polls, err
+		}
+
+		if err := out.flush(); err != nil {
+			return polls, err
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alissonsales/esexport/client"
+)
+
+func timestampedHits(timestamps map[string]string) []client.Hit {
+	var hits []client.Hit
+
+	for _, id := range []string{"a", "b", "c", "d", "e"} {
+		if ts, ok := timestamps[id]; ok {
+			hits = append(hits, client.Hit{ID: id, Source: map[string]interface{}{"@timestamp": ts}})
+		}
+	}
+
+	return hits
+}
+
+func hitIDs(hits []client.Hit) string {
+	var ids []string
+
+	for _, hit := range hits {
+		ids = append(ids, hit.ID)
+	}
+
+	return fmt.Sprint(ids)
+}
+
+func TestWatermarkSkipsDocumentsExportedWithinLag(t *testing.T) {
+	m := &watermark{field: "@timestamp", lag: time.Second}
+
+	fresh := m.observe(timestampedHits(map[string]string{"a": "2024-01-01T10:00:00Z", "b": "2024-01-01T10:00:05Z", "c": "2024-01-01T10:00:05Z"}))
+
+	if hitIDs(fresh) != "[a b c]" {
+		t.Errorf("Expected every document of the first poll to be exported, got %v", hitIDs(fresh))
+	}
+
+	expected := time.Date(2024, 1, 1, 10, 0, 4, 0, time.UTC)
+
+	if from := m.after(time.Time{}); !from.Equal(expected) {
+		t.Errorf("Expected the next poll to start at %v, got %v", expected, from)
+	}
+
+	// The next poll returns the documents of the latest timestamp again, along
+	// with one indexed with it since
+	fresh = m.observe(timestampedHits(map[string]string{"b": "2024-01-01T10:00:05Z", "c": "2024-01-01T10:00:05Z", "d": "2024-01-01T10:00:05Z", "e": "2024-01-01T10:00:07Z"}))
+
+	if hitIDs(fresh) != "[d e]" {
+		t.Errorf("Expected the documents not exported yet only, got %v", hitIDs(fresh))
+	}
+
+	if _, ok := m.recent["/b"]; ok || len(m.recent) != 1 {
+		t.Errorf("Expected the documents older than the lag to be forgotten, got %v", m.recent)
+	}
+}
+
+func TestFollowQuery(t *testing.T) {
+	q, err := followQuery(map[string]interface{}{}, "@timestamp", time.Unix(1700000000, 0))
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := `map[query:map[bool:map[filter:[map[range:map[@timestamp:map[format:epoch_millis gte:1700000000000]]]]]]]`
+
+	if fmt.Sprint(q) != expected {
+		t.Errorf("Expected query to be %v, got %v", expected, q)
+	}
+}
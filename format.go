@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/alissonsales/esexport/client"
+	"github.com/alissonsales/esexport/features"
+)
+
+func init() {
+	features.Register("format", "bulk", "Action and source lines ready to be posted to _bulk (-format bulk)")
+	features.Register("format", "elasticdump", "The lines of elasticdump --type=data: the hits with _index, _type, _id, _score and _source (-format elasticdump)")
+}
+
+// Formatter serializes the hits written by the sinks taking bytes (files
+// and remote outputs), whatever the sink
+type Formatter interface {
+	// Format returns the bytes of the hit, ending with a newline
+	Format(hit *client.Hit) ([]byte, error)
+}
+
+// lineFormatter formats a hit as the line returned by the function,
+// without its newline
+type lineFormatter func(hit *client.Hit) ([]byte, error)
+
+func (f lineFormatter) Format(hit *client.Hit) ([]byte, error) {
+	line, err := f(hit)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return append(line, '\n'), nil
+}
+
+// idsFormatter writes the _id of the hits, one per line (-idsOnly)
+var idsFormatter = lineFormatter(func(hit *client.Hit) ([]byte, error) {
+	return []byte(hit.ID), nil
+})
+
+// hitFormat is a format of -format: its formatter and what it needs
+type hitFormat struct {
+	Formatter
+	// hitFields are the fields of the hits returned by ES (-filterPath)
+	hitFields []string
+	// multiline is set when a hit takes several lines
+	multiline bool
+}
+
+// hitFormats are the formats of -format
+var hitFormats = map[string]*hitFormat{
+	"ndjson": {
+		hitFields: []string{"_id", "_source", "fields"},
+		Formatter: lineFormatter(func(hit *client.Hit) ([]byte, error) {
+			return json.Marshal(struct {
+				ID          string                 `json:"_id"`
+				Version     *int64                 `json:"_version,omitempty"`
+				SeqNo       *int64                 `json:"_seq_no,omitempty"`
+				PrimaryTerm *int64                 `json:"_primary_term,omitempty"`
+				Source      map[string]interface{} `json:"_source,omitempty"`
+				Fields      map[string]interface{} `json:"fields,omitempty"`
+			}{hit.ID, hit.Version, hit.SeqNo, hit.PrimaryTerm, hit.Source, hit.Fields})
+		}),
+	},
+	// Lines are the hits as returned by ES, which elasticdump writes as they
+	// are (with a null _score when it's not computed)
+	"elasticdump": {
+		hitFields: []string{"_index", "_type", "_id", "_score", "_routing", "_source", "fields"},
+		Formatter: lineFormatter(func(hit *client.Hit) ([]byte, error) {
+			return json.Marshal(struct {
+				Index       string                 `json:"_index"`
+				Type        string                 `json:"_type,omitempty"`
+				ID          string                 `json:"_id"`
+				Version     *int64                 `json:"_version,omitempty"`
+				SeqNo       *int64                 `json:"_seq_no,omitempty"`
+				PrimaryTerm *int64                 `json:"_primary_term,omitempty"`
+				Score       *float64               `json:"_score"`
+				Routing     string                 `json:"_routing,omitempty"`
+				Source      map[string]interface{} `json:"_source,omitempty"`
+				Fields      map[string]interface{} `json:"fields,omitempty"`
+			}{hit.Index, hit.Type, hit.ID, hit.Version, hit.SeqNo, hit.PrimaryTerm, hit.Score, hit.Routing, hit.Source, hit.Fields})
+		}),
+	},
+	// An index action followed by the source, which POST /_bulk takes as
+	// they are (the index of the action wins over that of the URL)
+	"bulk": {
+		hitFields: []string{"_index", "_id", "_routing", "_source"},
+		Formatter: lineFormatter(func(hit *client.Hit) ([]byte, error) {
+			action := map[string]interface{}{"_index": hit.Index, "_id": hit.ID}
+
+			if hit.Routing != "" {
+				action["routing"] = hit.Routing
+			}
+
+			// Indexing the document again only replaces older versions of it
+			if hit.Version != nil {
+				action["version"], action["version_type"] = *hit.Version, "external"
+			}
+
+			line, err := json.Marshal(map[string]interface{}{"index": action})
+
+			if err != nil {
+				return nil, err
+			}
+
+			source, err := json.Marshal(hit.Source)
+
+			if err != nil {
+				return nil, err
+			}
+
+			return append(append(line, '\n'), source...), nil
+		}),
+		multiline: true,
+	},
+}
+
+// checkDocumentLines returns an error when the hits of -format take several
+// lines, which output (reading the documents line by line) can't handle
+func checkDocumentLines(opts *cmdOpts, output string) error {
+	if f, ok := hitFormats[opts.format]; ok && f.multiline {
+		return fmt.Errorf("-format %v can't be used with %v, which needs a document per line", opts.format, output)
+	}
+
+	return nil
+}
+
+// lookupHitFormat returns the format of -format
+func lookupHitFormat(name string) (*hitFormat, error) {
+	if f, ok := hitFormats[name]; ok {
+		return f, nil
+	}
+
+	var names []string
+
+	for n := range hitFormats {
+		names = append(names, n)
+	}
+
+	sort.Strings(names)
+	return nil, fmt.Errorf("Invalid -format %v (expected %v)", name, strings.Join(names, ", "))
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var getDiskFreeSpaceEx = syscall.NewLazyDLL("kernel32.dll").NewProc("GetDiskFreeSpaceExW")
+
+// freeSpace returns the bytes available to the current user on the volume
+// holding dir
+func freeSpace(dir string) (uint64, error) {
+	path, err := syscall.UTF16PtrFromString(dir)
+
+	if err != nil {
+		return 0, err
+	}
+
+	var available uint64
+	r, _, err := getDiskFreeSpaceEx.Call(uintptr(unsafe.Pointer(path)), uintptr(unsafe.Pointer(&available)), 0, 0)
+
+	if r == 0 {
+		return 0, err
+	}
+
+	return available, nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/alissonsales/esexport/features"
+)
+
+func init() {
+	features.Register("output", "split-by-index", "Runs one export per concrete index of an alias or pattern (-splitByIndex)")
+}
+
+// resolveIndices returns the concrete indices -index resolves to
+func resolveIndices(opts *cmdOpts) ([]string, error) {
+	esClient, err := newESClient(opts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	indices, err := esClient.ResolveIndex()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(indices) == 0 {
+		return nil, fmt.Errorf("No index matches %v", opts.index)
+	}
+
+	return indices, nil
+}
+
+// listIndices prints the concrete indices of -index, one per line
+func listIndices(opts *cmdOpts) int {
+	indices, err := resolveIndices(opts)
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to resolve the index:", err)
+		return 1
+	}
+
+	for _, index := range indices {
+		fmt.Println(index)
+	}
+
+	return 0
+}
+
+// indexPath returns the path of the file of the index, inserting its name
+// before the extension (logs.manifest.json becomes
+// logs.manifest.logs-2024.01.json)
+func indexPath(path, index string) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "." + index + ext
+}
+
+// exportByIndex runs one export per concrete index of -index, one after the
+// other, into <output>/<index>.json (or the <output>/<index> directory with
+// -partitionBy). The -manifest and -deadLetter files of every index are
+// named after it. It fails when any export does, after running the others.
+func exportByIndex(ctx context.Context, opts *cmdOpts) int {
+	if err := checkSplitByIndex(opts); err != nil {
+		fmt.Fprintln(os.Stderr, "Error parsing options:", err)
+		return 1
+	}
+
+	encoding, err := newFileEncoding(opts)
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error parsing options:", err)
+		return 1
+	}
+
+	indices, err := resolveIndices(opts)
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to resolve the index:", err)
+		return 1
+	}
+
+	if err := os.MkdirAll(opts.output, 0755); err != nil {
+		fmt.Fprintln(os.Stderr, "Error creating the output directory:", err)
+		return 1
+	}
+
+	status := 0
+
+	for _, index := range indices {
+		if ctx.Err() != nil {
+			break
+		}
+
+		indexOpts := *opts
+		indexOpts.splitByIndex = false
+		indexOpts.index = index
+		indexOpts.output = filepath.Join(opts.output, index)
+
+		if opts.partitionBy == "" {
+			indexOpts.output += ".json" + encoding.extension()
+		}
+
+		if opts.manifest != "" {
+			indexOpts.manifest = indexPath(opts.manifest, index)
+		}
+
+		if opts.deadLetter != "" {
+			indexOpts.deadLetter = indexPath(opts.deadLetter, index)
+		}
+
+		fmt.Fprintf(os.Stderr, "Index %v: exporting to %v\n", index, indexOpts.output)
+
+		if s := runExport(ctx, &indexOpts); s != 0 {
+			fmt.Fprintf(os.Stderr, "Index %v: exited with status %v\n", index, s)
+			status = s
+		}
+	}
+
+	if ctx.Err() != nil {
+		fmt.Fprintln(os.Stderr, "Export interrupted")
+		return 130
+	}
+
+	return status
+}
+
+// checkSplitByIndex fails on options an export per index can't honor
+func checkSplitByIndex(opts *cmdOpts) error {
+	if opts.output == "" || opts.output == "-" || isRemote(opts.output) {
+		return errors.New("-splitByIndex requires -output to be a directory")
+	}
+
+	if opts.target != "" || opts.follow || opts.mode != modeScroll {
+		return errors.New("-splitByIndex can't be combined with -target, -follow or -mode agg")
+	}
+
+	return nil
+}
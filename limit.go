@@ -0,0 +1,40 @@
+package main
+
+import (
+	"sync/atomic"
+
+	"github.com/alissonsales/esexport/client"
+)
+
+// docLimit caps the number of documents exported across slices (-maxDocs),
+// the slices stopping once it's reached
+type docLimit struct {
+	max   int64
+	taken int64
+}
+
+// take returns the hits of the batch within the limit
+func (l *docLimit) take(hits []client.Hit) []client.Hit {
+	if l == nil {
+		return hits
+	}
+
+	taken := atomic.AddInt64(&l.taken, int64(len(hits)))
+
+	if over := taken - l.max; over > 0 {
+		keep := int64(len(hits)) - over
+
+		if keep < 0 {
+			keep = 0
+		}
+
+		return hits[:keep]
+	}
+
+	return hits
+}
+
+// reached returns whether the slices should stop
+func (l *docLimit) reached() bool {
+	return l != nil && atomic.LoadInt64(&l.taken) >= l.max
+}
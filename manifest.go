@@ -0,0 +1,259 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Status of an export recorded in its manifest
+const (
+	statusCompleted = "completed"
+	statusFailed    = "failed"
+	statusAborted   = "aborted"
+	// statusPartial is an export completed without the documents of the
+	// shards that failed (-maxFailedShards)
+	statusPartial = "partial"
+)
+
+// manifest describes an export (what was asked and what was written) for
+// auditing, and how far it went, so partial output is clearly labeled. Every
+// slice records the documents it wrote and, when it didn't complete, the
+// scroll id continuing after them.
+type manifest struct {
+	Status     string                 `json:"status"`
+	StartedAt  time.Time              `json:"started_at"`
+	FinishedAt time.Time              `json:"finished_at"`
+	DurationMS int64                  `json:"duration_ms"`
+	Host       string                 `json:"host"`
+	Index      string                 `json:"index,omitempty"`
+	Type       string                 `json:"type,omitempty"`
+	Routing    string                 `json:"routing,omitempty"`
+	Query      map[string]interface{} `json:"query"`
+	Count      *int64                 `json:"count,omitempty"`
+	// CountCheck compares the documents exported with the count of the
+	// query once the export is done
+	CountCheck *countCheck     `json:"count_check,omitempty"`
+	Slicing    slicingManifest `json:"slicing"`
+	Output     string          `json:"output"`
+	Docs       int             `json:"docs"`
+	Bytes      int64           `json:"bytes"`
+	Files      []outputFile    `json:"files"`
+	Errors     []string        `json:"errors"`
+	Slices     []sliceManifest `json:"slices"`
+	// Checkpoint is what the destination holds
+	Checkpoint *outputCheckpoint `json:"checkpoint,omitempty"`
+	// PartialResponses is the number of responses missing the documents of
+	// failed shards
+	PartialResponses int64 `json:"partial_responses,omitempty"`
+	// DeadLetters is the number of documents written to -deadLetter instead
+	// of the output
+	DeadLetters int `json:"dead_letters,omitempty"`
+}
+
+type slicingManifest struct {
+	Slices int    `json:"slices"`
+	Field  string `json:"field,omitempty"`
+}
+
+// sliceManifest is the position of a slice. Expected is the number of
+// documents its search reported, which Docs may not match when the index
+// changed during the export.
+type sliceManifest struct {
+	ID        int    `json:"id"`
+	Chunk     string `json:"chunk,omitempty"`
+	Expected  int    `json:"expected"`
+	Docs      int    `json:"docs"`
+	Bytes     int64  `json:"bytes"`
+	Completed bool   `json:"completed"`
+	ScrollID  string `json:"scroll_id,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// setCheckpoint records what the destination holds. When the documents
+// written aren't committed (an upload or transaction which didn't complete),
+// the scroll ids of the slices are left out: resuming from them would skip
+// the documents lost with the destination.
+func (m *manifest) setCheckpoint(c outputCheckpoint) {
+	m.Checkpoint = &c
+
+	if c.Committed {
+		return
+	}
+
+	for i := range m.Slices {
+		m.Slices[i].ScrollID = ""
+	}
+}
+
+// outputFile describes a file written by the export
+type outputFile struct {
+	Path   string `json:"path"`
+	Bytes  int64  `json:"bytes"`
+	SHA256 string `json:"sha256"`
+	MD5    string `json:"md5,omitempty"`
+}
+
+func newManifest(opts *cmdOpts, status string, start time.Time, query map[string]interface{}, slices []*slice, files []outputFile) *manifest {
+	finished := time.Now()
+
+	m := &manifest{
+		Status:     status,
+		StartedAt:  start.UTC(),
+		FinishedAt: finished.UTC(),
+		DurationMS: finished.Sub(start).Milliseconds(),
+		Host:       redactURL(opts.host),
+		Index:      opts.index,
+		Type:       opts.docType,
+		Routing:    opts.routing,
+		Query:      query,
+		Slicing:    slicingManifest{Slices: opts.sliceSize, Field: opts.sliceField},
+		Output:     opts.output,
+		Files:      files,
+		Errors:     []string{},
+	}
+
+	for _, s := range slices {
+		position := s.position()
+		m.Docs += position.Docs
+		m.Bytes += position.Bytes
+		m.Slices = append(m.Slices, position)
+
+		if position.Error != "" {
+			m.Errors = append(m.Errors, fmt.Sprintf("Slice %v: %v", position.ID, position.Error))
+		}
+	}
+
+	return m
+}
+
+func readManifest(path string) (*manifest, error) {
+	content, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var m manifest
+
+	if err := json.Unmarshal(content, &m); err != nil {
+		return nil, fmt.Errorf("Error parsing manifest %v: %v", path, err)
+	}
+
+	return &m, nil
+}
+
+// unchanged tells whether the export described by the manifest completed
+// with the same host, index and query, matching the given number of
+// documents, and its files are still there. Otherwise it returns why not.
+func (m *manifest) unchanged(opts *cmdOpts, query map[string]interface{}, count int64) (bool, string) {
+	if m.Status != statusCompleted {
+		return false, fmt.Sprintf("previous export %v", m.Status)
+	}
+
+	if m.Host != redactURL(opts.host) || m.Index != opts.index || m.Type != opts.docType || m.Routing != opts.routing || m.Output != opts.output {
+		return false, "previous export had a different host, index or output"
+	}
+
+	previousQuery, _ := json.Marshal(m.Query)
+	currentQuery, _ := json.Marshal(query)
+
+	if string(previousQuery) != string(currentQuery) {
+		return false, "previous export had a different query"
+	}
+
+	if m.Count == nil || *m.Count != count {
+		return false, "the number of documents changed"
+	}
+
+	for _, f := range m.Files {
+		if f.Path == "-" {
+			return false, "previous export was written to stdout"
+		}
+
+		if info, err := os.Stat(f.Path); err != nil || info.Size() != f.Bytes {
+			return false, fmt.Sprintf("%v is missing or changed", f.Path)
+		}
+	}
+
+	return true, ""
+}
+
+// manifestPath returns where the manifest is written: -manifest or, when an
+// export to a file is aborted, next to the output so the partial data is
+// labeled anyway
+func manifestPath(opts *cmdOpts, status string) string {
+	if opts.manifest != "" || status != statusAborted || opts.output == "" || opts.output == "-" || isRemote(opts.output) {
+		return opts.manifest
+	}
+
+	return filepath.Clean(opts.output) + ".manifest.json"
+}
+
+// write writes the manifest to a temporary file renamed over path, so a
+// manifest is never seen half written
+func (m *manifest) write(path string) error {
+	content, err := json.MarshalIndent(m, "", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".esexport-manifest-")
+
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(append(content, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// writeChecksumSidecars writes the SHA-256 of every file to <file>.sha256, in
+// the format of sha256sum so they can be checked with sha256sum -c from the
+// directory of the file
+func writeChecksumSidecars(files []outputFile) error {
+	for _, f := range files {
+		if f.Path == "-" {
+			continue
+		}
+
+		content := fmt.Sprintf("%v  %v\n", f.SHA256, filepath.Base(f.Path))
+
+		if err := ioutil.WriteFile(f.Path+".sha256", []byte(content), 0644); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// redactURL removes the credentials of an url
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+
+	if err != nil || u.User == nil {
+		return rawURL
+	}
+
+	u.User = nil
+	return u.String()
+}
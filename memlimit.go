@@ -0,0 +1,7 @@
+package main
+
+import "runtime/debug"
+
+func setMemoryLimit(limit int64) {
+	debug.SetMemoryLimit(limit)
+}
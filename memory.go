@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// memoryProfile groups the settings trading memory for speed, so they can be
+// tuned together with -memoryProfile
+type memoryProfile struct {
+	// gcPercent is applied as GOGC
+	gcPercent int
+	// memoryLimit is applied as GOMEMLIMIT (0 means no limit)
+	memoryLimit int64
+	// bufferSize is the size of the output write buffer
+	bufferSize int
+	// prefetch is the number of pages each slice fetches ahead of the one
+	// being written (0 fetches the next page only after writing the current)
+	prefetch int
+}
+
+var memoryProfiles = map[string]memoryProfile{
+	"low":        {gcPercent: 50, memoryLimit: 256 << 20, bufferSize: 32 << 10, prefetch: 0},
+	"balanced":   {gcPercent: 100, bufferSize: 256 << 10, prefetch: 1},
+	"throughput": {gcPercent: 400, bufferSize: 1 << 20, prefetch: 4},
+}
+
+func lookupMemoryProfile(name string) (memoryProfile, error) {
+	profile, ok := memoryProfiles[name]
+
+	if !ok {
+		var names []string
+
+		for n := range memoryProfiles {
+			names = append(names, n)
+		}
+
+		sort.Strings(names)
+		return profile, fmt.Errorf("Unknown memory profile %v (expected %v)", name, strings.Join(names, ", "))
+	}
+
+	return profile, nil
+}
+
+// applyGCSettings sets the GC target and memory limit of the profile, unless
+// GOGC or GOMEMLIMIT were set in the environment, which take precedence
+func (p memoryProfile) applyGCSettings() {
+	if os.Getenv("GOGC") == "" {
+		debug.SetGCPercent(p.gcPercent)
+	}
+
+	if os.Getenv("GOMEMLIMIT") == "" && p.memoryLimit > 0 {
+		setMemoryLimit(p.memoryLimit)
+	}
+}
+
+// memoryBudget bounds the size of the pages fetched but not written yet
+// (-maxMemoryMB), counted as the size of their responses: once reached,
+// slices wait for pages to be written before fetching more, so a slow output
+// holds back the fetching instead of pages piling up in memory. A page is
+// always fetched while under the budget, which can be exceeded by a page per
+// slice at most.
+type memoryBudget struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	max  int64
+	used int64
+}
+
+func newMemoryBudget(max int64) *memoryBudget {
+	b := &memoryBudget{max: max}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+// wait blocks until the pages held are under the budget or ctx is canceled
+func (b *memoryBudget) wait(ctx context.Context) error {
+	if b == nil {
+		return nil
+	}
+
+	// Wakes the waiters up when canceled, cond can't wait on ctx
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			b.mu.Lock()
+			b.cond.Broadcast()
+			b.mu.Unlock()
+		case <-done:
+		}
+	}()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for b.used >= b.max && ctx.Err() == nil {
+		b.cond.Wait()
+	}
+
+	return ctx.Err()
+}
+
+// add counts a page fetched
+func (b *memoryBudget) add(n int64) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	b.used += n
+	b.mu.Unlock()
+}
+
+// release uncounts a page written (or dropped)
+func (b *memoryBudget) release(n int64) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	b.used -= n
+	b.cond.Broadcast()
+	b.mu.Unlock()
+}
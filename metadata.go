@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/alissonsales/esexport/client"
+	"github.com/alissonsales/esexport/features"
+	"github.com/alissonsales/esexport/transform"
+)
+
+func init() {
+	features.Register("output", "index-metadata", "Writes the mapping, settings and aliases of the index next to the export (-withMapping)")
+}
+
+// generatedSettings are the index settings ES sets when creating an index,
+// which can't be given to create another
+var generatedSettings = []string{"uuid", "creation_date", "provided_name", "version"}
+
+// writeIndexMetadata writes the mapping, settings and aliases of the index,
+// by index name, next to the output (<file>.mapping.json...) or in the
+// directory of -partitionBy (mapping.json...)
+func writeIndexMetadata(esClient *client.Client, opts *cmdOpts) error {
+	if opts.output == "" || opts.output == "-" || isRemote(opts.output) || opts.target != "" {
+		return errors.New("-withMapping writes next to the output, it requires -output to be a file or directory")
+	}
+
+	parts := []struct{ endpoint, name string }{{"_mapping", "mapping"}, {"_settings", "settings"}, {"_alias", "aliases"}}
+
+	for _, part := range parts {
+		metadata, err := esClient.Metadata(part.endpoint)
+
+		if err != nil {
+			return err
+		}
+
+		if part.endpoint == "_settings" {
+			for index, raw := range metadata {
+				var settings map[string]interface{}
+
+				if err := json.Unmarshal(raw, &settings); err != nil {
+					return err
+				}
+
+				for _, s := range generatedSettings {
+					transform.Delete(settings, "settings.index."+s)
+				}
+
+				if metadata[index], err = json.Marshal(settings); err != nil {
+					return err
+				}
+			}
+		}
+
+		content, err := json.MarshalIndent(metadata, "", "  ")
+
+		if err != nil {
+			return err
+		}
+
+		path := opts.output + "." + part.name + ".json"
+
+		if opts.partitionBy != "" {
+			path = filepath.Join(opts.output, part.name+".json")
+		}
+
+		if err := ioutil.WriteFile(path, append(content, '\n'), 0644); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
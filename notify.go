@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alissonsales/esexport/features"
+)
+
+func init() {
+	features.Register("notification", "webhook", "Posts a JSON summary of the export to -notifyUrl once it ends, Slack compatible")
+}
+
+// notifyTimeout bounds the request posting the notification
+const notifyTimeout = 30 * time.Second
+
+// notification is posted to -notifyUrl once the export ends. Text makes it
+// readable as a message by Slack (and other chat) incoming webhooks.
+type notification struct {
+	Text       string    `json:"text"`
+	Index      string    `json:"index"`
+	Output     string    `json:"output"`
+	Status     string    `json:"status"`
+	ExitCode   int       `json:"exit_code"`
+	Docs       int       `json:"docs"`
+	Total      int       `json:"total"`
+	Started    time.Time `json:"started"`
+	DurationMS int64     `json:"duration_ms"`
+	Errors     []string  `json:"errors"`
+}
+
+// completion collects the outcome of the exports a notification is about,
+// those of every index with -splitByIndex
+type completion struct {
+	mu     sync.Mutex
+	status string
+	docs   int
+	total  int
+	errors []string
+}
+
+// statusRanks orders the statuses of the exports, the worst one being the
+// status of the notification
+var statusRanks = map[string]int{statusCompleted: 1, statusPartial: 2, statusAborted: 3, statusFailed: 4}
+
+// add records the outcome of an export
+func (c *completion) add(status string, docs, total int, errors []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if statusRanks[status] > statusRanks[c.status] {
+		c.status = status
+	}
+
+	c.docs += docs
+	c.total += total
+	c.errors = append(c.errors, errors...)
+}
+
+// notifyExport runs the export and posts its notification, whether it
+// completes, fails or is interrupted. Exports failing before they start
+// (e.g. on an invalid option) are notified with their exit status only.
+func notifyExport(ctx context.Context, opts *cmdOpts) int {
+	c := &completion{}
+	exportOpts := *opts
+	exportOpts.completion = c
+
+	start := time.Now()
+	exitCode := runExport(ctx, &exportOpts)
+
+	n := notification{Index: opts.index, Output: redactURL(opts.output), Status: c.status, ExitCode: exitCode, Docs: c.docs, Total: c.total,
+		Started: start.UTC(), DurationMS: time.Since(start).Milliseconds(), Errors: c.errors}
+
+	if n.Errors == nil {
+		n.Errors = []string{}
+	}
+
+	switch {
+	case n.Status != "":
+	case exitCode == 0:
+		n.Status = statusCompleted
+	case exitCode == 130:
+		n.Status = statusAborted
+	default:
+		n.Status = statusFailed
+		n.Errors = append(n.Errors, fmt.Sprintf("Exited with status %v, see the logs", exitCode))
+	}
+
+	n.Text = notificationText(n)
+
+	if err := postNotification(opts, n); err != nil {
+		fmt.Fprintln(os.Stderr, "Error sending the notification:", err)
+	}
+
+	return exitCode
+}
+
+// notificationText sums the notification up in a line
+func notificationText(n notification) string {
+	text := fmt.Sprintf("esexport of %v %v after %v: %v of %v documents", n.Index, n.Status,
+		(time.Duration(n.DurationMS) * time.Millisecond).Round(time.Second), n.Docs, n.Total)
+
+	if len(n.Errors) > 0 {
+		text += "\n" + strings.Join(n.Errors, "\n")
+	}
+
+	return text
+}
+
+// postNotification posts the notification as JSON, connecting through
+// -egressAllow like the requests to ES
+func postNotification(opts *cmdOpts, n notification) error {
+	body, err := json.Marshal(n)
+
+	if err != nil {
+		return err
+	}
+
+	httpClient := &http.Client{Transport: egressTransport(opts), Timeout: notifyTimeout}
+
+	// Not canceled with the export, an interrupted export is notified too
+	resp, err := httpClient.Post(opts.notifyURL, "application/json", bytes.NewReader(body))
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%v responded %v", redactURL(opts.notifyURL), resp.Status)
+	}
+
+	return nil
+}
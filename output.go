@@ -0,0 +1,411 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/alissonsales/esexport/features"
+)
+
+func init() {
+	features.Register("output", "stdout", "Writes documents to the standard output (-output -)")
+	features.Register("output", "file", "Writes documents to a local file")
+}
+
+// exportOutput is where the export is written: a single output or
+// partitions
+type exportOutput interface {
+	io.Closer
+	files() []outputFile
+	flush() error
+}
+
+// output is the destination hits are exported to. It is shared by all
+// cursors and computes checksums of everything written while streaming, so
+// verifying the export doesn't require re-reading it afterwards.
+type output struct {
+	mu    sync.Mutex
+	path  string
+	w     *bufferedFile
+	sums  *fileSums
+	space spaceMonitor
+	// lock is held while writing to a local file
+	lock *outputLock
+	// tmpPath is the file written with -atomic, renamed to path by commit
+	tmpPath string
+	// dest is what w writes to: a local file, stdout or a remote output
+	dest io.WriteCloser
+}
+
+// openOutput returns the output hits are exported to. An empty path or "-"
+// means stdout, which keeps the output pipeable into other tools.
+func openOutput(opts *cmdOpts, bufferSize int) (*output, error) {
+	encoding, err := newFileEncoding(opts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	o := &output{path: opts.output, sums: newFileSums(opts.md5)}
+	o.space = spaceMonitor{dir: filepath.Dir(o.path), minFreeSpace: uint64(opts.minFreeSpaceMB) << 20}
+	limiter := newWriteLimiter(opts.maxWriteRate)
+
+	if err := checkAppend(opts); err != nil {
+		return nil, err
+	}
+
+	if err := checkAtomic(opts); err != nil {
+		return nil, err
+	}
+
+	if opts.target != "" {
+		if !o.isStdout() {
+			return nil, errors.New("-target indexes the documents instead of writing them to -output, they can't be combined")
+		}
+
+		o.path = redactURL(opts.target) + "/" + opts.targetIndex
+	}
+
+	if o.isStdout() {
+		// Whatever stdout is piped into, it isn't the current directory
+		o.space.minFreeSpace = 0
+		o.w, err = newFileWriter(nopCloser{os.Stdout}, o.sums, encoding, limiter, bufferSize)
+		return o, err
+	}
+
+	var f io.WriteCloser
+
+	if opts.target != "" {
+		o.space.minFreeSpace = 0
+		f, err = openTarget(opts)
+	} else if isRemote(o.path) {
+		// There is no local filesystem to run out of space
+		o.space.minFreeSpace = 0
+		f, err = openRemote(opts)
+	} else {
+		f, err = o.openFile(opts)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	o.dest = f
+
+	if o.w, err = newFileWriter(f, o.sums, encoding, limiter, bufferSize); err != nil {
+		f.Close()
+		o.lock.release()
+		return nil, err
+	}
+
+	return o, nil
+}
+
+// openFile locks and opens the local file of the output, appended to with
+// -append. Appending to a compressed file adds a stream of its own, which
+// decompressors read as the continuation of the previous ones.
+func (o *output) openFile(opts *cmdOpts) (*os.File, error) {
+	if err := checkOverwrite(opts, o.path, false); err != nil {
+		return nil, err
+	}
+
+	lock, err := lockOutput(o.path, opts.force)
+
+	if err != nil {
+		return nil, err
+	}
+
+	path, flags := o.path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC
+
+	if opts.appendOutput {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+
+	if opts.atomic {
+		o.tmpPath = o.path + ".tmp"
+		path = o.tmpPath
+	}
+
+	f, err := os.OpenFile(path, flags, 0644)
+
+	if err != nil {
+		lock.release()
+		return nil, err
+	}
+
+	o.lock = lock
+	return f, nil
+}
+
+// checkAtomic checks -atomic, which applies to a single local output file
+func checkAtomic(opts *cmdOpts) error {
+	if !opts.atomic {
+		return nil
+	}
+
+	switch {
+	case opts.output == "" || opts.output == "-" || isRemote(opts.output) || opts.target != "":
+		return errors.New("-atomic requires -output to be a file (sftp:// and cloud storage outputs are complete once the export ends anyway)")
+	case opts.partitionBy != "":
+		return errors.New("-atomic can't be used with -partitionBy")
+	case opts.appendOutput:
+		return errors.New("-atomic can't be used with -append")
+	case opts.follow:
+		return errors.New("-atomic can't be used with -follow, the export never ends")
+	}
+
+	return nil
+}
+
+// commit renames the file written with -atomic to the output, once the
+// export completed. With verify, the file is read again first to check it
+// against the SHA-256 computed while writing it.
+func (o *output) commit(verify bool) error {
+	if o.tmpPath == "" {
+		return nil
+	}
+
+	if verify {
+		f, err := os.Open(o.tmpPath)
+
+		if err != nil {
+			return err
+		}
+
+		h := sha256.New()
+		_, err = io.Copy(h, f)
+		f.Close()
+
+		if err != nil {
+			return fmt.Errorf("Failed to verify %v: %v", o.tmpPath, err)
+		}
+
+		if sum, expected := hex.EncodeToString(h.Sum(nil)), o.checksums()["sha256"]; sum != expected {
+			return fmt.Errorf("%v doesn't match what was written (SHA-256 %v instead of %v), left as is", o.tmpPath, sum, expected)
+		}
+	}
+
+	if err := os.Rename(o.tmpPath, o.path); err != nil {
+		return err
+	}
+
+	o.tmpPath = ""
+	o.lock.release()
+	return nil
+}
+
+func (o *output) isStdout() bool {
+	return o.path == "" || o.path == "-"
+}
+
+// write writes p once the output filesystem has enough free space, failing
+// when ctx is canceled while waiting for it. The wait doesn't hold the output
+// lock, so Close isn't held back by it.
+func (o *output) write(ctx context.Context, p []byte) (int, error) {
+	if err := o.space.wait(ctx); err != nil {
+		return 0, err
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return o.w.Write(p)
+}
+
+// Close flushes and closes the output. Writes still in progress (when
+// interrupted) fail afterwards.
+func (o *output) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	// The file of -atomic stays locked until renamed into place
+	if o.tmpPath == "" {
+		defer o.lock.release()
+	}
+
+	return o.w.Close()
+}
+
+// checkpoint returns what the remote output (or -target) committed, local
+// files being committed as they're written
+func (o *output) checkpoint() outputCheckpoint {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	c := checkpointOf(o.dest)
+
+	if _, remote := o.dest.(checkpointer); remote && c.Committed {
+		c.Object = redactURL(o.path)
+	}
+
+	return c
+}
+
+// flush writes what is buffered, so it can be read while the export goes on
+func (o *output) flush() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return o.w.Flush()
+}
+
+// checksums returns the hex encoded checksums of the bytes written to the
+// file so far
+func (o *output) checksums() map[string]string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return o.sums.hex()
+}
+
+// fileSums holds the size and checksums of the bytes written to a file, as
+// they end up in the file (compressed)
+type fileSums struct {
+	bytes  int64
+	sha256 hash.Hash
+	md5    hash.Hash
+}
+
+func newFileSums(withMD5 bool) *fileSums {
+	s := &fileSums{sha256: sha256.New()}
+
+	if withMD5 {
+		s.md5 = md5.New()
+	}
+
+	return s
+}
+
+// hex returns the hex encoded checksums by algorithm
+func (s *fileSums) hex() map[string]string {
+	sums := map[string]string{"sha256": hex.EncodeToString(s.sha256.Sum(nil))}
+
+	if s.md5 != nil {
+		sums["md5"] = hex.EncodeToString(s.md5.Sum(nil))
+	}
+
+	return sums
+}
+
+// summedWriter writes to w, adding what was written to sums
+type summedWriter struct {
+	w    io.Writer
+	sums *fileSums
+}
+
+func (s *summedWriter) Write(p []byte) (int, error) {
+	n, err := s.w.Write(p)
+	s.sums.bytes += int64(n)
+	s.sums.sha256.Write(p[:n])
+
+	if s.sums.md5 != nil {
+		s.sums.md5.Write(p[:n])
+	}
+
+	return n, err
+}
+
+// newFileWriter returns the buffered writer of an output file: what is
+// written is compressed and encrypted as set by encoding, added to sums and
+// throttled by limiter on its way to f, which is closed along with it
+func newFileWriter(f io.WriteCloser, sums *fileSums, encoding *fileEncoding, limiter *writeLimiter, bufferSize int) (*bufferedFile, error) {
+	var w io.Writer = &summedWriter{&throttledWriter{f, limiter}, sums}
+	closers := multiCloser{f}
+
+	if encoding.encrypt != nil {
+		ew, err := encoding.encrypt(w)
+
+		if err != nil {
+			return nil, err
+		}
+
+		w, closers = ew, append(multiCloser{ew}, closers...)
+	}
+
+	var compressor flusher
+
+	if encoding.compressor != nil {
+		cw, err := encoding.compressor.newWriter(w, encoding.level)
+
+		if err != nil {
+			return nil, err
+		}
+
+		w, closers = cw, append(multiCloser{cw}, closers...)
+
+		if fl, ok := cw.(flusher); ok {
+			compressor = fl
+		}
+	}
+
+	return &bufferedFile{bufio.NewWriterSize(w, bufferSize), closers, compressor}, nil
+}
+
+type flusher interface {
+	Flush() error
+}
+
+// bufferedFile batches the small writes of every hit into larger ones,
+// flushing them when closed
+type bufferedFile struct {
+	*bufio.Writer
+	c io.Closer
+	// compressor is flushed along with the buffer, so what was written can
+	// be decompressed
+	compressor flusher
+}
+
+// Flush writes what is buffered to the file
+func (b *bufferedFile) Flush() error {
+	if err := b.Writer.Flush(); err != nil {
+		return err
+	}
+
+	if b.compressor != nil {
+		return b.compressor.Flush()
+	}
+
+	return nil
+}
+
+func (b *bufferedFile) Close() error {
+	err := b.Flush()
+
+	if cerr := b.c.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+// files describes the file written, or stdout as "-"
+func (o *output) files() []outputFile {
+	sums := o.checksums()
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	path := o.path
+
+	if o.isStdout() {
+		path = "-"
+	}
+
+	return []outputFile{{Path: path, Bytes: o.sums.bytes, SHA256: sums["sha256"], MD5: sums["md5"]}}
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
@@ -0,0 +1,105 @@
+//go:build !minimal
+// +build !minimal
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/alissonsales/esexport/features"
+)
+
+func init() {
+	features.Register("output", "azblob", "Uploads the output to Azure Blob Storage (-output azblob://container/blob)")
+
+	remoteOutputs["azblob"] = openAzureBlob
+}
+
+// azblobBlockSize is the size of the blocks staged while the output is
+// written, committed as the blob once it's closed
+const azblobBlockSize = 8 << 20
+
+// openAzureBlob streams the output to a block blob of the storage account
+// of AZURE_STORAGE_ACCOUNT, authenticated with the account key of
+// AZURE_STORAGE_KEY or the SAS token of AZURE_STORAGE_SAS_TOKEN
+func openAzureBlob(opts *cmdOpts, u *url.URL) (io.WriteCloser, error) {
+	blob := strings.TrimPrefix(u.Path, "/")
+
+	if u.Host == "" || blob == "" {
+		return nil, fmt.Errorf("Invalid -output %v (expected azblob://container/blob)", opts.output)
+	}
+
+	account := os.Getenv("AZURE_STORAGE_ACCOUNT")
+
+	if account == "" {
+		return nil, errors.New("AZURE_STORAGE_ACCOUNT must be set to write to an azblob:// output")
+	}
+
+	blobURL := (&url.URL{Scheme: "https", Host: account + ".blob.core.windows.net", Path: "/" + u.Host + "/" + blob}).String()
+
+	// The storage account is subject to -egressAllow like ES
+	clientOpts := &blockblob.ClientOptions{ClientOptions: azcore.ClientOptions{Transport: &http.Client{Transport: egressTransport(opts)}}}
+
+	var client *blockblob.Client
+	var err error
+
+	if key := os.Getenv("AZURE_STORAGE_KEY"); key != "" {
+		cred, credErr := azblob.NewSharedKeyCredential(account, key)
+
+		if credErr != nil {
+			return nil, fmt.Errorf("Invalid AZURE_STORAGE_KEY: %v", credErr)
+		}
+
+		client, err = blockblob.NewClientWithSharedKeyCredential(blobURL, cred, clientOpts)
+	} else if sas := os.Getenv("AZURE_STORAGE_SAS_TOKEN"); sas != "" {
+		client, err = blockblob.NewClientWithNoCredential(blobURL+"?"+strings.TrimPrefix(sas, "?"), clientOpts)
+	} else {
+		return nil, errors.New("AZURE_STORAGE_KEY or AZURE_STORAGE_SAS_TOKEN must be set to write to an azblob:// output")
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create the Azure Blob Storage client: %v", err)
+	}
+
+	r, w := io.Pipe()
+	done := make(chan error, 1)
+
+	go func() {
+		_, err := client.UploadStream(context.Background(), r, &blockblob.UploadStreamOptions{BlockSize: azblobBlockSize})
+		// Writes fail from now on if the upload did
+		r.CloseWithError(err)
+		done <- err
+	}()
+
+	return &azblobWriter{PipeWriter: w, done: done}, nil
+}
+
+// azblobWriter writes to the upload running in the background
+type azblobWriter struct {
+	*io.PipeWriter
+	done      chan error
+	committed bool
+}
+
+// Close commits the blocks written, the blob only shows up in the container
+// once it succeeds
+func (a *azblobWriter) Close() error {
+	a.PipeWriter.Close()
+	err := <-a.done
+	a.committed = err == nil
+	return err
+}
+
+func (a *azblobWriter) checkpoint() outputCheckpoint {
+	return outputCheckpoint{Committed: a.committed}
+}
@@ -0,0 +1,317 @@
+//go:build !minimal
+// +build !minimal
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/alissonsales/esexport/client"
+	"github.com/alissonsales/esexport/features"
+)
+
+func init() {
+	features.Register("output", "bigquery", "Loads the documents into a BigQuery table with load jobs, its schema derived from the index mapping (-output bigquery://project/dataset.table)")
+
+	remoteOutputs["bigquery"] = openBigQuery
+}
+
+// bigQueryTypes are the BigQuery types of the ES field types, fields of
+// other types being loaded as JSON
+var bigQueryTypes = map[string]bigquery.FieldType{
+	"keyword":          bigquery.StringFieldType,
+	"constant_keyword": bigquery.StringFieldType,
+	"wildcard":         bigquery.StringFieldType,
+	"text":             bigquery.StringFieldType,
+	"match_only_text":  bigquery.StringFieldType,
+	"ip":               bigquery.StringFieldType,
+	"version":          bigquery.StringFieldType,
+	"long":             bigquery.IntegerFieldType,
+	"integer":          bigquery.IntegerFieldType,
+	"short":            bigquery.IntegerFieldType,
+	"byte":             bigquery.IntegerFieldType,
+	"double":           bigquery.FloatFieldType,
+	"float":            bigquery.FloatFieldType,
+	"half_float":       bigquery.FloatFieldType,
+	"scaled_float":     bigquery.FloatFieldType,
+	"boolean":          bigquery.BooleanFieldType,
+	"date":             bigquery.TimestampFieldType,
+	"date_nanos":       bigquery.TimestampFieldType,
+}
+
+// bigQueryColumn matches the field names BigQuery accepts as column names
+var bigQueryColumn = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// bigQuerySchema returns the schema of the fields mapped by ES, leaving out
+// those BigQuery can't name (they are ignored when loading)
+func bigQuerySchema(fields map[string]client.FieldMapping) bigquery.Schema {
+	var names []string
+
+	for name := range fields {
+		if bigQueryColumn.MatchString(name) {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+	var schema bigquery.Schema
+
+	for _, name := range names {
+		f := fields[name]
+		column := &bigquery.FieldSchema{Name: name, Type: bigquery.JSONFieldType}
+
+		if t, ok := bigQueryTypes[f.Type]; ok {
+			column.Type = t
+		} else if f.Properties != nil && (f.Type == "" || f.Type == "object" || f.Type == "nested") {
+			column.Type, column.Schema = bigquery.RecordFieldType, bigQuerySchema(f.Properties)
+			column.Repeated = f.Type == "nested"
+
+			// Records need at least a field
+			if len(column.Schema) == 0 {
+				column.Type, column.Schema = bigquery.JSONFieldType, nil
+			}
+		}
+
+		schema = append(schema, column)
+	}
+
+	return schema
+}
+
+// openBigQuery loads the documents into the table with load jobs of up to
+// -bigQueryBatchSize documents, uploaded as they are exported. The table is
+// created or replaced by the first one, with the schema of the index mapping
+// and an _id column.
+func openBigQuery(opts *cmdOpts, u *url.URL) (io.WriteCloser, error) {
+	dataset, table, _ := cut(strings.TrimPrefix(u.Path, "/"), ".")
+
+	if u.Host == "" || dataset == "" || table == "" {
+		return nil, fmt.Errorf("Invalid -output %v (expected bigquery://project/dataset.table)", opts.output)
+	}
+
+	if opts.compress != "" || opts.encrypt != "" {
+		return nil, errors.New("-compress and -encrypt can't be used with a bigquery:// output")
+	}
+
+	if opts.egressAllow != "" {
+		return nil, errors.New("-egressAllow can't restrict the connections of the BigQuery client, it can't be used with a bigquery:// output")
+	}
+
+	if err := checkDocumentLines(opts, "a bigquery:// output"); err != nil {
+		return nil, err
+	}
+
+	if opts.bqBatchSize < 1 {
+		return nil, errors.New("-bigQueryBatchSize must be at least 1")
+	}
+
+	esClient, err := newESClient(opts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	fields, err := esClient.Mapping()
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read the mapping for the BigQuery schema: %v", err)
+	}
+
+	schema := append(bigquery.Schema{{Name: "_id", Type: bigquery.StringFieldType, Required: true}}, bigQuerySchema(fields)...)
+	ctx := context.Background()
+	bq, err := bigquery.NewClient(ctx, u.Host)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create the BigQuery client: %v", err)
+	}
+
+	return &bigQueryWriter{ctx: ctx, client: bq, table: bq.Dataset(dataset).Table(table), schema: schema, batchSize: opts.bqBatchSize}, nil
+}
+
+// bigQueryWriter turns the lines written into rows (the _source fields and
+// _id), streamed to a load job replaced by a new one every batchSize rows
+type bigQueryWriter struct {
+	ctx       context.Context
+	client    *bigquery.Client
+	table     *bigquery.Table
+	schema    bigquery.Schema
+	batchSize int
+	// jobs is the number of load jobs started so far
+	jobs int
+	rows int
+	pipe *io.PipeWriter
+	done chan error
+	// partial is the start of a line not written yet
+	partial []byte
+	// loaded is the number of load jobs completed, with their loadedRows
+	loaded     int
+	loadedRows int
+}
+
+// start starts the load job of the next batch
+func (b *bigQueryWriter) start() {
+	r, w := io.Pipe()
+	source := bigquery.NewReaderSource(r)
+	source.SourceFormat = bigquery.JSON
+	source.Schema = b.schema
+	// Fields BigQuery can't name aren't part of the schema
+	source.IgnoreUnknownValues = true
+
+	loader := b.table.LoaderFrom(source)
+	loader.CreateDisposition = bigquery.CreateIfNeeded
+	loader.WriteDisposition = bigquery.WriteAppend
+
+	if b.jobs == 0 {
+		loader.WriteDisposition = bigquery.WriteTruncate
+	}
+
+	b.jobs++
+	b.rows = 0
+	b.pipe = w
+	b.done = make(chan error, 1)
+
+	go func(done chan error) {
+		err := runLoadJob(b.ctx, loader)
+		// Writes fail from now on if the load did
+		r.CloseWithError(err)
+		done <- err
+	}(b.done)
+}
+
+// runLoadJob uploads the rows of the job and waits for it to complete
+func runLoadJob(ctx context.Context, loader *bigquery.Loader) error {
+	job, err := loader.Run(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	status, err := job.Wait(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	if err := status.Err(); err != nil {
+		return fmt.Errorf("BigQuery load job %v failed: %v", job.ID(), err)
+	}
+
+	return nil
+}
+
+// finish waits for the load job of the current batch
+func (b *bigQueryWriter) finish() error {
+	if b.pipe == nil {
+		return nil
+	}
+
+	b.pipe.Close()
+	b.pipe = nil
+	err := <-b.done
+
+	if err == nil {
+		b.loaded++
+		b.loadedRows += b.rows
+	}
+
+	return err
+}
+
+// checkpoint tells the batches loaded, everything written being committed
+// once the last one is
+func (b *bigQueryWriter) checkpoint() outputCheckpoint {
+	return outputCheckpoint{Committed: b.pipe == nil && b.loaded == b.jobs && len(b.partial) == 0, Batches: b.loaded, Docs: b.loadedRows}
+}
+
+func (b *bigQueryWriter) Write(p []byte) (int, error) {
+	lines := append(b.partial, p...)
+
+	for {
+		i := bytes.IndexByte(lines, '\n')
+
+		if i < 0 {
+			break
+		}
+
+		if err := b.row(lines[:i]); err != nil {
+			return 0, err
+		}
+
+		lines = lines[i+1:]
+	}
+
+	b.partial = append([]byte(nil), lines...)
+	return len(p), nil
+}
+
+// row writes the row of the document line to the current load job, waiting
+// for it to complete once it has a full batch
+func (b *bigQueryWriter) row(line []byte) error {
+	if len(bytes.TrimSpace(line)) == 0 {
+		return nil
+	}
+
+	var doc struct {
+		ID     string                     `json:"_id"`
+		Source map[string]json.RawMessage `json:"_source"`
+	}
+
+	if err := json.Unmarshal(line, &doc); err != nil {
+		return fmt.Errorf("Failed to load a document into BigQuery: %v", err)
+	}
+
+	row := map[string]json.RawMessage{}
+
+	for k, v := range doc.Source {
+		row[k] = v
+	}
+
+	id, _ := json.Marshal(doc.ID)
+	row["_id"] = id
+	j, err := json.Marshal(row)
+
+	if err != nil {
+		return err
+	}
+
+	if b.pipe == nil {
+		b.start()
+	}
+
+	if _, err := b.pipe.Write(append(j, '\n')); err != nil {
+		return err
+	}
+
+	if b.rows++; b.rows >= b.batchSize {
+		return b.finish()
+	}
+
+	return nil
+}
+
+// Close loads the last batch
+func (b *bigQueryWriter) Close() error {
+	defer b.client.Close()
+
+	if err := b.row(b.partial); err != nil {
+		b.finish()
+		return err
+	}
+
+	// An export without documents still replaces the table
+	if b.jobs == 0 {
+		b.start()
+	}
+
+	return b.finish()
+}
@@ -0,0 +1,75 @@
+//go:build !minimal
+// +build !minimal
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/alissonsales/esexport/features"
+)
+
+func init() {
+	features.Register("output", "gcs", "Uploads the output to Google Cloud Storage (-output gs://bucket/object)")
+
+	remoteOutputs["gs"] = openGCS
+}
+
+// gcsChunkSize is the size of the parts of the resumable upload, a failed
+// part being retried without starting over
+const gcsChunkSize = 16 << 20
+
+// openGCS streams the output to a Cloud Storage object with a resumable
+// upload, authenticated with the Application Default Credentials
+func openGCS(opts *cmdOpts, u *url.URL) (io.WriteCloser, error) {
+	object := strings.TrimPrefix(u.Path, "/")
+
+	if u.Host == "" || object == "" {
+		return nil, fmt.Errorf("Invalid -output %v (expected gs://bucket/object)", opts.output)
+	}
+
+	if opts.egressAllow != "" {
+		return nil, errors.New("-egressAllow can't restrict the connections of the Cloud Storage client, it can't be used with a gs:// output")
+	}
+
+	ctx := context.Background()
+	client, err := storage.NewClient(ctx)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create the Cloud Storage client: %v", err)
+	}
+
+	w := client.Bucket(u.Host).Object(object).NewWriter(ctx)
+	w.ChunkSize = gcsChunkSize
+
+	return &gcsWriter{Writer: w, client: client}, nil
+}
+
+type gcsWriter struct {
+	*storage.Writer
+	client    *storage.Client
+	committed bool
+}
+
+func (g *gcsWriter) checkpoint() outputCheckpoint {
+	return outputCheckpoint{Committed: g.committed}
+}
+
+// Close completes the upload, the object only shows up in the bucket once
+// it succeeds
+func (g *gcsWriter) Close() error {
+	err := g.Writer.Close()
+	g.committed = err == nil
+
+	if cerr := g.client.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/alissonsales/esexport/features"
+)
+
+func init() {
+	features.Register("output", "http", "POSTs the documents to an HTTP endpoint in NDJSON batches (-output https://host/path)")
+
+	remoteOutputs["http"] = openHTTPOutput
+	remoteOutputs["https"] = openHTTPOutput
+}
+
+// httpRequestTimeout bounds every attempt to post a batch
+const httpRequestTimeout = time.Minute
+
+// openHTTPOutput posts the documents to the endpoint of the URL, in batches
+// of -httpBatchSize lines
+func openHTTPOutput(opts *cmdOpts, u *url.URL) (io.WriteCloser, error) {
+	if u.Host == "" {
+		return nil, fmt.Errorf("Invalid -output %v (expected http(s)://host/path)", redactURL(opts.output))
+	}
+
+	if opts.compress != "" || opts.encrypt != "" {
+		return nil, errors.New("-compress and -encrypt can't be used with an http(s):// output, batches are posted as NDJSON")
+	}
+
+	if err := checkDocumentLines(opts, "an http(s):// output"); err != nil {
+		return nil, err
+	}
+
+	header := http.Header{}
+
+	for _, h := range opts.httpHeaders {
+		name, value, ok := cut(h, ":")
+
+		if !ok || strings.TrimSpace(name) == "" {
+			// The value isn't printed as it may hold credentials
+			return nil, fmt.Errorf("Invalid -httpHeader %v (expected Name: value)", name)
+		}
+
+		header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+
+	return newHTTPOutput(opts, u.String(), header)
+}
+
+// newHTTPOutput returns an output posting batches of -httpBatchSize lines
+// to endpoint with the header, retried as set by -httpRetries
+func newHTTPOutput(opts *cmdOpts, endpoint string, header http.Header) (*httpOutput, error) {
+	if opts.httpBatchSize < 1 {
+		return nil, errors.New("-httpBatchSize must be at least 1")
+	}
+
+	header.Set("Content-Type", "application/x-ndjson")
+
+	// The endpoint is subject to -egressAllow like ES
+	return &httpOutput{
+		client:    &http.Client{Transport: egressTransport(opts), Timeout: httpRequestTimeout},
+		url:       endpoint,
+		header:    header,
+		batchSize: opts.httpBatchSize,
+		retries:   opts.httpRetries,
+	}, nil
+}
+
+// httpOutput buffers the lines written, posting them every batchSize lines
+// and when closed
+type httpOutput struct {
+	client    *http.Client
+	url       string
+	header    http.Header
+	batchSize int
+	retries   int
+	// line, when set, returns what is posted for a line (without its
+	// newline) instead of the line itself
+	line func([]byte) ([]byte, error)
+	// check, when set, returns an error for a successful response whose
+	// body reports a failure, and whether it's worth retrying
+	check   func(body []byte) (bool, error)
+	batch   bytes.Buffer
+	lines   int
+	partial []byte
+	// posted is the number of lines posted so far, in batches requests
+	posted  int
+	batches int
+}
+
+// checkpoint tells the batches posted, everything written being committed
+// once the last batch is
+func (h *httpOutput) checkpoint() outputCheckpoint {
+	return outputCheckpoint{Committed: h.lines == 0 && len(h.partial) == 0, Batches: h.batches, Docs: h.posted}
+}
+
+func (h *httpOutput) Write(p []byte) (int, error) {
+	lines := append(h.partial, p...)
+
+	for {
+		i := bytes.IndexByte(lines, '\n')
+
+		if i < 0 {
+			break
+		}
+
+		if err := h.add(lines[:i+1]); err != nil {
+			return 0, err
+		}
+
+		lines = lines[i+1:]
+
+		if h.lines < h.batchSize {
+			continue
+		}
+
+		if err := h.post(); err != nil {
+			return 0, err
+		}
+	}
+
+	h.partial = append([]byte(nil), lines...)
+	return len(p), nil
+}
+
+// add adds the line to the batch
+func (h *httpOutput) add(line []byte) error {
+	if len(line) == 0 {
+		return nil
+	}
+
+	if h.line == nil {
+		h.batch.Write(line)
+		h.lines++
+		return nil
+	}
+
+	if line = bytes.TrimSpace(line); len(line) == 0 {
+		return nil
+	}
+
+	b, err := h.line(line)
+
+	if err != nil {
+		return err
+	}
+
+	h.batch.Write(b)
+	h.lines++
+	return nil
+}
+
+// post sends the batch, retrying failed requests and responses asking to
+// retry (429 and 5xx) up to retries times, waiting 1s, 2s, 4s... in between
+func (h *httpOutput) post() error {
+	for attempt := 0; ; attempt++ {
+		retry, err := h.send(h.batch.Bytes())
+
+		if err == nil {
+			h.posted += h.lines
+			h.batches++
+			h.batch.Reset()
+			h.lines = 0
+			return nil
+		}
+
+		if !retry || attempt >= h.retries {
+			return fmt.Errorf("Failed to post %v documents to %v: %v", h.lines, redactURL(h.url), err)
+		}
+
+		time.Sleep(time.Duration(1<<uint(attempt)) * time.Second)
+	}
+}
+
+// send posts the body once, returning whether a failure is worth retrying
+func (h *httpOutput) send(body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, h.url, bytes.NewReader(body))
+
+	if err != nil {
+		return false, err
+	}
+
+	req.Header = h.header.Clone()
+	resp, err := h.client.Do(req)
+
+	if err != nil {
+		return true, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 1<<20))
+		return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, errors.New(resp.Status)
+	}
+
+	if h.check == nil {
+		io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 1<<20))
+		return false, nil
+	}
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+
+	if err != nil {
+		return true, err
+	}
+
+	return h.check(respBody)
+}
+
+// Close posts the documents left
+func (h *httpOutput) Close() error {
+	defer h.client.CloseIdleConnections()
+
+	if err := h.add(h.partial); err != nil {
+		return err
+	}
+
+	if h.batch.Len() == 0 {
+		return nil
+	}
+
+	return h.post()
+}
@@ -0,0 +1,254 @@
+//go:build !minimal
+// +build !minimal
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/alissonsales/esexport/features"
+	"github.com/alissonsales/esexport/transform"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func init() {
+	features.Register("output", "postgres", "Copies the documents into a PostgreSQL table (-output postgres://host/db -pgTable)")
+
+	remoteOutputs["postgres"] = openPostgres
+	remoteOutputs["postgresql"] = openPostgres
+}
+
+// pgColumn is a column of -pgTable and the field of the documents it's
+// filled with
+type pgColumn struct {
+	name  string
+	field string
+}
+
+// parsePGColumns parses -pgColumns column=field,... where a field alone
+// fills the column of the same name
+func parsePGColumns(value string) ([]pgColumn, error) {
+	var columns []pgColumn
+
+	for _, c := range splitList(value) {
+		name, field, ok := cut(c, "=")
+
+		if !ok {
+			field = name
+		}
+
+		if name == "" || field == "" {
+			return nil, fmt.Errorf("Invalid -pgColumns %v (expected column=field,...)", value)
+		}
+
+		columns = append(columns, pgColumn{name, field})
+	}
+
+	if len(columns) == 0 {
+		return nil, errors.New("-pgColumns is required with a postgres:// output")
+	}
+
+	return columns, nil
+}
+
+// openPostgres streams the documents into -pgTable with a single COPY, so
+// they are all committed once the output is closed or none is
+func openPostgres(opts *cmdOpts, u *url.URL) (io.WriteCloser, error) {
+	if opts.compress != "" || opts.encrypt != "" {
+		return nil, errors.New("-compress and -encrypt can't be used with a postgres:// output")
+	}
+
+	if err := checkDocumentLines(opts, "a postgres:// output"); err != nil {
+		return nil, err
+	}
+
+	if opts.pgTable == "" {
+		return nil, errors.New("-pgTable is required with a postgres:// output")
+	}
+
+	columns, err := parsePGColumns(opts.pgColumns)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := pgconn.ParseConfig(u.String())
+
+	if err != nil {
+		return nil, fmt.Errorf("Invalid -output %v: %v", redactURL(opts.output), err)
+	}
+
+	// The server is subject to -egressAllow like ES, checked by name rather
+	// than by the addresses it resolves to
+	config.DialFunc = pgconn.DialFunc(egressDial(opts))
+	config.LookupFunc = func(ctx context.Context, host string) ([]string, error) {
+		return []string{host}, nil
+	}
+
+	ctx := context.Background()
+	conn, err := pgconn.ConnectConfig(ctx, config)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to connect to PostgreSQL: %v", err)
+	}
+
+	names := make([]string, len(columns))
+
+	for i, c := range columns {
+		names[i] = pgx.Identifier{c.name}.Sanitize()
+	}
+
+	copySQL := fmt.Sprintf("COPY %v (%v) FROM STDIN WITH (FORMAT csv)",
+		pgx.Identifier(strings.Split(opts.pgTable, ".")).Sanitize(), strings.Join(names, ", "))
+
+	r, w := io.Pipe()
+	done := make(chan error, 1)
+
+	go func() {
+		_, err := conn.CopyFrom(ctx, r, copySQL)
+		// Writes fail from now on if the copy did
+		r.CloseWithError(err)
+		done <- err
+	}()
+
+	return &pgWriter{pipe: w, done: done, conn: conn, columns: columns}, nil
+}
+
+// pgWriter turns the lines written into CSV rows of the columns, streamed
+// to the COPY running in the background
+type pgWriter struct {
+	pipe    *io.PipeWriter
+	done    chan error
+	conn    *pgconn.PgConn
+	columns []pgColumn
+	// partial is the start of a line not written yet
+	partial []byte
+	// committed is set once the COPY completed
+	committed bool
+}
+
+func (p *pgWriter) checkpoint() outputCheckpoint {
+	return outputCheckpoint{Committed: p.committed}
+}
+
+func (p *pgWriter) Write(b []byte) (int, error) {
+	var rows bytes.Buffer
+	lines := append(p.partial, b...)
+
+	for {
+		i := bytes.IndexByte(lines, '\n')
+
+		if i < 0 {
+			break
+		}
+
+		if err := p.row(&rows, lines[:i]); err != nil {
+			return 0, err
+		}
+
+		lines = lines[i+1:]
+	}
+
+	p.partial = append([]byte(nil), lines...)
+
+	if _, err := p.pipe.Write(rows.Bytes()); err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+// row appends the CSV row of the document line to rows: missing fields are
+// NULL, objects and arrays are written as JSON
+func (p *pgWriter) row(rows *bytes.Buffer, line []byte) error {
+	if len(bytes.TrimSpace(line)) == 0 {
+		return nil
+	}
+
+	var doc map[string]interface{}
+	d := json.NewDecoder(bytes.NewReader(line))
+	// Numbers are copied as written, without going through float64
+	d.UseNumber()
+
+	if err := d.Decode(&doc); err != nil {
+		return fmt.Errorf("Failed to copy a document to PostgreSQL: %v", err)
+	}
+
+	source, _ := doc["_source"].(map[string]interface{})
+	fields, _ := doc["fields"].(map[string]interface{})
+
+	for i, c := range p.columns {
+		if i > 0 {
+			rows.WriteByte(',')
+		}
+
+		var value interface{}
+		var ok bool
+
+		if c.field == "_id" {
+			value, ok = doc["_id"]
+		} else if value, ok = transform.Get(source, c.field); !ok {
+			value, ok = fields[c.field]
+		}
+
+		if !ok || value == nil {
+			continue
+		}
+
+		var s string
+
+		switch v := value.(type) {
+		case string:
+			s = v
+		case json.Number:
+			s = v.String()
+		case bool:
+			s = strconv.FormatBool(v)
+		default:
+			j, err := json.Marshal(v)
+
+			if err != nil {
+				return err
+			}
+
+			s = string(j)
+		}
+
+		// Quoted values are never NULL, even when empty
+		rows.WriteString(`"` + strings.ReplaceAll(s, `"`, `""`) + `"`)
+	}
+
+	rows.WriteByte('\n')
+	return nil
+}
+
+// Close ends the COPY, committing the documents written
+func (p *pgWriter) Close() error {
+	defer p.conn.Close(context.Background())
+
+	if len(p.partial) > 0 {
+		var rows bytes.Buffer
+
+		if err := p.row(&rows, p.partial); err != nil {
+			p.pipe.CloseWithError(err)
+			<-p.done
+			return err
+		}
+
+		p.pipe.Write(rows.Bytes())
+	}
+
+	p.pipe.Close()
+	err := <-p.done
+	p.committed = err == nil
+	return err
+}
@@ -0,0 +1,183 @@
+//go:build !minimal
+// +build !minimal
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/alissonsales/esexport/features"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func init() {
+	features.Register("output", "sftp", "Uploads the output to an SFTP server, renamed into place once complete (-output sftp://user@host/path)")
+
+	remoteOutputs["sftp"] = openSFTP
+}
+
+// openSFTP uploads the output to <path>.part on the server, renamed to path
+// once the output is closed so the file is never seen half written
+func openSFTP(opts *cmdOpts, u *url.URL) (io.WriteCloser, error) {
+	if u.User == nil || u.User.Username() == "" || u.Host == "" || u.Path == "" || u.Path == "/" {
+		return nil, fmt.Errorf("Invalid -output %v (expected sftp://user@host/path)", redactURL(opts.output))
+	}
+
+	if _, ok := u.User.Password(); ok {
+		return nil, errors.New("sftp:// outputs authenticate with keys, the URL can't hold a password")
+	}
+
+	auth, err := sftpAuth(opts.sftpKey)
+
+	if err != nil {
+		return nil, err
+	}
+
+	hostKeys, err := sftpHostKeys(opts.sftpKnownHosts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	addr := u.Host
+
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "22")
+	}
+
+	// The server is subject to -egressAllow like ES
+	conn, err := egressDial(opts)(context.Background(), "tcp", addr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config := &ssh.ClientConfig{User: u.User.Username(), Auth: auth, HostKeyCallback: hostKeys, Timeout: opts.connectTimeout}
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("Failed to connect to %v: %v", addr, err)
+	}
+
+	sshClient := ssh.NewClient(c, chans, reqs)
+	client, err := sftp.NewClient(sshClient, sftp.UseConcurrentWrites(true))
+
+	if err != nil {
+		sshClient.Close()
+		return nil, fmt.Errorf("Failed to start SFTP on %v: %v", addr, err)
+	}
+
+	f, err := client.OpenFile(u.Path+".part", os.O_CREATE|os.O_WRONLY|os.O_TRUNC)
+
+	if err != nil {
+		client.Close()
+		sshClient.Close()
+		return nil, fmt.Errorf("Failed to create %v on %v: %v", u.Path+".part", addr, err)
+	}
+
+	return &sftpWriter{File: f, client: client, ssh: sshClient, path: u.Path}, nil
+}
+
+// sftpAuth authenticates with the private key of the file or, without one,
+// the keys of the SSH agent
+func sftpAuth(keyFile string) ([]ssh.AuthMethod, error) {
+	if keyFile != "" {
+		key, err := ioutil.ReadFile(keyFile)
+
+		if err != nil {
+			return nil, err
+		}
+
+		signer, err := ssh.ParsePrivateKey(key)
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid -sftpKey %v (keys protected by a passphrase have to be loaded in the SSH agent): %v", keyFile, err)
+		}
+
+		return []ssh.AuthMethod{ssh.PublicKeys(signer)}, nil
+	}
+
+	sock := os.Getenv("SSH_AUTH_SOCK")
+
+	if sock == "" {
+		return nil, errors.New("sftp:// outputs require -sftpKey or an SSH agent (SSH_AUTH_SOCK)")
+	}
+
+	conn, err := net.Dial("unix", sock)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to connect to the SSH agent: %v", err)
+	}
+
+	return []ssh.AuthMethod{ssh.PublicKeysCallback(agent.NewClient(conn).Signers)}, nil
+}
+
+// sftpHostKeys checks the key of the server against the known_hosts file,
+// ~/.ssh/known_hosts by default
+func sftpHostKeys(file string) (ssh.HostKeyCallback, error) {
+	if file == "" {
+		home, err := os.UserHomeDir()
+
+		if err != nil {
+			return nil, err
+		}
+
+		file = filepath.Join(home, ".ssh", "known_hosts")
+	}
+
+	callback, err := knownhosts.New(file)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read the known hosts: %v", err)
+	}
+
+	return callback, nil
+}
+
+// sftpWriter writes to the .part file on the server
+type sftpWriter struct {
+	*sftp.File
+	client    *sftp.Client
+	ssh       *ssh.Client
+	path      string
+	committed bool
+}
+
+// checkpoint tells whether the .part file was renamed into place
+func (s *sftpWriter) checkpoint() outputCheckpoint {
+	return outputCheckpoint{Committed: s.committed}
+}
+
+// Close completes the upload and renames the file into place, replacing any
+// file of the same name
+func (s *sftpWriter) Close() error {
+	defer s.ssh.Close()
+	defer s.client.Close()
+
+	if err := s.File.Close(); err != nil {
+		return err
+	}
+
+	// posix-rename replaces the file atomically, servers without the
+	// extension only rename to a new name
+	err := s.client.PosixRename(s.path+".part", s.path)
+
+	if err != nil {
+		err = s.client.Rename(s.path+".part", s.path)
+	}
+
+	s.committed = err == nil
+	return err
+}
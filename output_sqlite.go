@@ -0,0 +1,189 @@
+//go:build !minimal
+// +build !minimal
+
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/alissonsales/esexport/features"
+	_ "modernc.org/sqlite"
+)
+
+func init() {
+	features.Register("output", "sqlite", "Writes the documents to a table of a SQLite database (-output sqlite://export.db?table=docs)")
+
+	remoteOutputs["sqlite"] = openSQLite
+}
+
+// sqliteIdent quotes an SQLite identifier
+func sqliteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// openSQLite writes the documents to the table of the query (docs by
+// default) of the database file, replacing the table. Every document is a
+// row with its id and JSON, and the _source fields listed in the columns
+// query parameter are extracted to indexed columns named after them (dots
+// replaced by underscores).
+func openSQLite(opts *cmdOpts, u *url.URL) (io.WriteCloser, error) {
+	path := u.Host + u.Path
+	table := u.Query().Get("table")
+
+	if table == "" {
+		table = "docs"
+	}
+
+	if path == "" {
+		return nil, fmt.Errorf("Invalid -output %v (expected sqlite://file.db?table=docs)", opts.output)
+	}
+
+	if opts.compress != "" || opts.encrypt != "" {
+		return nil, errors.New("-compress and -encrypt can't be used with a sqlite:// output")
+	}
+
+	if err := checkDocumentLines(opts, "a sqlite:// output"); err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("sqlite", path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// A single connection, the rows are all inserted by one transaction
+	db.SetMaxOpenConns(1)
+	tx, err := db.Begin()
+
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("Failed to open %v: %v", path, err)
+	}
+
+	definitions := []string{"id TEXT PRIMARY KEY", "doc TEXT NOT NULL"}
+	var indexes []string
+
+	for _, field := range splitList(u.Query().Get("columns")) {
+		column := strings.ReplaceAll(field, ".", "_")
+		jsonPath := strings.ReplaceAll("$._source."+field, "'", "''")
+
+		definitions = append(definitions, fmt.Sprintf("%v AS (json_extract(doc, '%v'))", sqliteIdent(column), jsonPath))
+		indexes = append(indexes, fmt.Sprintf("CREATE INDEX %v ON %v (%v)", sqliteIdent(table+"_"+column), sqliteIdent(table), sqliteIdent(column)))
+	}
+
+	statements := []string{
+		"DROP TABLE IF EXISTS " + sqliteIdent(table),
+		fmt.Sprintf("CREATE TABLE %v (%v)", sqliteIdent(table), strings.Join(definitions, ", ")),
+	}
+
+	for _, s := range statements {
+		if _, err := tx.Exec(s); err != nil {
+			tx.Rollback()
+			db.Close()
+			return nil, fmt.Errorf("Failed to create the %v table: %v", table, err)
+		}
+	}
+
+	// Duplicates (documents exported twice by a retried page) replace each
+	// other rather than failing the export
+	insert, err := tx.Prepare(fmt.Sprintf("INSERT OR REPLACE INTO %v (id, doc) VALUES (?, ?)", sqliteIdent(table)))
+
+	if err != nil {
+		tx.Rollback()
+		db.Close()
+		return nil, err
+	}
+
+	return &sqliteWriter{db: db, tx: tx, insert: insert, indexes: indexes}, nil
+}
+
+// sqliteWriter inserts a row for every line written
+type sqliteWriter struct {
+	db     *sql.DB
+	tx     *sql.Tx
+	insert *sql.Stmt
+	// indexes are created once the rows are inserted, which is faster than
+	// updating them along the way
+	indexes []string
+	// partial is the start of a line not written yet
+	partial []byte
+	// committed is set once the transaction is
+	committed bool
+}
+
+func (s *sqliteWriter) checkpoint() outputCheckpoint {
+	return outputCheckpoint{Committed: s.committed}
+}
+
+func (s *sqliteWriter) Write(b []byte) (int, error) {
+	lines := append(s.partial, b...)
+
+	for {
+		i := bytes.IndexByte(lines, '\n')
+
+		if i < 0 {
+			break
+		}
+
+		if err := s.row(lines[:i]); err != nil {
+			return 0, err
+		}
+
+		lines = lines[i+1:]
+	}
+
+	s.partial = append([]byte(nil), lines...)
+	return len(b), nil
+}
+
+// row inserts the document of the line
+func (s *sqliteWriter) row(line []byte) error {
+	if len(bytes.TrimSpace(line)) == 0 {
+		return nil
+	}
+
+	var doc struct {
+		ID string `json:"_id"`
+	}
+
+	if err := json.Unmarshal(line, &doc); err != nil {
+		return fmt.Errorf("Failed to insert a document: %v", err)
+	}
+
+	_, err := s.insert.Exec(doc.ID, string(line))
+	return err
+}
+
+// Close inserts the last line, indexes the table and commits
+func (s *sqliteWriter) Close() error {
+	defer s.db.Close()
+
+	err := s.row(s.partial)
+
+	for _, index := range s.indexes {
+		if err != nil {
+			break
+		}
+
+		_, err = s.tx.Exec(index)
+	}
+
+	s.insert.Close()
+
+	if err != nil {
+		s.tx.Rollback()
+		return err
+	}
+
+	err = s.tx.Commit()
+	s.committed = err == nil
+	return err
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// checkAppend checks -append, which requires local files the export can be
+// appended to
+func checkAppend(opts *cmdOpts) error {
+	if !opts.appendOutput {
+		return nil
+	}
+
+	if opts.output == "" || opts.output == "-" || isRemote(opts.output) || opts.target != "" {
+		return errors.New("-append requires -output to be a file (or a directory with -partitionBy)")
+	}
+
+	if opts.encrypt != "" {
+		return errors.New("-append can't be used with -encrypt, encrypted files can't be appended to")
+	}
+
+	if opts.checksumSidecars {
+		return errors.New("-append can't be used with -sha256Files, the checksums would only cover the documents appended")
+	}
+
+	return nil
+}
+
+// checkOverwrite refuses to replace an existing output, a non-empty file or
+// partitions directory, unless -force or -append is given. Exports with
+// -skipIfUnchanged are meant to replace their previous output, the one their
+// -manifest names.
+func checkOverwrite(opts *cmdOpts, path string, dir bool) error {
+	if opts.force || opts.appendOutput || (opts.skipIfUnchanged && replacesPrevious(opts)) {
+		return nil
+	}
+
+	if dir {
+		f, err := os.Open(path)
+
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		defer f.Close()
+
+		if _, err := f.Readdirnames(1); err == io.EOF {
+			return nil
+		}
+
+		return fmt.Errorf("%v isn't empty, use -force to overwrite its partitions or -append to append to them", path)
+	}
+
+	info, err := os.Stat(path)
+
+	if os.IsNotExist(err) || (err == nil && (info.Size() == 0 || !info.Mode().IsRegular())) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return fmt.Errorf("%v already exists, use -force to overwrite it or -append to append to it", path)
+}
+
+// replacesPrevious tells whether -manifest describes a previous export to
+// -output
+func replacesPrevious(opts *cmdOpts) bool {
+	m, err := readManifest(opts.manifest)
+	return err == nil && m.Output == opts.output
+}
+
+// outputLock is the <output>.lock file held while an export writes to a
+// local output, so two exports don't write to the same files
+type outputLock struct {
+	path string
+}
+
+// lockOutput creates the lock file of the output, failing when it exists
+// unless force is set (taking over a lock left by an export that crashed)
+func lockOutput(path string, force bool) (*outputLock, error) {
+	l := &outputLock{path: path + ".lock"}
+	flags := os.O_CREATE | os.O_WRONLY | os.O_EXCL
+
+	if force {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	}
+
+	f, err := os.OpenFile(l.path, flags, 0644)
+
+	if os.IsExist(err) {
+		owner, _ := ioutil.ReadFile(l.path)
+		return nil, fmt.Errorf("Another export is writing to %v (%v: %v), use -force if it isn't running anymore", path, l.path, strings.TrimSpace(string(owner)))
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to lock %v: %v", path, err)
+	}
+
+	host, _ := os.Hostname()
+	fmt.Fprintf(f, "pid %v on %v\n", os.Getpid(), host)
+
+	if err := f.Close(); err != nil {
+		os.Remove(l.path)
+		return nil, fmt.Errorf("Failed to lock %v: %v", path, err)
+	}
+
+	return l, nil
+}
+
+// release removes the lock file, once
+func (l *outputLock) release() {
+	if l == nil || l.path == "" {
+		return
+	}
+
+	os.Remove(l.path)
+	l.path = ""
+}
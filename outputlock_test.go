@@ -0,0 +1,100 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckOverwrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "outputlock")
+
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	existing := filepath.Join(dir, "docs.json")
+	empty := filepath.Join(dir, "empty.json")
+	manifestPath := filepath.Join(dir, "manifest.json")
+	other := filepath.Join(dir, "other.json")
+	ioutil.WriteFile(existing, []byte("{}\n"), 0644)
+	ioutil.WriteFile(empty, nil, 0644)
+	(&manifest{Status: statusCompleted, Output: existing}).write(manifestPath)
+
+	scenarios := []struct {
+		opts        cmdOpts
+		path        string
+		dir         bool
+		expectedErr string
+	}{
+		{cmdOpts{}, filepath.Join(dir, "missing.json"), false, ""},
+		{cmdOpts{}, empty, false, ""},
+		{cmdOpts{}, existing, false, "already exists"},
+		{cmdOpts{force: true}, existing, false, ""},
+		{cmdOpts{appendOutput: true}, existing, false, ""},
+		{cmdOpts{skipIfUnchanged: true, manifest: manifestPath}, existing, false, ""},
+		// The manifest of another export doesn't make the file replaceable
+		{cmdOpts{skipIfUnchanged: true, manifest: other}, existing, false, "already exists"},
+		{cmdOpts{skipIfUnchanged: true, manifest: filepath.Join(dir, "missing.manifest.json")}, existing, false, "already exists"},
+		{cmdOpts{}, filepath.Join(dir, "missing"), true, ""},
+		{cmdOpts{}, dir, true, "isn't empty"},
+		{cmdOpts{force: true}, dir, true, ""},
+	}
+
+	ioutil.WriteFile(other, []byte(`{"status": "completed", "output": "elsewhere.json"}`), 0644)
+
+	for _, scenario := range scenarios {
+		opts := scenario.opts
+		opts.output = existing
+		err := checkOverwrite(&opts, scenario.path, scenario.dir)
+
+		if (err == nil) != (scenario.expectedErr == "") || (err != nil && !strings.Contains(err.Error(), scenario.expectedErr)) {
+			t.Errorf("Expected checking %v with %+v to fail with '%v', got '%v'", scenario.path, scenario.opts, scenario.expectedErr, err)
+		}
+	}
+}
+
+func TestLockOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "outputlock")
+
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "docs.json")
+	lock, err := lockOutput(path, false)
+
+	if err != nil {
+		t.Fatalf("Failed to lock %v: %v", path, err)
+	}
+
+	if _, err := lockOutput(path, false); err == nil || !strings.Contains(err.Error(), "Another export is writing") {
+		t.Errorf("Expected a second lock to fail, got '%v'", err)
+	}
+
+	// -force takes over the lock of an export which didn't end
+	forced, err := lockOutput(path, true)
+
+	if err != nil {
+		t.Fatalf("Expected -force to take over the lock, got '%v'", err)
+	}
+
+	forced.release()
+	lock.release()
+
+	if _, err := os.Stat(path + ".lock"); !os.IsNotExist(err) {
+		t.Errorf("Expected the lock file to be removed, got '%v'", err)
+	}
+
+	if lock, err = lockOutput(path, false); err != nil {
+		t.Errorf("Expected the output to be locked again once released, got '%v'", err)
+	}
+
+	lock.release()
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alissonsales/esexport/client"
+	"github.com/alissonsales/esexport/features"
+	"github.com/alissonsales/esexport/transform"
+)
+
+func init() {
+	features.Register("output", "partitioned", "Writes documents to one directory per value of a field (-partitionBy)")
+}
+
+// partitionFile is the name of the file written in every partition directory
+const partitionFile = "docs.json"
+
+// missingPartition holds the documents without a value for the partition field
+const missingPartition = "_missing"
+
+// partitioner computes the partition of a document from one of its fields,
+// formatted as a date when a layout is given
+type partitioner struct {
+	name   string
+	field  string
+	layout string
+}
+
+// parsePartitionBy parses [name=]field[:layout], e.g. tenant_id or
+// dt=created_at:2006-01-02. The name defaults to the field.
+func parsePartitionBy(value string) (*partitioner, error) {
+	p := &partitioner{}
+	spec := value
+
+	if name, rest, ok := cut(spec, "="); ok {
+		p.name, spec = name, rest
+	}
+
+	p.field, p.layout, _ = cut(spec, ":")
+
+	if p.name == "" {
+		p.name = p.field
+	}
+
+	if p.field == "" {
+		return nil, fmt.Errorf("Invalid -partitionBy %v (expected [name=]field[:layout])", value)
+	}
+
+	return p, nil
+}
+
+// partition returns the directory name of the document partition, as
+// name=value
+func (p *partitioner) partition(hit *client.Hit) (string, error) {
+	value, ok := transform.Get(hit.Source, p.field)
+
+	if !ok || value == nil {
+		return p.name + "=" + missingPartition, nil
+	}
+
+	var s string
+
+	if p.layout != "" {
+		t, err := parseDate(value)
+
+		if err != nil {
+			return "", fmt.Errorf("Failed to partition document %v by %v: %v", hit.ID, p.field, err)
+		}
+
+		s = t.UTC().Format(p.layout)
+	} else {
+		switch v := value.(type) {
+		case string:
+			s = v
+		case float64:
+			s = strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			j, err := json.Marshal(v)
+
+			if err != nil {
+				return "", err
+			}
+
+			s = string(j)
+		}
+	}
+
+	return p.name + "=" + escapePartition(s), nil
+}
+
+// parseDate parses the date formats ES returns by default: ISO 8601 strings
+// and epoch milliseconds
+func parseDate(value interface{}) (time.Time, error) {
+	switch v := value.(type) {
+	case float64:
+		return time.Unix(0, int64(v)*int64(time.Millisecond)), nil
+	case string:
+		for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999999", "2006-01-02"} {
+			if t, err := time.Parse(layout, v); err == nil {
+				return t, nil
+			}
+		}
+
+		if ms, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return time.Unix(0, ms*int64(time.Millisecond)), nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("%v is not a date", value)
+}
+
+// escapePartition percent-encodes the characters of a partition value that
+// aren't safe in a directory name on every platform
+func escapePartition(value string) string {
+	if value == "" {
+		return "_empty"
+	}
+
+	var b strings.Builder
+
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', strings.IndexByte("-_@+", c) >= 0:
+			b.WriteByte(c)
+		case c == '.' && strings.Trim(value, ".") != "":
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
+
+// partitionedOutput writes every document to the file of its partition.
+// Files are opened as documents of their partition show up, keeping at most
+// maxOpen of them open: the least recently written is closed to open another
+// and reopened for appending when needed again.
+type partitionedOutput struct {
+	mu          sync.Mutex
+	dir         string
+	partitioner *partitioner
+	bufferSize  int
+	maxOpen     int
+	open        map[string]*list.Element
+	recent      *list.List
+	created     map[string]bool
+	sums        map[string]*fileSums
+	md5         bool
+	space       spaceMonitor
+	// limiter is shared by the files of every partition
+	limiter *writeLimiter
+	// fileName is partitionFile, with the extension of the encoding
+	fileName string
+	encoding *fileEncoding
+	// appendFiles appends to the files of the partitions rather than
+	// replacing them (-append)
+	appendFiles bool
+	lock        *outputLock
+}
+
+type partitionWriter struct {
+	partition string
+	file      *bufferedFile
+}
+
+func newPartitionedOutput(opts *cmdOpts, bufferSize int) (*partitionedOutput, error) {
+	p, err := parsePartitionBy(opts.partitionBy)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.output == "" || opts.output == "-" || isRemote(opts.output) {
+		return nil, errors.New("-partitionBy requires -output to be a directory")
+	}
+
+	if opts.transformCmd != "" {
+		return nil, errors.New("-partitionBy can't be combined with -transformCmd")
+	}
+
+	if opts.openPartitions < 1 {
+		return nil, errors.New("-maxOpenPartitions must be at least 1")
+	}
+
+	if err := checkAppend(opts); err != nil {
+		return nil, err
+	}
+
+	encoding, err := newFileEncoding(opts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkOverwrite(opts, opts.output, true); err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(opts.output, 0755); err != nil {
+		return nil, err
+	}
+
+	lock, err := lockOutput(filepath.Clean(opts.output), opts.force)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &partitionedOutput{
+		appendFiles: opts.appendOutput,
+		lock:        lock,
+		dir:         opts.output,
+		partitioner: p,
+		bufferSize:  bufferSize,
+		maxOpen:     opts.openPartitions,
+		open:        map[string]*list.Element{},
+		recent:      list.New(),
+		created:     map[string]bool{},
+		sums:        map[string]*fileSums{},
+		md5:         opts.md5,
+		space:       spaceMonitor{dir: opts.output, minFreeSpace: uint64(opts.minFreeSpaceMB) << 20},
+		limiter:     newWriteLimiter(opts.maxWriteRate),
+		fileName:    partitionFile + encoding.extension(),
+		encoding:    encoding,
+	}, nil
+}
+
+// write writes the serialized hits to the file of their partition, once the
+// filesystem has enough free space
+func (o *partitionedOutput) write(ctx context.Context, partition string, line []byte) error {
+	if err := o.space.wait(ctx); err != nil {
+		return err
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	f, err := o.file(partition)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(line)
+	return err
+}
+
+// file returns the open file of the partition, opening it if needed. Must be
+// called holding o.mu.
+func (o *partitionedOutput) file(partition string) (*bufferedFile, error) {
+	if e, ok := o.open[partition]; ok {
+		o.recent.MoveToFront(e)
+		return e.Value.(*partitionWriter).file, nil
+	}
+
+	if o.recent.Len() >= o.maxOpen {
+		oldest := o.recent.Remove(o.recent.Back()).(*partitionWriter)
+		delete(o.open, oldest.partition)
+
+		if err := oldest.file.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	dir := filepath.Join(o.dir, partition)
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	if o.created[partition] && !o.encoding.appendable() {
+		return nil, fmt.Errorf("Can't reopen the encrypted file of partition %v to append to it, raise -maxOpenPartitions above the number of partitions", partition)
+	}
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+
+	if !o.created[partition] && !o.appendFiles {
+		flags |= os.O_TRUNC
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, o.fileName), flags, 0644)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !o.created[partition] {
+		o.created[partition] = true
+		o.sums[partition] = newFileSums(o.md5)
+	}
+
+	// Files reopened for appending get a compressed stream of their own,
+	// which decompressors read as the continuation of the previous one
+	file, err := newFileWriter(f, o.sums[partition], o.encoding, o.limiter, o.bufferSize)
+
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	w := &partitionWriter{partition, file}
+	o.open[partition] = o.recent.PushFront(w)
+
+	return w.file, nil
+}
+
+// partitions returns the number of partitions written
+func (o *partitionedOutput) partitions() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return len(o.created)
+}
+
+// files describes the partition files written, sorted by path
+func (o *partitionedOutput) files() []outputFile {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var files []outputFile
+
+	for partition, sums := range o.sums {
+		sum := sums.hex()
+		files = append(files, outputFile{Path: filepath.Join(o.dir, partition, o.fileName), Bytes: sums.bytes, SHA256: sum["sha256"], MD5: sum["md5"]})
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files
+}
+
+// flush writes what is buffered for the open partition files
+func (o *partitionedOutput) flush() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for e := o.recent.Front(); e != nil; e = e.Next() {
+		if err := e.Value.(*partitionWriter).file.Flush(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Close flushes and closes the open partition files
+func (o *partitionedOutput) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	defer o.lock.release()
+
+	var err error
+
+	for e := o.recent.Front(); e != nil; e = e.Next() {
+		if cerr := e.Value.(*partitionWriter).file.Close(); err == nil {
+			err = cerr
+		}
+	}
+
+	o.recent.Init()
+	o.open = map[string]*list.Element{}
+
+	return err
+}
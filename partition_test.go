@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alissonsales/esexport/client"
+)
+
+func TestEscapePartition(t *testing.T) {
+	scenarios := []struct {
+		value    string
+		expected string
+	}{
+		{"acme-01_x@y+z", "acme-01_x@y+z"},
+		{"", "_empty"},
+		{"a/b", "a%2Fb"},
+		{"../etc", "..%2Fetc"},
+		{"..", "%2E%2E"},
+		{".", "%2E"},
+		{"1.5", "1.5"},
+		{"50%", "50%25"},
+		{"a b\\c", "a%20b%5Cc"},
+	}
+
+	for _, scenario := range scenarios {
+		if escaped := escapePartition(scenario.value); escaped != scenario.expected {
+			t.Errorf("Expected '%v' to be escaped as '%v', got '%v'", scenario.value, scenario.expected, escaped)
+		}
+	}
+}
+
+func TestPartition(t *testing.T) {
+	scenarios := []struct {
+		partitionBy string
+		source      map[string]interface{}
+		expected    string
+		expectedErr bool
+	}{
+		{"tenant", map[string]interface{}{"tenant": "acme"}, "tenant=acme", false},
+		{"t=tenant", map[string]interface{}{"tenant": "a/b"}, "t=a%2Fb", false},
+		{"tenant", map[string]interface{}{"tenant": 3.0}, "tenant=3", false},
+		{"tenant", map[string]interface{}{"tenant": true}, "tenant=true", false},
+		{"tenant", map[string]interface{}{}, "tenant=_missing", false},
+		{"tenant", map[string]interface{}{"tenant": nil}, "tenant=_missing", false},
+		{"dt=created:2006-01-02", map[string]interface{}{"created": "2024-03-05T23:30:00-02:00"}, "dt=2024-03-06", false},
+		{"dt=created:2006-01-02", map[string]interface{}{"created": "2024-03-05"}, "dt=2024-03-05", false},
+		{"dt=created:2006-01", map[string]interface{}{"created": 1700000000000.0}, "dt=2023-11", false},
+		{"dt=created:2006-01", map[string]interface{}{"created": "1700000000000"}, "dt=2023-11", false},
+		{"dt=created:2006-01-02", map[string]interface{}{"created": "yesterday"}, "", true},
+	}
+
+	for _, scenario := range scenarios {
+		p, err := parsePartitionBy(scenario.partitionBy)
+
+		if err != nil {
+			t.Fatalf("Failed to parse %v: %v", scenario.partitionBy, err)
+		}
+
+		partition, err := p.partition(&client.Hit{ID: "1", Source: scenario.source})
+
+		if (err != nil) != scenario.expectedErr || partition != scenario.expected {
+			t.Errorf("Expected %v of %v to be '%v' (error: %v), got '%v' (%v)", scenario.partitionBy, scenario.source, scenario.expected, scenario.expectedErr, partition, err)
+		}
+	}
+}
+
+func TestPartitionsReopenedAppend(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "partitions")
+
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+
+	defer os.RemoveAll(tmp)
+
+	dir := filepath.Join(tmp, "out")
+	opts, fs := newCmdOpts("esexport", flag.ContinueOnError)
+
+	if err := fs.Parse([]string{"-output", dir, "-partitionBy", "tenant", "-maxOpenPartitions", "1", "-minFreeSpaceMB", "0"}); err != nil {
+		t.Fatalf("Failed to parse options: %v", err)
+	}
+
+	o, err := newPartitionedOutput(opts, 0)
+
+	if err != nil {
+		t.Fatalf("Failed to create partitioned output: %v", err)
+	}
+
+	// Writing b closes a, which is reopened for the last line
+	for _, line := range []struct{ partition, line string }{{"tenant=a", "1\n"}, {"tenant=b", "2\n"}, {"tenant=a", "3\n"}} {
+		if err := o.write(context.Background(), line.partition, []byte(line.line)); err != nil {
+			t.Fatalf("Failed to write %v: %v", line.partition, err)
+		}
+	}
+
+	if err := o.Close(); err != nil {
+		t.Fatalf("Failed to close partitioned output: %v", err)
+	}
+
+	for partition, expected := range map[string]string{"tenant=a": "1\n3\n", "tenant=b": "2\n"} {
+		content, err := ioutil.ReadFile(filepath.Join(dir, partition, partitionFile))
+
+		if err != nil {
+			t.Fatalf("Failed to read partition %v: %v", partition, err)
+		}
+
+		if string(content) != expected {
+			t.Errorf("Expected partition %v to hold '%q', got '%q'", partition, expected, content)
+		}
+	}
+}
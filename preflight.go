@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/alissonsales/esexport/client"
+)
+
+// preflight checks the credentials, the index and the query before the
+// export starts, so a mistake fails with a precise error rather than with
+// every slice failing its first request. Checks the user isn't allowed to
+// run (a role limited to reading the documents) are skipped.
+func preflight(esClient *client.Client, opts *cmdOpts, query map[string]interface{}) error {
+	exists, err := esClient.IndexExists()
+
+	switch {
+	case isStatus(err, http.StatusUnauthorized):
+		return errors.New("Authentication failed, check -user and -password")
+	case isStatus(err, http.StatusForbidden):
+	case err != nil:
+		return fmt.Errorf("Failed to check the index %v: %v", opts.index, err)
+	case !exists:
+		return fmt.Errorf("Index %v not found (no index or alias matches it)", opts.index)
+	}
+
+	reason, err := esClient.ValidateQuery(query)
+
+	switch {
+	case isStatus(err, http.StatusUnauthorized):
+		return errors.New("Authentication failed, check -user and -password")
+	case isStatus(err, http.StatusForbidden):
+	case err != nil:
+		return fmt.Errorf("Failed to validate the query: %v", err)
+	case reason != "":
+		return fmt.Errorf("Invalid query: %v", reason)
+	}
+
+	return nil
+}
+
+// healthLevels ranks the health statuses of -requireHealth
+var healthLevels = map[string]int{"red": 0, "yellow": 1, "green": 2}
+
+// healthPollInterval is the time between two checks of the health while
+// waiting for it with -waitForHealth
+const healthPollInterval = 10 * time.Second
+
+// checkHealth refuses to start the export while the health of the index is
+// below -requireHealth, waiting up to -waitForHealth for it to get there
+func checkHealth(ctx context.Context, esClient *client.Client, opts *cmdOpts) error {
+	if opts.requireHealth == "" {
+		if opts.waitForHealth > 0 {
+			return errors.New("-waitForHealth requires -requireHealth")
+		}
+
+		return nil
+	}
+
+	required, ok := healthLevels[opts.requireHealth]
+
+	if !ok || required == 0 {
+		return fmt.Errorf("Invalid -requireHealth %v (expected yellow or green)", opts.requireHealth)
+	}
+
+	deadline := time.Now().Add(opts.waitForHealth)
+	waiting := false
+
+	for {
+		health, err := esClient.Health()
+
+		switch {
+		case isStatus(err, http.StatusUnauthorized):
+			return errors.New("Authentication failed, check -user and -password")
+		case isStatus(err, http.StatusForbidden):
+			fmt.Fprintln(os.Stderr, "Skipping the health check, not allowed to read the cluster health")
+			return nil
+		case err != nil:
+			return fmt.Errorf("Failed to check the health of %v: %v", opts.index, err)
+		case healthLevels[health.Status] >= required:
+			return nil
+		}
+
+		remaining := time.Until(deadline)
+
+		if remaining <= 0 {
+			return fmt.Errorf("The health of %v is %v (%v unassigned, %v initializing shards), -requireHealth is %v",
+				opts.index, health.Status, health.UnassignedShards, health.InitializingShards, opts.requireHealth)
+		}
+
+		if !waiting {
+			fmt.Fprintf(os.Stderr, "The health of %v is %v, waiting up to %v for it to be %v\n", opts.index, health.Status, opts.waitForHealth, opts.requireHealth)
+			waiting = true
+		}
+
+		wait := healthPollInterval
+
+		if remaining < wait {
+			wait = remaining
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// isStatus returns whether the error is an ES response with the status
+func isStatus(err error, status int) bool {
+	var statusErr *client.HTTPStatusError
+	return errors.As(err, &statusErr) && statusErr.StatusCode == status
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/alissonsales/esexport/debug"
+)
+
+const (
+	defaultProgressTemplate = `Progress: [{{.Current}}/{{.Total}}] {{printf "%.0f" .Percent}}%`
+	defaultSummaryTemplate  = `{{range $algo, $sum := .Checksums}}{{$algo}} {{$sum}}  {{$.Output}}{{"\n"}}{{end}}` +
+		`{{if .Partitions}}{{.Partitions}} partitions written to {{.Output}}{{"\n"}}{{end}}` +
+		`{{if .Rejected}}{{.Rejected}} documents failed and were written to {{.DeadLetter}}{{"\n"}}{{end}}` +
+		`{{if and .Verbose (gt (len .Slices) 1)}}{{range .Slices}}Slice {{.ID}}: {{.Docs}} documents, {{bytes .Bytes}} in {{round .Elapsed}} ({{printf "%.0f" .DocsPerSec}} docs/s, {{bytes .BytesPerSec}}/s), ` +
+		`{{round .AvgLatency}} average page latency, {{.Retries}} retries{{"\n"}}{{end}}{{end}}` +
+		`{{if .Bytes}}{{.Docs}} documents, {{bytes .Bytes}} written in {{round .Elapsed}} ({{printf "%.0f" .DocsPerSec}} docs/s, {{bytes .BytesPerSec}}/s), ` +
+		`{{round .AvgLatency}} average page latency, {{.Retries}} retries{{"\n"}}{{end}}`
+)
+
+// templateFuncs are available to the progress and summary templates
+var templateFuncs = template.FuncMap{
+	"bytes": func(b int64) string { return formatBytes(uint64(b)) },
+	"round": func(d time.Duration) time.Duration { return d.Round(time.Millisecond) },
+}
+
+// progressData is available to the progress template
+type progressData struct {
+	Event   string        `json:"event"`
+	Current int           `json:"current"`
+	Total   int           `json:"total"`
+	Percent float64       `json:"percent"`
+	Elapsed time.Duration `json:"elapsed_ns"`
+	// DocsPerSec is the average number of documents retrieved per second
+	DocsPerSec float64 `json:"docs_per_sec"`
+}
+
+// summaryData is available to the summary template
+type summaryData struct {
+	Event       string            `json:"event"`
+	Docs        int               `json:"docs"`
+	Total       int               `json:"total"`
+	Elapsed     time.Duration     `json:"elapsed_ns"`
+	Output      string            `json:"output"`
+	Checksums   map[string]string `json:"checksums,omitempty"`
+	Partitions  int               `json:"partitions,omitempty"`
+	Rejected    int               `json:"rejected,omitempty"`
+	DeadLetter  string            `json:"dead_letter,omitempty"`
+	Interrupted bool              `json:"interrupted"`
+	// Bytes is the size of the documents written, DocsPerSec and
+	// BytesPerSec the average throughput over the export
+	Bytes       int64             `json:"bytes"`
+	DocsPerSec  float64           `json:"docs_per_sec"`
+	BytesPerSec int64             `json:"bytes_per_sec"`
+	AvgLatency  time.Duration     `json:"avg_latency_ns"`
+	Retries     int               `json:"retries"`
+	Slices      []sliceThroughput `json:"slices,omitempty"`
+	// Verbose is set with -v, the default template then printing the
+	// throughput of every slice (which can be hundreds of them)
+	Verbose bool `json:"-"`
+}
+
+// reporter renders the progress and summary messages, either with the
+// (user overridable) text templates or as JSON lines meant for machines
+type reporter struct {
+	w        io.Writer
+	json     bool
+	progress *template.Template
+	summary  *template.Template
+	start    time.Time
+	line     progressLine
+	// lines prints every progress message on a line of its own, when stderr
+	// isn't a terminal (CI logs, nohup) where rewriting the line with
+	// carriage returns garbles the output
+	lines    bool
+	interval time.Duration
+	// quiet leaves the progress and summary out, with -quiet
+	quiet bool
+}
+
+const (
+	// terminalProgressInterval and logProgressInterval are the default time
+	// between two progress messages, on a terminal and otherwise
+	terminalProgressInterval = 500 * time.Millisecond
+	logProgressInterval      = 10 * time.Second
+)
+
+func newReporter(format, progressTemplate, summaryTemplate string, interval time.Duration) (*reporter, error) {
+	r := &reporter{w: os.Stderr, start: time.Now(), lines: !isTerminal(os.Stderr), interval: interval}
+
+	switch format {
+	case "text":
+	case "json":
+		r.json = true
+	default:
+		return nil, fmt.Errorf("Unknown progress format: %v", format)
+	}
+
+	if r.interval <= 0 {
+		r.interval = terminalProgressInterval
+
+		if r.lines && !r.json {
+			r.interval = logProgressInterval
+		}
+	}
+
+	var err error
+
+	if r.progress, err = template.New("progress").Funcs(templateFuncs).Parse(progressTemplate); err != nil {
+		return nil, fmt.Errorf("Invalid progress template: %v", err)
+	}
+
+	if r.summary, err = template.New("summary").Funcs(templateFuncs).Parse(summaryTemplate); err != nil {
+		return nil, fmt.Errorf("Invalid summary template: %v", err)
+	}
+
+	return r, nil
+}
+
+func (r *reporter) printProgress(current, total int) {
+	if r.quiet {
+		return
+	}
+
+	percent := 100.0
+
+	if total > 0 {
+		percent = (float64(current) / float64(total)) * 100.0
+	}
+
+	elapsed := time.Since(r.start)
+	data := progressData{"progress", current, total, percent, elapsed, 0}
+	data.DocsPerSec, _ = rates(current, 0, elapsed)
+
+	if r.json {
+		r.printJSON(data)
+		return
+	}
+
+	var buf bytes.Buffer
+
+	if err := r.progress.Execute(&buf, data); err != nil {
+		fmt.Fprintln(r.w, "Error rendering progress template:", err)
+		return
+	}
+
+	debug.Debug(func() { fmt.Fprintf(&buf, " (%.0f docs/s)", data.DocsPerSec) })
+
+	if r.lines {
+		fmt.Fprintln(r.w, buf.String())
+		return
+	}
+
+	r.line.print(r.w, buf.String())
+}
+
+func (r *reporter) printSummary(data summaryData) {
+	if r.quiet {
+		return
+	}
+
+	data.Event = "summary"
+	data.Elapsed = time.Since(r.start)
+	data.DocsPerSec, data.BytesPerSec = rates(data.Docs, data.Bytes, data.Elapsed)
+	debug.Debug(func() { data.Verbose = true })
+
+	if r.json {
+		r.printJSON(data)
+		return
+	}
+
+	if !r.lines {
+		fmt.Fprintln(r.w)
+	}
+
+	if err := r.summary.Execute(r.w, data); err != nil {
+		fmt.Fprintln(r.w, "Error rendering summary template:", err)
+	}
+}
+
+func (r *reporter) printJSON(v interface{}) {
+	if j, err := json.Marshal(v); err == nil {
+		fmt.Fprintf(r.w, "%s\n", j)
+	}
+}
+
+// watch prints the progress returned by progress until done is signaled,
+// then prints it one last time and signals done back
+func (r *reporter) watch(progress func() (current, total *int), done chan struct{}) {
+	lastCurrent, lastTotal := -1, -1
+	report := func() {
+		current, total := progress()
+
+		if current != nil && total != nil && (*current != lastCurrent || *total != lastTotal) {
+			r.printProgress(*current, *total)
+			lastCurrent, lastTotal = *current, *total
+		}
+	}
+timer:
+	for {
+		select {
+		case <-done:
+			break timer
+		case <-time.After(r.interval):
+			report()
+		}
+	}
+
+	report()
+	done <- struct{}{}
+}
+
+// isTerminal returns whether f is a terminal (or a Windows console) rather
+// than a file or a pipe
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// progressLine rewrites the current line using a carriage return. Leftovers
+// of a longer previous line are blanked with spaces rather than ANSI escape
+// codes, which older Windows consoles don't interpret.
+type progressLine struct {
+	lastLen int
+}
+
+func (p *progressLine) print(w io.Writer, s string) {
+	padding := ""
+
+	if len(s) < p.lastLen {
+		padding = strings.Repeat(" ", p.lastLen-len(s))
+	}
+
+	fmt.Fprintf(w, "\r%s%s", s, padding)
+	p.lastLen = len(s)
+}
+
+// processingProgress returns the documents retrieved and expected by the
+// slices started so far (slices waiting for a worker don't know their total
+// yet), or nil until one started
+func processingProgress(slices []*slice) (current, total *int) {
+	t := 0
+	c := 0
+	started := false
+
+	for _, s := range slices {
+		// The total is known once the search returned a page
+		if retrieved, expected, searched := s.progress(); searched {
+			t += expected
+			c += retrieved
+			started = true
+		}
+	}
+
+	if !started {
+		return nil, nil
+	}
+
+	return &c, &t
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/alissonsales/esexport/client"
+	"github.com/alissonsales/esexport/features"
+	"github.com/alissonsales/esexport/transform"
+)
+
+func init() {
+	features.Register("check", "quality-gate", "Fails exports not meeting document count, dead letter and field coverage expectations")
+}
+
+// exitQualityFailed is the exit status of an export that completed without
+// meeting its expectations, so pipelines can tell it from other failures
+const exitQualityFailed = 3
+
+// qualityGate holds the expectations a completed export must meet, checked
+// once it ends. It counts the documents having each required field as they
+// are written.
+type qualityGate struct {
+	minDocs       int
+	maxDocs       int
+	maxRejectRate float64
+	required      []requiredField
+
+	mu      sync.Mutex
+	docs    int
+	present []int
+}
+
+// requiredField is a field expected in at least a ratio of the documents
+type requiredField struct {
+	field    string
+	coverage float64
+}
+
+func newQualityGate(opts *cmdOpts) (*qualityGate, error) {
+	if opts.expectMinDocs < 0 || opts.expectMaxDocs < 0 || (opts.expectMaxDocs > 0 && opts.expectMinDocs > opts.expectMaxDocs) {
+		return nil, fmt.Errorf("Invalid -expectMinDocs %v and -expectMaxDocs %v", opts.expectMinDocs, opts.expectMaxDocs)
+	}
+
+	if opts.deadLetterRatio < 0 || opts.deadLetterRatio > 1 {
+		return nil, fmt.Errorf("Invalid -maxDeadLetterRatio %v (expected between 0 and 1)", opts.deadLetterRatio)
+	}
+
+	q := &qualityGate{minDocs: opts.expectMinDocs, maxDocs: opts.expectMaxDocs, maxRejectRate: opts.deadLetterRatio}
+
+	for _, r := range opts.requireFields {
+		field, ratio, hasRatio := cut(r, ":")
+		coverage := 1.0
+
+		if hasRatio {
+			c, err := strconv.ParseFloat(ratio, 64)
+
+			if err != nil || c < 0 || c > 1 {
+				return nil, fmt.Errorf("Invalid -requireField %v (expected field[:ratio] with a ratio between 0 and 1)", r)
+			}
+
+			coverage = c
+		}
+
+		if field == "" {
+			return nil, fmt.Errorf("Invalid -requireField %v (expected field[:ratio])", r)
+		}
+
+		q.required = append(q.required, requiredField{field, coverage})
+	}
+
+	q.present = make([]int, len(q.required))
+	return q, nil
+}
+
+// observe counts the required fields the written hit has (with a non null
+// value)
+func (q *qualityGate) observe(hit *client.Hit) {
+	if len(q.required) == 0 {
+		return
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.docs++
+
+	for i, r := range q.required {
+		if value, ok := transform.Get(hit.Source, r.field); ok && value != nil {
+			q.present[i]++
+		}
+	}
+}
+
+// check returns the expectations the export didn't meet, given the number
+// of documents written and sent to the dead letters
+func (q *qualityGate) check(docs, rejected int) []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var violations []string
+
+	if docs < q.minDocs {
+		violations = append(violations, fmt.Sprintf("%v documents exported, expected at least %v (-expectMinDocs)", docs, q.minDocs))
+	}
+
+	if q.maxDocs > 0 && docs > q.maxDocs {
+		violations = append(violations, fmt.Sprintf("%v documents exported, expected at most %v (-expectMaxDocs)", docs, q.maxDocs))
+	}
+
+	if total := docs + rejected; total > 0 {
+		if ratio := float64(rejected) / float64(total); ratio > q.maxRejectRate {
+			violations = append(violations, fmt.Sprintf("%v of %v documents failed (%.2f%%), expected at most %.2f%% (-maxDeadLetterRatio)",
+				rejected, total, ratio*100, q.maxRejectRate*100))
+		}
+	}
+
+	for i, r := range q.required {
+		if q.docs == 0 {
+			break
+		}
+
+		if coverage := float64(q.present[i]) / float64(q.docs); coverage < r.coverage {
+			violations = append(violations, fmt.Sprintf("%v is set in %.2f%% of the documents, expected at least %.2f%% (-requireField)",
+				r.field, coverage*100, r.coverage*100))
+		}
+	}
+
+	return violations
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// remoteOutput opens a writer streaming the output to u, the object being
+// complete once the writer is closed
+type remoteOutput func(opts *cmdOpts, u *url.URL) (io.WriteCloser, error)
+
+// remoteOutputs open an -output given as an URL, by scheme. They register
+// themselves when compiled in.
+var remoteOutputs = map[string]remoteOutput{}
+
+// isRemote returns whether the output is an URL (gs://bucket/file.json)
+// rather than a local file
+func isRemote(output string) bool {
+	return strings.Contains(output, "://")
+}
+
+// openRemote opens the remote -output, failing on options that only apply
+// to local files
+func openRemote(opts *cmdOpts) (io.WriteCloser, error) {
+	u, err := url.Parse(opts.output)
+
+	if err != nil {
+		return nil, fmt.Errorf("Invalid -output: %v", err)
+	}
+
+	var schemes []string
+
+	for s := range remoteOutputs {
+		schemes = append(schemes, s+"://")
+	}
+
+	for s := range sinks {
+		schemes = append(schemes, s+"://")
+	}
+
+	sort.Strings(schemes)
+	open, ok := remoteOutputs[u.Scheme]
+
+	if !ok {
+		return nil, fmt.Errorf("Unsupported -output %v (expected a file or %v)", redactURL(opts.output), strings.Join(schemes, ", "))
+	}
+
+	if opts.checksumSidecars {
+		return nil, errors.New("-sha256Files writes next to the output, it requires -output to be a file")
+	}
+
+	return open(opts, u)
+}
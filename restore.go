@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alissonsales/esexport/features"
+	"github.com/alissonsales/esexport/transform"
+)
+
+func init() {
+	commands["restore"] = restore
+	features.Register("command", "restore", "Recreates an index from an export and its -withMapping files and bulk loads the documents")
+}
+
+// restore recreates the index of an export written with -withMapping on the
+// target cluster and bulk loads its documents, the way -target does
+func restore(args []string) int {
+	fs := flag.NewFlagSet("esexport restore", flag.ExitOnError)
+	input := fs.String("input", "", "Export to restore: an output file, or the directory of an export with -partitionBy")
+	opts := &cmdOpts{connectTimeout: 30 * time.Second, keepAlive: 30 * time.Second}
+	fs.StringVar(&opts.target, "target", "", "Cluster the index is restored to (e.g. http://localhost:9200)")
+	fs.StringVar(&opts.targetIndex, "index", "", "Name of the restored index (defaults to the name of the exported one)")
+	fs.StringVar(&opts.targetUser, "user", "", "Username used to authenticate on -target (basic auth)")
+	fs.StringVar(&opts.targetPassword, "password", "", "Password used to authenticate on -target (basic auth)")
+	shards := fs.Int("shards", 0, "Number of primary shards of the restored index (defaults to that of the exported one)")
+	replicas := fs.Int("replicas", -1, "Number of replicas of the restored index (defaults to that of the exported one)")
+	withAliases := fs.Bool("aliases", true, "Also restore the aliases of the index")
+	fs.IntVar(&opts.httpBatchSize, "batchSize", 1000, "Number of documents per bulk request")
+	fs.IntVar(&opts.httpRetries, "retries", 3, "Number of times a bulk request is retried on errors, 429 and 5xx responses")
+	fs.StringVar(&opts.egressAllow, "egressAllow", "", "Comma separated list of hosts (host or host:port) the restore may connect to")
+	fs.Parse(args)
+
+	if *input == "" || opts.target == "" {
+		fmt.Fprintln(os.Stderr, "Error parsing options: -input and -target are required")
+		return 1
+	}
+
+	files, metadataPath, err := restoreInput(*input)
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error reading the export:", err)
+		return 1
+	}
+
+	index, body, err := restoredIndex(metadataPath, *shards, *replicas, *withAliases)
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error reading the index metadata:", err)
+		return 1
+	}
+
+	if opts.targetIndex == "" {
+		opts.targetIndex = index
+	}
+
+	if opts.targetIndex == "" {
+		fmt.Fprintln(os.Stderr, "Error parsing options: -index is required to restore an export without -withMapping files")
+		return 1
+	}
+
+	h, err := openTarget(opts)
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error parsing options:", err)
+		return 1
+	}
+
+	if body == nil {
+		fmt.Fprintln(os.Stderr, "No -withMapping files next to the export, documents are indexed with the mapping of the existing or dynamically created index")
+	} else if err := targetRequest(h, http.MethodPut, opts.target, "/"+opts.targetIndex, body); err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating index %v: %v\n", opts.targetIndex, err)
+		return 1
+	}
+
+	for _, file := range files {
+		if err := loadFile(h, file); err != nil {
+			h.Close()
+			fmt.Fprintf(os.Stderr, "Error restoring %v: %v\n", file, err)
+			return 1
+		}
+	}
+
+	if err := h.Close(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error restoring the documents:", err)
+		return 1
+	}
+
+	if err := targetRequest(h, http.MethodPost, opts.target, "/"+opts.targetIndex+"/_refresh", nil); err != nil {
+		fmt.Fprintf(os.Stderr, "Error refreshing index %v: %v\n", opts.targetIndex, err)
+		return 1
+	}
+
+	fmt.Fprintf(os.Stderr, "Restored %v documents into %v\n", h.posted, opts.targetIndex)
+	return 0
+}
+
+// restoreInput returns the files holding the documents of an export and the
+// path of its -withMapping files by name (mapping, settings or aliases)
+func restoreInput(input string) ([]string, func(name string) string, error) {
+	info, err := os.Stat(input)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !info.IsDir() {
+		return []string{input}, func(name string) string { return input + "." + name + ".json" }, nil
+	}
+
+	// The documents of a partitioned export are in the partition
+	// directories, next to which the metadata files are written
+	var files []string
+
+	err = filepath.Walk(input, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !info.IsDir() && filepath.Dir(path) != filepath.Clean(input) {
+			files = append(files, path)
+		}
+
+		return nil
+	})
+
+	sort.Strings(files)
+	return files, func(name string) string { return filepath.Join(input, name+".json") }, err
+}
+
+// restoredIndex returns the name of the exported index and the body
+// creating it again from its metadata files, nil without a mapping file
+func restoredIndex(metadataPath func(string) string, shards, replicas int, withAliases bool) (string, map[string]interface{}, error) {
+	body := map[string]interface{}{}
+	index := ""
+	parts := []struct{ name, key string }{{"mapping", "mappings"}, {"settings", "settings"}, {"aliases", "aliases"}}
+
+	for _, part := range parts {
+		if part.name == "aliases" && !withAliases {
+			continue
+		}
+
+		content, err := ioutil.ReadFile(metadataPath(part.name))
+
+		if os.IsNotExist(err) && part.name == "mapping" {
+			return "", nil, nil
+		}
+
+		if err != nil {
+			return "", nil, err
+		}
+
+		var metadata map[string]map[string]interface{}
+
+		if err := json.Unmarshal(content, &metadata); err != nil {
+			return "", nil, fmt.Errorf("Invalid %v: %v", metadataPath(part.name), err)
+		}
+
+		if len(metadata) != 1 {
+			return "", nil, fmt.Errorf("%v holds %v indices, only exports of a single index can be restored", metadataPath(part.name), len(metadata))
+		}
+
+		for name, m := range metadata {
+			index = name
+			body[part.key] = m[part.key]
+		}
+	}
+
+	if settings, ok := body["settings"].(map[string]interface{}); ok {
+		if shards > 0 {
+			transform.Set(settings, "index.number_of_shards", strconv.Itoa(shards))
+		}
+
+		if replicas >= 0 {
+			transform.Set(settings, "index.number_of_replicas", strconv.Itoa(replicas))
+		}
+	}
+
+	return index, body, nil
+}
+
+// targetRequest sends a request to the cluster of the bulk requests of h
+func targetRequest(h *httpOutput, method, target, path string, body interface{}) error {
+	var reader io.Reader
+
+	if body != nil {
+		j, err := json.Marshal(body)
+
+		if err != nil {
+			return err
+		}
+
+		reader = bytes.NewReader(j)
+	}
+
+	req, err := http.NewRequest(method, strings.TrimSuffix(target, "/")+path, reader)
+
+	if err != nil {
+		return err
+	}
+
+	req.Header = h.header.Clone()
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := h.client.Do(req)
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+	respBody, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%v: %s", resp.Status, respBody)
+	}
+
+	return nil
+}
+
+// loadFile writes the documents of the file to h, decompressing gzip files
+func loadFile(h *httpOutput, path string) error {
+	switch filepath.Ext(path) {
+	case ".zst", ".lz4", ".age":
+		return errors.New("only gzip compressed files can be restored, decompress or decrypt it first")
+	}
+
+	f, err := os.Open(path)
+
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+	var r io.Reader = bufio.NewReader(f)
+
+	if filepath.Ext(path) == ".gz" {
+		gz, err := gzip.NewReader(r)
+
+		if err != nil {
+			return err
+		}
+
+		defer gz.Close()
+		r = gz
+	}
+
+	// The last line may lack its newline, which would join it to the first
+	// document of the next file
+	if _, err := io.Copy(h, r); err != nil {
+		return err
+	}
+
+	_, err = h.Write([]byte("\n"))
+	return err
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"errors"
+)
+
+// checkResume checks -resumeScroll, which continues the scroll of a single
+// slice of an export that died, as the only slice of the export
+func checkResume(opts *cmdOpts, routed bool) error {
+	if opts.resumeScroll == "" {
+		if opts.resumeSlice != 0 || opts.resumeDocs != 0 {
+			return errors.New("-resumeSlice and -resumeDocs require -resumeScroll")
+		}
+
+		return nil
+	}
+
+	if opts.resumeSlice < 0 || opts.resumeDocs < 0 {
+		return errors.New("-resumeSlice and -resumeDocs can't be negative")
+	}
+
+	switch {
+	case opts.mode == modeAggregation:
+		return errors.New("-resumeScroll can't be used with -mode agg, aggregations aren't scrolled")
+	case routed:
+		return errors.New("-resumeScroll can't be used with a -routing mapping, the scroll is a single slice")
+	case opts.stealWork:
+		return errors.New("-resumeScroll can't be used with -stealWork, the scroll is a single slice")
+	case opts.chunkField != "" || opts.idsFile != "":
+		return errors.New("-resumeScroll can't be used with -chunkByField or -idsFile, the scroll is a single slice")
+	case opts.follow:
+		return errors.New("-resumeScroll can't be used with -follow")
+	case opts.sliceRetries > 0:
+		return errors.New("-resumeScroll can't be used with -sliceRetries, the slice can't be searched again")
+	}
+
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/alissonsales/esexport/client"
+	"github.com/alissonsales/esexport/features"
+)
+
+func init() {
+	features.Register("strategy", "routed-slices", "Exports the documents of each routing value (or group of them) in its own slice (-routing a,b,c)")
+}
+
+// sliceRouting returns the routing values of every slice when -routing holds
+// several of them, assigned round-robin to -sliceSize slices, or a mapping
+// of slice ids to values (from the config file), which sets the number of
+// slices. It returns nil for a single value, which routes every slice.
+func sliceRouting(opts *cmdOpts) ([][]string, error) {
+	if strings.HasPrefix(strings.TrimSpace(opts.routing), "{") {
+		var mapping map[string]string
+
+		if err := json.Unmarshal([]byte(opts.routing), &mapping); err != nil {
+			return nil, fmt.Errorf("Invalid -routing mapping: %v", err)
+		}
+
+		routing := make([][]string, len(mapping))
+
+		for id, values := range mapping {
+			i, err := strconv.Atoi(id)
+
+			if err != nil || i < 0 || i >= len(mapping) {
+				return nil, fmt.Errorf("Invalid -routing mapping: slice ids must go from 0 to %v, got %v", len(mapping)-1, id)
+			}
+
+			if routing[i] = splitList(values); len(routing[i]) == 0 {
+				return nil, fmt.Errorf("Invalid -routing mapping: no routing for slice %v", i)
+			}
+		}
+
+		return routing, nil
+	}
+
+	values := splitList(opts.routing)
+
+	if len(values) < 2 {
+		return nil, nil
+	}
+
+	// Slices without routing values would have nothing to export
+	slices := opts.sliceSize
+
+	if slices > len(values) {
+		slices = len(values)
+	}
+
+	routing := make([][]string, slices)
+
+	for i, value := range values {
+		routing[i%slices] = append(routing[i%slices], value)
+	}
+
+	return routing, nil
+}
+
+// routingFilter matches the documents routed by one of the values. Routing
+// only picks the shards searched, which hold other routing values as well.
+func routingFilter(values []string) map[string]interface{} {
+	return map[string]interface{}{"terms": map[string]interface{}{"_routing": values}}
+}
+
+// routedClient searches with the routing of its slice
+type routedClient struct {
+	*client.Client
+	routing string
+}
+
+func (c *routedClient) Search(searchBody map[string]interface{}) (*client.ESSearchResponse, error) {
+	return c.Client.SearchWithRouting(searchBody, c.routing)
+}
+
+func (c *routedClient) SearchEach(searchBody map[string]interface{}, hit func(*client.Hit) error) (*client.ESSearchResponse, error) {
+	return c.Client.SearchEachWithRouting(searchBody, c.routing, hit)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"github.com/alissonsales/esexport/features"
+)
+
+func init() {
+	features.Register("strategy", "sample", "Exports a random sample of the documents (-sample)")
+}
+
+// sampledQuery returns the query restricted to a random share of its
+// documents: every document gets a random score between 0 and 1, the same for
+// a given seed, and those under 1 - ratio are left out. A seed of 0 picks one.
+func sampledQuery(query map[string]interface{}, ratio float64, seed int64) (map[string]interface{}, int64, error) {
+	if ratio <= 0 || ratio > 1 {
+		return nil, 0, errors.New("-sample must be between 0 (excluded) and 1")
+	}
+
+	if seed == 0 {
+		seed = time.Now().UnixNano() % (1 << 31)
+	}
+
+	var original interface{} = map[string]interface{}{"match_all": map[string]interface{}{}}
+
+	if q, ok := query["query"]; ok {
+		original = q
+	}
+
+	q := make(map[string]interface{}, len(query)+1)
+
+	for k, v := range query {
+		q[k] = v
+	}
+
+	// The score is computed from the seed and _seq_no, which has doc values
+	// unlike _id
+	q["query"] = map[string]interface{}{"function_score": map[string]interface{}{
+		"query":        original,
+		"random_score": map[string]interface{}{"seed": seed, "field": "_seq_no"},
+		"boost_mode":   "replace",
+		"min_score":    1 - ratio,
+	}}
+
+	return q, seed, nil
+}
@@ -0,0 +1,434 @@
+// Package selfupdate replaces the running binary with the latest release
+// published on GitHub, verifying it against the release checksums, themselves
+// signed with the release signing key
+package selfupdate
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// LatestReleaseURL is the GitHub API endpoint describing the latest release
+const LatestReleaseURL = "https://api.github.com/repos/alissonsales/esexport/releases/latest"
+
+// checksumsAsset is the name of the checksums file published by goreleaser
+const checksumsAsset = "checksums.txt"
+
+// signatureAsset is the Ed25519 signature of the checksums file (the raw 64
+// bytes, as written by openssl pkeyutl -sign -rawin). The checksums come from
+// the same release as the archives, the signature is what ties them to the
+// key the binary was built with.
+const signatureAsset = checksumsAsset + ".sig"
+
+// A HTTPClient is required to download the release
+type HTTPClient interface {
+	Get(string) (*http.Response, error)
+}
+
+// Release represents a GitHub release
+type Release struct {
+	TagName string  `json:"tag_name"`
+	Assets  []Asset `json:"assets"`
+}
+
+// Asset represents a file attached to a GitHub release
+type Asset struct {
+	Name string `json:"name"`
+	URL  string `json:"browser_download_url"`
+}
+
+// Updater downloads releases and swaps the binary in place
+type Updater struct {
+	client     HTTPClient
+	releaseURL string
+	publicKey  ed25519.PublicKey
+	goos       string
+	goarch     string
+}
+
+// NewUpdater returns an Updater fetching releases for the running platform,
+// accepting those whose checksums are signed with publicKey
+func NewUpdater(client HTTPClient, releaseURL string, publicKey ed25519.PublicKey) *Updater {
+	return &Updater{client, releaseURL, publicKey, runtime.GOOS, runtime.GOARCH}
+}
+
+// ParsePublicKey decodes a base64 encoded Ed25519 public key
+func ParsePublicKey(s string) (ed25519.PublicKey, error) {
+	key, err := base64.StdEncoding.DecodeString(s)
+
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, errors.New("Invalid release signing key (expected a base64 encoded Ed25519 public key)")
+	}
+
+	return ed25519.PublicKey(key), nil
+}
+
+// LatestRelease returns the latest published release
+func (u *Updater) LatestRelease() (*Release, error) {
+	body, err := u.download(u.releaseURL)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var release Release
+
+	if err := json.Unmarshal(body, &release); err != nil {
+		return nil, fmt.Errorf("Error decoding release: %v", err)
+	}
+
+	return &release, nil
+}
+
+// CompareVersions compares two semantic versions (e.g. v1.2.3 or
+// 1.3.0-rc.1), returning -1, 0 or 1 when a is older than, the same as or
+// newer than b. Build metadata (+...) is ignored.
+func CompareVersions(a, b string) (int, error) {
+	va, err := parseVersion(a)
+
+	if err != nil {
+		return 0, err
+	}
+
+	vb, err := parseVersion(b)
+
+	if err != nil {
+		return 0, err
+	}
+
+	for i := range va.numbers {
+		if c := compareInts(va.numbers[i], vb.numbers[i]); c != 0 {
+			return c, nil
+		}
+	}
+
+	// A pre-release comes before the release
+	switch {
+	case len(va.pre) == 0 && len(vb.pre) == 0:
+		return 0, nil
+	case len(va.pre) == 0:
+		return 1, nil
+	case len(vb.pre) == 0:
+		return -1, nil
+	}
+
+	for i := 0; i < len(va.pre) && i < len(vb.pre); i++ {
+		if c := comparePrerelease(va.pre[i], vb.pre[i]); c != 0 {
+			return c, nil
+		}
+	}
+
+	return compareInts(len(va.pre), len(vb.pre)), nil
+}
+
+// semver is a parsed semantic version
+type semver struct {
+	numbers [3]int
+	pre     []string
+}
+
+func parseVersion(v string) (semver, error) {
+	var parsed semver
+	s := strings.TrimPrefix(v, "v")
+
+	if i := strings.Index(s, "+"); i >= 0 {
+		s = s[:i]
+	}
+
+	if i := strings.Index(s, "-"); i >= 0 {
+		parsed.pre = strings.Split(s[i+1:], ".")
+		s = s[:i]
+	}
+
+	parts := strings.Split(s, ".")
+
+	if len(parts) != 3 {
+		return parsed, fmt.Errorf("Invalid version %v (expected major.minor.patch)", v)
+	}
+
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+
+		if err != nil || n < 0 {
+			return parsed, fmt.Errorf("Invalid version %v (expected major.minor.patch)", v)
+		}
+
+		parsed.numbers[i] = n
+	}
+
+	return parsed, nil
+}
+
+// comparePrerelease compares pre-release identifiers, numeric ones coming
+// before the others
+func comparePrerelease(a, b string) int {
+	na, errA := strconv.Atoi(a)
+	nb, errB := strconv.Atoi(b)
+
+	switch {
+	case errA == nil && errB == nil:
+		return compareInts(na, nb)
+	case errA == nil:
+		return -1
+	case errB == nil:
+		return 1
+	}
+
+	return strings.Compare(a, b)
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+
+	return 0
+}
+
+// Update replaces the binary at exePath with the one from the given release,
+// after checking the signature of the release checksums and the downloaded
+// archive against them
+func (u *Updater) Update(release *Release, exePath string) error {
+	archive, err := u.archiveAsset(release)
+
+	if err != nil {
+		return err
+	}
+
+	checksums, err := u.checksums(release)
+
+	if err != nil {
+		return err
+	}
+
+	expected, ok := checksums[archive.Name]
+
+	if !ok {
+		return fmt.Errorf("No checksum published for %v", archive.Name)
+	}
+
+	content, err := u.download(archive.URL)
+
+	if err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256(content)
+
+	if hex.EncodeToString(sum[:]) != expected {
+		return fmt.Errorf("Checksum mismatch for %v: expected %v, got %x", archive.Name, expected, sum)
+	}
+
+	binary, err := extractBinary(content, u.binaryName())
+
+	if err != nil {
+		return err
+	}
+
+	return replaceBinary(exePath, binary, u.goos == "windows")
+}
+
+// archiveAsset finds the release archive built for the platform, named by
+// goreleaser as esexport_<version>_<Os>_<arch>.tar.gz
+func (u *Updater) archiveAsset(release *Release) (*Asset, error) {
+	suffix := fmt.Sprintf("_%v_%v.tar.gz", goreleaserOS(u.goos), u.goarch)
+
+	for i, asset := range release.Assets {
+		if strings.HasSuffix(asset.Name, suffix) {
+			return &release.Assets[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("Release %v has no archive for %v/%v", release.TagName, u.goos, u.goarch)
+}
+
+// checksums returns the checksums of the release, once their signature is
+// verified
+func (u *Updater) checksums(release *Release) (map[string]string, error) {
+	if len(u.publicKey) == 0 {
+		return nil, errors.New("This build has no release signing key to verify the release with, download it manually")
+	}
+
+	content, err := u.downloadAsset(release, checksumsAsset)
+
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := u.downloadAsset(release, signatureAsset)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !ed25519.Verify(u.publicKey, content, signature) {
+		return nil, fmt.Errorf("The signature of %v doesn't match the release signing key", checksumsAsset)
+	}
+
+	return parseChecksums(content), nil
+}
+
+// downloadAsset downloads the asset of the release with the given name
+func (u *Updater) downloadAsset(release *Release, name string) ([]byte, error) {
+	for _, asset := range release.Assets {
+		if asset.Name == name {
+			return u.download(asset.URL)
+		}
+	}
+
+	return nil, fmt.Errorf("Release %v has no %v", release.TagName, name)
+}
+
+func (u *Updater) binaryName() string {
+	if u.goos == "windows" {
+		return "esexport.exe"
+	}
+
+	return "esexport"
+}
+
+func (u *Updater) download(url string) ([]byte, error) {
+	resp, err := u.client.Get(url)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Unexpected response downloading %v: %v", url, resp.StatusCode)
+	}
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+// goreleaserOS applies the archive name replacements of .goreleaser.yml
+func goreleaserOS(goos string) string {
+	switch goos {
+	case "darwin":
+		return "Darwin"
+	case "linux":
+		return "Linux"
+	}
+
+	return goos
+}
+
+// parseChecksums parses a sha256sum formatted file into a map of file names
+// to hex encoded checksums
+func parseChecksums(content []byte) map[string]string {
+	checksums := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+
+		if len(fields) == 2 {
+			checksums[fields[1]] = strings.ToLower(fields[0])
+		}
+	}
+
+	return checksums
+}
+
+func extractBinary(archive []byte, name string) ([]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+
+	if err != nil {
+		return nil, err
+	}
+
+	tr := tar.NewReader(gz)
+
+	for {
+		header, err := tr.Next()
+
+		if err == io.EOF {
+			return nil, fmt.Errorf("Archive doesn't contain %v", name)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if header.Typeflag == tar.TypeReg && filepath.Base(header.Name) == name {
+			return ioutil.ReadAll(tr)
+		}
+	}
+}
+
+// rename is os.Rename, replaced by tests
+var rename = os.Rename
+
+// replaceBinary writes the new binary next to the current one and renames it
+// over it. Windows doesn't allow replacing a running executable, so there
+// (moveCurrent) the current one is moved out of the way first, and put back
+// when the new one can't take its place.
+func replaceBinary(exePath string, binary []byte, moveCurrent bool) error {
+	if len(binary) == 0 {
+		return errors.New("Downloaded binary is empty")
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(exePath), ".esexport-update-")
+
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(binary); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Chmod(tmp.Name(), 0755); err != nil {
+		return err
+	}
+
+	if !moveCurrent {
+		return rename(tmp.Name(), exePath)
+	}
+
+	old := exePath + ".old"
+	os.Remove(old)
+
+	if err := rename(exePath, old); err != nil {
+		return err
+	}
+
+	if err := rename(tmp.Name(), exePath); err != nil {
+		if restoreErr := rename(old, exePath); restoreErr != nil {
+			return fmt.Errorf("%v (the previous binary is left as %v: %v)", err, old, restoreErr)
+		}
+
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,259 @@
+package selfupdate
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type MockHTTPClient struct {
+	Responses map[string]string
+}
+
+func (m *MockHTTPClient) Get(url string) (*http.Response, error) {
+	body, ok := m.Responses[url]
+
+	if !ok {
+		return &http.Response{StatusCode: 404, Body: ioutil.NopCloser(strings.NewReader(""))}, nil
+	}
+
+	return &http.Response{StatusCode: 200, Body: ioutil.NopCloser(strings.NewReader(body))}, nil
+}
+
+func tarGz(t *testing.T, name string, content []byte) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0755, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+		t.Fatalf("Failed to write tar header: %v", err)
+	}
+
+	tw.Write(content)
+	tw.Close()
+	gz.Close()
+
+	return buf.Bytes()
+}
+
+func newKey(t *testing.T) (ed25519.PublicKey, ed25519.PrivateKey) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	return public, private
+}
+
+// mockRelease returns a client serving a release with the archive, its
+// checksums signed with key
+func mockRelease(t *testing.T, archive []byte, checksum string, key ed25519.PrivateKey) *MockHTTPClient {
+	if checksum == "" {
+		checksum = fmt.Sprintf("%x", sha256.Sum256(archive))
+	}
+
+	checksums := "0000  esexport_1.1.0_Darwin_amd64.tar.gz\n" + checksum + "  esexport_1.1.0_Linux_amd64.tar.gz\n"
+
+	release := `{
+		"tag_name": "v1.1.0",
+		"assets": [
+			{"name": "esexport_1.1.0_Darwin_amd64.tar.gz", "browser_download_url": "http://dl/darwin"},
+			{"name": "esexport_1.1.0_Linux_amd64.tar.gz", "browser_download_url": "http://dl/linux"},
+			{"name": "checksums.txt", "browser_download_url": "http://dl/checksums"},
+			{"name": "checksums.txt.sig", "browser_download_url": "http://dl/checksums.sig"}
+		]
+	}`
+
+	return &MockHTTPClient{map[string]string{
+		"http://releases":         release,
+		"http://dl/linux":         string(archive),
+		"http://dl/checksums":     checksums,
+		"http://dl/checksums.sig": string(ed25519.Sign(key, []byte(checksums))),
+	}}
+}
+
+func TestLatestRelease(t *testing.T) {
+	public, private := newKey(t)
+	mockClient := mockRelease(t, nil, "", private)
+	updater := NewUpdater(mockClient, "http://releases", public)
+
+	release, err := updater.LatestRelease()
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if release.TagName != "v1.1.0" {
+		t.Errorf("Expected tag to be '%v', got '%v'", "v1.1.0", release.TagName)
+	}
+
+	if len(release.Assets) != 4 {
+		t.Errorf("Expected %v assets, got %v", 4, len(release.Assets))
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "selfupdate")
+
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	exePath := filepath.Join(dir, "esexport")
+	ioutil.WriteFile(exePath, []byte("old binary"), 0755)
+
+	archive := tarGz(t, "esexport", []byte("new binary"))
+	public, private := newKey(t)
+	_, otherPrivate := newKey(t)
+
+	scenarios := []struct {
+		goos       string
+		checksum   string
+		signingKey ed25519.PrivateKey
+		publicKey  ed25519.PublicKey
+		err        string
+		binary     string
+	}{
+		{"windows", "", private, public, "Release v1.1.0 has no archive for windows/amd64", "old binary"},
+		{"linux", "abcd", private, public, fmt.Sprintf("Checksum mismatch for esexport_1.1.0_Linux_amd64.tar.gz: expected abcd, got %x", sha256.Sum256(archive)), "old binary"},
+		{"linux", "", otherPrivate, public, "The signature of checksums.txt doesn't match the release signing key", "old binary"},
+		{"linux", "", private, nil, "This build has no release signing key to verify the release with, download it manually", "old binary"},
+		{"linux", "", private, public, "", "new binary"},
+	}
+
+	for _, scenario := range scenarios {
+		updater := NewUpdater(mockRelease(t, archive, scenario.checksum, scenario.signingKey), "http://releases", scenario.publicKey)
+		updater.goos, updater.goarch = scenario.goos, "amd64"
+
+		release, err := updater.LatestRelease()
+
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		err = updater.Update(release, exePath)
+
+		if scenario.err == "" && err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+
+		if scenario.err != "" && (err == nil || err.Error() != scenario.err) {
+			t.Errorf("Expected error '%v', got '%v'", scenario.err, err)
+		}
+
+		binary, _ := ioutil.ReadFile(exePath)
+
+		if string(binary) != scenario.binary {
+			t.Errorf("Expected binary to be '%v', got '%s'", scenario.binary, binary)
+		}
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	scenarios := []struct {
+		a, b     string
+		expected int
+		err      bool
+	}{
+		{"v1.2.3", "1.2.3", 0, false},
+		{"v1.10.0", "v1.9.0", 1, false},
+		// A rolled back release is older than the installed version
+		{"v1.2.0", "v1.3.0", -1, false},
+		{"v2.0.0", "v1.99.99", 1, false},
+		{"v1.3.0-rc.1", "v1.3.0", -1, false},
+		{"v1.3.0-rc.2", "v1.3.0-rc.10", -1, false},
+		{"v1.3.0-rc.1", "v1.3.0-beta", 1, false},
+		{"v1.3.0-rc", "v1.3.0-rc.1", -1, false},
+		{"v1.3.0+build.5", "v1.3.0", 0, false},
+		{"v1.3.0", "dev", 0, true},
+		{"v1.3", "v1.3.0", 0, true},
+	}
+
+	for _, scenario := range scenarios {
+		c, err := CompareVersions(scenario.a, scenario.b)
+
+		if scenario.err != (err != nil) {
+			t.Errorf("Unexpected error comparing %v and %v: %v", scenario.a, scenario.b, err)
+			continue
+		}
+
+		if c != scenario.expected {
+			t.Errorf("Expected comparing %v and %v to return %v, got %v", scenario.a, scenario.b, scenario.expected, c)
+		}
+	}
+}
+
+func TestParseChecksums(t *testing.T) {
+	checksums := parseChecksums([]byte("ABCD  file_a.tar.gz\n\nef01  file_b.tar.gz\ninvalid line here\n"))
+
+	if len(checksums) != 2 {
+		t.Errorf("Expected %v checksums, got %v", 2, len(checksums))
+	}
+
+	if checksums["file_a.tar.gz"] != "abcd" {
+		t.Errorf("Expected checksum of file_a.tar.gz to be '%v', got '%v'", "abcd", checksums["file_a.tar.gz"])
+	}
+
+	if checksums["file_b.tar.gz"] != "ef01" {
+		t.Errorf("Expected checksum of file_b.tar.gz to be '%v', got '%v'", "ef01", checksums["file_b.tar.gz"])
+	}
+}
+
+func TestReplaceBinaryRestoresCurrentOne(t *testing.T) {
+	dir, err := ioutil.TempDir("", "selfupdate")
+
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+
+	defer os.RemoveAll(dir)
+	defer func() { rename = os.Rename }()
+
+	exePath := filepath.Join(dir, "esexport.exe")
+	ioutil.WriteFile(exePath, []byte("old binary"), 0755)
+
+	// The new binary fails to take the place of the current one, moved away
+	rename = func(from, to string) error {
+		if strings.Contains(from, ".esexport-update-") {
+			return errors.New("access denied")
+		}
+
+		return os.Rename(from, to)
+	}
+
+	if err := replaceBinary(exePath, []byte("new binary"), true); err == nil || err.Error() != "access denied" {
+		t.Errorf("Expected error 'access denied', got '%v'", err)
+	}
+
+	if binary, _ := ioutil.ReadFile(exePath); string(binary) != "old binary" {
+		t.Errorf("Expected the current binary to be put back, got '%s'", binary)
+	}
+
+	rename = os.Rename
+
+	if err := replaceBinary(exePath, []byte("new binary"), true); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if binary, _ := ioutil.ReadFile(exePath); string(binary) != "new binary" {
+		t.Errorf("Expected binary to be 'new binary', got '%s'", binary)
+	}
+
+	if old, _ := ioutil.ReadFile(exePath + ".old"); string(old) != "old binary" {
+		t.Errorf("Expected the previous binary to be left as .old, got '%s'", old)
+	}
+}
@@ -0,0 +1,110 @@
+//go:build !minimal
+// +build !minimal
+
+package main
+
+import (
+	"crypto/ed25519"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/alissonsales/esexport/features"
+	"github.com/alissonsales/esexport/selfupdate"
+)
+
+func init() {
+	commands["self-update"] = selfUpdate
+	features.Register("command", "self-update", "Replaces the binary with the latest GitHub release")
+}
+
+// releasePublicKey is the base64 encoded Ed25519 key the checksums of the
+// releases are signed with, set when building a release (-ldflags
+// "-X main.releasePublicKey=..."). Builds without it can't self-update.
+var releasePublicKey string
+
+func selfUpdate(args []string) int {
+	fs := flag.NewFlagSet("esexport self-update", flag.ExitOnError)
+	check := fs.Bool("check", false, "Only report whether a newer release is available")
+	force := fs.Bool("force", false, "Install the latest release even when it's older than the current version (or the versions can't be compared)")
+	egressAllow := fs.String("egressAllow", "", "Comma separated list of hosts (host or host:port) the update may connect to")
+	fs.Parse(args)
+
+	allowlist := egressAllowlist(splitList(*egressAllow))
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = allowlist.dialer((&net.Dialer{Timeout: 30 * time.Second}).DialContext)
+
+	var publicKey ed25519.PublicKey
+
+	if releasePublicKey != "" {
+		key, err := selfupdate.ParsePublicKey(releasePublicKey)
+
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to update esexport:", err)
+			return 1
+		}
+
+		publicKey = key
+	}
+
+	httpClient := &http.Client{Transport: allowlist.transport(transport), Timeout: 5 * time.Minute}
+	updater := selfupdate.NewUpdater(httpClient, selfupdate.LatestReleaseURL, publicKey)
+	release, err := updater.LatestRelease()
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to retrieve the latest release:", err)
+		return 1
+	}
+
+	newer, err := selfupdate.CompareVersions(release.TagName, version)
+
+	if err == nil && newer == 0 {
+		fmt.Fprintf(os.Stderr, "esexport is up to date (%v)\n", version)
+		return 0
+	}
+
+	// A release rolled back (or published out of order) would downgrade
+	// every install
+	if (err != nil || newer < 0) && !*force {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Can't compare the latest release %v with the current version %v: %v\n", release.TagName, version, err)
+		} else {
+			fmt.Fprintf(os.Stderr, "The latest release %v is older than the current version %v\n", release.TagName, version)
+		}
+
+		if *check {
+			return 0
+		}
+
+		fmt.Fprintln(os.Stderr, "Not updating, use -force to install it anyway")
+		return 1
+	}
+
+	if *check {
+		fmt.Fprintf(os.Stderr, "esexport %v is available (current version: %v)\n", release.TagName, version)
+		return 0
+	}
+
+	exePath, err := os.Executable()
+
+	if err == nil {
+		exePath, err = filepath.EvalSymlinks(exePath)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to locate the esexport binary:", err)
+		return 1
+	}
+
+	if err := updater.Update(release, exePath); err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to update esexport:", err)
+		return 1
+	}
+
+	fmt.Fprintf(os.Stderr, "Updated esexport from %v to %v\n", version, release.TagName)
+	return 0
+}
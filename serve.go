@@ -0,0 +1,451 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alissonsales/esexport/features"
+)
+
+func init() {
+	commands["serve"] = serve
+	features.Register("command", "serve", "Runs exports submitted to an HTTP API (esexport serve -addr :8080)")
+}
+
+// Statuses of the jobs run by serve
+const (
+	jobRunning   = "running"
+	jobCompleted = "completed"
+	jobFailed    = "failed"
+	jobCanceled  = "canceled"
+)
+
+// errTooManyJobs is returned when submitting a job while -maxJobs are running
+var errTooManyJobs = errors.New("Too many jobs running, try again later")
+
+// jobOptions are the flags jobs submitted to serve can set. Those running
+// commands, reading or writing local paths other than the outputs (kept under
+// -outputRoot), sending data elsewhere than the output or changing the
+// settings of the whole process are left out.
+var jobOptions = map[string]bool{
+	"host": true, "user": true, "password": true, "index": true, "type": true, "routing": true, "query": true, "param": true,
+	"searchContextTTL": true, "sliceSize": true, "sliceField": true, "workers": true, "chunkByField": true, "chunkInterval": true,
+	"stealWork": true, "sliceRetries": true, "splitByIndex": true, "follow": true, "timestampField": true, "pollInterval": true, "followLag": true,
+	"output": true, "withMapping": true, "format": true, "docVersion": true, "seqNoPrimaryTerm": true, "idsOnly": true,
+	"includeFields": true, "excludeFields": true, "docvalueFields": true, "storedFields": true, "filterPath": true,
+	"requestTimeout": true, "connectTimeout": true, "keepAlive": true, "disableKeepAlives": true, "maxIdleConnsPerHost": true,
+	"maxResponseBytes": true, "maxFailedShards": true, "breakerFailures": true, "breakerInterval": true, "searchMethod": true,
+	"methodOverride": true, "compression": true, "requireHealth": true, "waitForHealth": true, "slowThreshold": true,
+	"maxMemoryMB": true, "prefetch": true, "writeBufferSize": true, "compress": true, "compressLevel": true,
+	"skipSpaceCheck": true, "storeSizeRatio": true, "spaceEstimate": true, "maxWriteBytesPerSec": true, "minFreeSpaceMB": true,
+	"rename": true, "drop": true, "set": true, "coerce": true, "hashFields": true, "redactFields": true, "hashSalt": true,
+	"partitionBy": true, "maxOpenPartitions": true, "deadLetter": true, "sample": true, "sampleSeed": true, "maxDocs": true,
+	"expectMinDocs": true, "expectMaxDocs": true, "maxDeadLetterRatio": true, "requireField": true, "manifest": true,
+	"skipIfUnchanged": true, "gracePeriod": true, "sha256Files": true, "append": true, "atomic": true, "verify": true,
+}
+
+// secretJobOptions are the job options left out when a job is returned
+var secretJobOptions = map[string]bool{"password": true, "hashSalt": true}
+
+// serveJob is an export submitted to serve. Its progress and summary are the
+// JSON events of -progressFormat json, kept as they were reported.
+type serveJob struct {
+	mu       sync.Mutex
+	id       int
+	options  map[string]interface{}
+	status   string
+	exitCode int
+	started  time.Time
+	ended    time.Time
+	progress json.RawMessage
+	summary  json.RawMessage
+	cancel   context.CancelFunc
+}
+
+// Write receives the JSON lines of the reporter of the export
+func (j *serveJob) Write(p []byte) (int, error) {
+	var event struct {
+		Event string `json:"event"`
+	}
+
+	if err := json.Unmarshal(p, &event); err != nil {
+		return len(p), nil
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	line := append(json.RawMessage(nil), p...)
+
+	switch event.Event {
+	case "progress":
+		j.progress = line
+	case "summary":
+		j.summary = line
+	}
+
+	return len(p), nil
+}
+
+func (j *serveJob) MarshalJSON() ([]byte, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	v := struct {
+		ID       int                    `json:"id"`
+		Options  map[string]interface{} `json:"options"`
+		Status   string                 `json:"status"`
+		ExitCode *int                   `json:"exit_code,omitempty"`
+		Started  time.Time              `json:"started"`
+		Ended    *time.Time             `json:"ended,omitempty"`
+		Progress json.RawMessage        `json:"progress,omitempty"`
+		Summary  json.RawMessage        `json:"summary,omitempty"`
+	}{ID: j.id, Options: redactedOptions(j.options), Status: j.status, Started: j.started, Progress: j.progress, Summary: j.summary}
+
+	if j.status != jobRunning {
+		exitCode, ended := j.exitCode, j.ended
+		v.ExitCode, v.Ended = &exitCode, &ended
+	}
+
+	return json.Marshal(v)
+}
+
+// redactedOptions returns the options of a job without its secrets, the
+// credentials of its host included
+func redactedOptions(options map[string]interface{}) map[string]interface{} {
+	redacted := make(map[string]interface{}, len(options))
+
+	for name, value := range options {
+		if secretJobOptions[name] {
+			redacted[name] = "[redacted]"
+			continue
+		}
+
+		if host, ok := value.(string); ok && name == "host" {
+			value = redactURL(host)
+		}
+
+		redacted[name] = value
+	}
+
+	return redacted
+}
+
+// jobServer runs the exports submitted to its HTTP API, at most maxJobs at
+// once, and keeps every job until it's stopped
+type jobServer struct {
+	ctx     context.Context
+	mu      sync.Mutex
+	jobs    map[int]*serveJob
+	lastID  int
+	maxJobs int
+	outputs map[string]int
+	wg      sync.WaitGroup
+	// outputRoot is the absolute directory jobs write under, token the
+	// bearer token requests need, if any
+	outputRoot string
+	token      string
+}
+
+// submit starts the export described by options, flag values like the ones of
+// a jobs file
+func (s *jobServer) submit(options map[string]interface{}) (*serveJob, error) {
+	for name := range options {
+		if !jobOptions[name] {
+			return nil, fmt.Errorf("Option %v isn't allowed in jobs", name)
+		}
+	}
+
+	opts, fs := newCmdOpts("esexport serve", flag.ContinueOnError)
+
+	if err := (&configFile{values: options}).apply(fs, ""); err != nil {
+		return nil, err
+	}
+
+	if opts.output == "" || opts.output == "-" {
+		return nil, errors.New("Output is required, jobs can't write to stdout")
+	}
+
+	for _, path := range []*string{&opts.output, &opts.manifest, &opts.deadLetter} {
+		if *path == "" {
+			continue
+		}
+
+		var err error
+
+		if *path, err = s.outputPath(*path); err != nil {
+			return nil, err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	running := 0
+
+	for _, j := range s.jobs {
+		if j.running() {
+			running++
+		}
+	}
+
+	if running >= s.maxJobs {
+		return nil, errTooManyJobs
+	}
+
+	if id, ok := s.outputs[opts.output]; ok && s.jobs[id].running() {
+		return nil, fmt.Errorf("Job %v is already writing to %v", id, opts.output)
+	}
+
+	s.lastID++
+	ctx, cancel := context.WithCancel(s.ctx)
+	j := &serveJob{id: s.lastID, options: options, status: jobRunning, started: time.Now(), cancel: cancel}
+	s.jobs[j.id] = j
+	s.outputs[opts.output] = j.id
+
+	opts.progressFormat = "json"
+	opts.reportTo = j
+	s.wg.Add(1)
+
+	go func() {
+		defer s.wg.Done()
+		defer cancel()
+
+		fmt.Fprintf(os.Stderr, "Job %v: starting\n", j.id)
+		exitCode := runExport(ctx, opts)
+		fmt.Fprintf(os.Stderr, "Job %v: exited with status %v\n", j.id, exitCode)
+
+		j.mu.Lock()
+		defer j.mu.Unlock()
+
+		j.exitCode, j.ended = exitCode, time.Now()
+
+		switch {
+		case exitCode == 0:
+			j.status = jobCompleted
+		case ctx.Err() != nil:
+			j.status = jobCanceled
+		default:
+			j.status = jobFailed
+		}
+	}()
+
+	return j, nil
+}
+
+// outputPath resolves a path written by a job under -outputRoot, refusing
+// those outside of it
+func (s *jobServer) outputPath(path string) (string, error) {
+	if isRemote(path) {
+		return "", fmt.Errorf("%v isn't a local file, jobs write under -outputRoot", path)
+	}
+
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(s.outputRoot, path)
+	}
+
+	path = filepath.Clean(path)
+	rel, err := filepath.Rel(s.outputRoot, path)
+
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%v is outside of -outputRoot %v", path, s.outputRoot)
+	}
+
+	return path, nil
+}
+
+// authorized checks the bearer token of the request, when -token is set
+func (s *jobServer) authorized(r *http.Request) bool {
+	if s.token == "" {
+		return true
+	}
+
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+s.token)) == 1
+}
+
+func (j *serveJob) running() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.status == jobRunning
+}
+
+func (s *jobServer) job(id int) (*serveJob, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j, ok := s.jobs[id]
+	return j, ok
+}
+
+func (s *jobServer) list() []*serveJob {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	jobs := make([]*serveJob, 0, len(s.jobs))
+
+	for _, j := range s.jobs {
+		jobs = append(jobs, j)
+	}
+
+	sort.Slice(jobs, func(a, b int) bool { return jobs[a].id < jobs[b].id })
+	return jobs
+}
+
+// ServeHTTP handles:
+//
+//	POST /jobs          submits a job, the body holding its flag values
+//	GET /jobs           lists the jobs
+//	GET /jobs/{id}      returns a job with its progress
+//	DELETE /jobs/{id}   cancels a job
+func (s *jobServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(w, http.StatusUnauthorized, errors.New("Unauthorized, -token is required"))
+		return
+	}
+
+	path := strings.TrimSuffix(r.URL.Path, "/")
+
+	if path == "/jobs" {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, s.list())
+		case http.MethodPost:
+			var options map[string]interface{}
+			dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+
+			// Numbers are kept as written, 10485760 would be 1.048576e+07 as
+			// a float64, which the int flags refuse
+			dec.UseNumber()
+
+			if err := dec.Decode(&options); err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("Error parsing job: %v", err))
+				return
+			}
+
+			j, err := s.submit(options)
+
+			if errors.Is(err, errTooManyJobs) {
+				writeError(w, http.StatusTooManyRequests, err)
+				return
+			}
+
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+
+			writeJSON(w, http.StatusCreated, j)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Method %v not allowed", r.Method))
+		}
+
+		return
+	}
+
+	id, err := strconv.Atoi(strings.TrimPrefix(path, "/jobs/"))
+
+	if !strings.HasPrefix(path, "/jobs/") || err != nil {
+		writeError(w, http.StatusNotFound, errors.New("Not found"))
+		return
+	}
+
+	j, ok := s.job(id)
+
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("Job %v not found", id))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, j)
+	case http.MethodDelete:
+		j.cancel()
+		writeJSON(w, http.StatusAccepted, j)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Method %v not allowed", r.Method))
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// serve runs the exports submitted to its HTTP API until interrupted, when
+// the running ones are canceled
+func serve(args []string) int {
+	fs := flag.NewFlagSet("esexport serve", flag.ExitOnError)
+	addr := fs.String("addr", "localhost:8080", "Address the HTTP API listens on (without -token, keep it on a trusted network)")
+	maxJobs := fs.Int("maxJobs", 4, "Number of exports run at once, submitting more fails until one ends")
+	outputRoot := fs.String("outputRoot", ".", "Directory jobs write their output, manifest and dead letters under")
+	token := fs.String("token", "", "Bearer token the requests must send in their Authorization header (ESEXPORT_SERVE_TOKEN otherwise)")
+	fs.Parse(args)
+
+	if *token == "" {
+		*token = os.Getenv("ESEXPORT_SERVE_TOKEN")
+	}
+
+	if *maxJobs < 1 {
+		fmt.Fprintln(os.Stderr, "Error parsing options: -maxJobs must be at least 1")
+		return 1
+	}
+
+	root, err := filepath.Abs(*outputRoot)
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error parsing options:", err)
+		return 1
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handleInterrupt(cancel)
+
+	s := &jobServer{ctx: ctx, jobs: map[int]*serveJob{}, maxJobs: *maxJobs, outputs: map[string]int{}, outputRoot: root, token: *token}
+	server := &http.Server{Addr: *addr, Handler: s}
+	failed := make(chan error, 1)
+
+	go func() {
+		failed <- server.ListenAndServe()
+	}()
+
+	fmt.Fprintln(os.Stderr, "Listening on", *addr)
+
+	select {
+	case err := <-failed:
+		fmt.Fprintln(os.Stderr, "Error serving:", err)
+		cancel()
+		s.wg.Wait()
+		return 1
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer shutdownCancel()
+	server.Shutdown(shutdownCtx)
+
+	// Running exports were canceled along with ctx, they get their grace
+	// period to write what they have
+	s.wg.Wait()
+	return 130
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// handleInterrupt cancels the export on the first Ctrl-C (also Ctrl-Break on
+// Windows) or SIGTERM (sent on Windows when the console is closed), letting
+// the cursors finish the batch being written, and exits right away on the
+// second one.
+func handleInterrupt(cancel context.CancelFunc) {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		<-signals
+		fmt.Fprintln(os.Stderr, "\nInterrupted, finishing the batches in progress (interrupt again to quit immediately)")
+		cancel()
+		<-signals
+		os.Exit(130)
+	}()
+}
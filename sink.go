@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/alissonsales/esexport/client"
+)
+
+// Sink receives the batches of hits of the export, once transformed. It's
+// shared by the slices, WriteHits being called by several at once, and
+// closed once they are done.
+type Sink interface {
+	WriteHits(ctx context.Context, hits []client.Hit) error
+	Close() error
+}
+
+// sinkOpener opens the sink of an -output URL
+type sinkOpener func(opts *cmdOpts, u *url.URL) (Sink, error)
+
+// sinks open the -output URLs of destinations taking hits rather than lines
+// (the documents serialized in -format), by scheme. They register
+// themselves when compiled in, taking precedence over remoteOutputs. Sinks
+// writing bytes can still serialize the hits with the Formatter of -format
+// (lookupHitFormat).
+var sinks = map[string]sinkOpener{}
+
+// openSink returns the sink registered for the scheme of -output, or nil
+// when the output is written as lines by a fileSink
+func openSink(opts *cmdOpts) (Sink, error) {
+	if !isRemote(opts.output) || opts.target != "" {
+		return nil, nil
+	}
+
+	u, err := url.Parse(opts.output)
+
+	if err != nil {
+		return nil, fmt.Errorf("Invalid -output: %v", err)
+	}
+
+	open, ok := sinks[u.Scheme]
+
+	if !ok {
+		return nil, nil
+	}
+
+	if opts.partitionBy != "" || opts.transformCmd != "" || opts.checksumSidecars {
+		return nil, fmt.Errorf("%v:// outputs can't be combined with -partitionBy, -transformCmd or -sha256Files", u.Scheme)
+	}
+
+	return open(opts, u)
+}
+
+// sinkOutput is the exportOutput of a sink, which has no files
+type sinkOutput struct {
+	Sink
+}
+
+func (s sinkOutput) files() []outputFile {
+	return nil
+}
+
+func (s sinkOutput) flush() error {
+	return nil
+}
+
+func (s sinkOutput) checkpoint() outputCheckpoint {
+	return checkpointOf(s.Sink)
+}
+
+// fileSink writes the hits serialized by the formatter to the output or to
+// the files of their partitions
+type fileSink struct {
+	output     *output
+	partitions *partitionedOutput
+	formatter  Formatter
+	// reject handles the hits failing to be serialized or partitioned, the
+	// batch failing when it returns an error
+	reject func(hit *client.Hit, stage string, err error) error
+}
+
+func (f *fileSink) WriteHits(ctx context.Context, hits []client.Hit) error {
+	_, _, err := f.write(ctx, hits)
+	return err
+}
+
+// write writes the batch, returning the hits written (those rejected left
+// out) and the number of bytes
+func (f *fileSink) write(ctx context.Context, hits []client.Hit) ([]client.Hit, int, error) {
+	// The lines of the batch are written at once (once per partition),
+	// rather than taking the output lock for every hit
+	var batch bytes.Buffer
+	var partitions []string
+	partitioned := map[string]*bytes.Buffer{}
+	written := hits[:0]
+
+	for i := range hits {
+		hit := &hits[i]
+		line, err := f.formatter.Format(hit)
+
+		if err != nil {
+			if err := f.reject(hit, "serialize", err); err != nil {
+				return nil, 0, err
+			}
+
+			continue
+		}
+
+		if f.partitions != nil {
+			partition, err := f.partitions.partitioner.partition(hit)
+
+			if err != nil {
+				if err := f.reject(hit, "partition", err); err != nil {
+					return nil, 0, err
+				}
+
+				continue
+			}
+
+			if partitioned[partition] == nil {
+				partitioned[partition] = &bytes.Buffer{}
+				partitions = append(partitions, partition)
+			}
+
+			partitioned[partition].Write(line)
+		} else {
+			batch.Write(line)
+		}
+
+		written = append(written, *hit)
+	}
+
+	n := batch.Len()
+
+	if f.partitions != nil {
+		n = 0
+
+		for _, partition := range partitions {
+			if err := f.partitions.write(ctx, partition, partitioned[partition].Bytes()); err != nil {
+				return nil, n, err
+			}
+
+			n += partitioned[partition].Len()
+		}
+	} else if n > 0 {
+		if _, err := f.output.write(ctx, batch.Bytes()); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	return written, n, nil
+}
+
+// exportOutput returns the partitions or the output the sink writes to
+func (f *fileSink) exportOutput() exportOutput {
+	if f.partitions != nil {
+		return f.partitions
+	}
+
+	return f.output
+}
+
+func (f *fileSink) Close() error {
+	return f.exportOutput().Close()
+}
+
+func (f *fileSink) files() []outputFile {
+	return f.exportOutput().files()
+}
+
+func (f *fileSink) flush() error {
+	return f.exportOutput().flush()
+}
@@ -0,0 +1,679 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alissonsales/esexport/client"
+	"github.com/alissonsales/esexport/cursor"
+)
+
+// slice is one of the parts of the export processed concurrently: its
+// cursor, log and the position it reached in the output
+type slice struct {
+	id     int
+	cursor *cursor.SlicedScrollCursor
+	log    *sliceLog
+	// chunk names the documents of the chunk the slice exports, if any
+	chunk string
+	// ttl is how long ES keeps the scroll between requests, writes getting
+	// close to it are reported before the scroll expires
+	ttl        time.Duration
+	slowWrites sync.Once
+	// tracer records the spans of the pages fetched and written
+	tracer *spanTracer
+	// pages is the number of pages fetched, only touched by next
+	pages int
+	// bound limits the -sliceField values exported with -stealWork, once
+	// reached the slice is done (touched by next as well)
+	bound        *sliceBound
+	boundReached bool
+	// memory holds back the fetching once the pages not written yet reach
+	// -maxMemoryMB, fetched is the size of the responses so far (both
+	// touched by next as well)
+	memory  *memoryBudget
+	fetched int64
+	// slow is -slowThreshold, took the time ES reported for the pages so
+	// far (touched by next as well)
+	slow time.Duration
+	took time.Duration
+	// restart opens a new cursor over the documents of the slice, to retry
+	// it with -sliceRetries. attempts is the number of times it was
+	// processed, skip the hits a retry drops, written by the previous
+	// attempts, the last of them being skipID (touched by next as well).
+	restart  func() (*cursor.SlicedScrollCursor, error)
+	attempts int
+	skip     int
+	skipID   string
+
+	// mu guards what is read while the slice runs (by the progress, the
+	// manifest and the work stealing), cursor included
+	mu        sync.Mutex
+	expected  int
+	retrieved int
+	exhausted bool
+	// searched is set once a search of the slice returned a page, its total
+	// being known from then on
+	searched  bool
+	docs      int
+	bytes     int64
+	scrollID  string
+	completed bool
+	err       error
+	// elapsed is the time spent processing the slice, over every attempt
+	elapsed time.Duration
+	// hits is the number of hits of the pages written, lastID the _id of
+	// the last one
+	hits   int
+	lastID string
+	// requested is the number of pages requested which may have moved the
+	// scroll, consumed those written (or skipped). The scroll id continues
+	// after the last page written only when they are equal: the scroll is a
+	// cursor of ES, pages fetched ahead are skipped when it's resumed.
+	requested int
+	consumed  int
+}
+
+// errSliceChanged fails a retry finding other documents than those written
+// by the previous attempts of the slice
+var errSliceChanged = errors.New("The documents of the slice changed since it failed, retrying it would duplicate or miss some")
+
+// streamedHits is the number of hits decoded before being written while
+// streaming pages
+const streamedHits = 100
+
+// page is a batch of hits along with the scroll id continuing after it
+type page struct {
+	hits     []client.Hit
+	scrollID string
+	number   int
+	// bytes is the size of the response, counted by the memory budget
+	// until the page is written
+	bytes int64
+}
+
+// process writes every page of the slice. With prefetch > 0 pages are
+// fetched in the background, up to prefetch pages ahead of the one being
+// written, overlapping ES latency with writing. Otherwise the hits of every
+// page are written as they are decoded, without holding the page.
+func (s *slice) process(ctx context.Context, w *hitWriter, prefetch int) error {
+	start := time.Now()
+	ctx, endSpan := s.tracer.start(ctx, "slice", map[string]interface{}{"slice.id": s.id})
+	var err error
+
+	if prefetch > 0 {
+		err = s.processPrefetching(ctx, w, prefetch)
+	} else {
+		err = s.processStreaming(ctx, w)
+	}
+
+	stats := s.cursor.Stats()
+	s.log.log("end", map[string]interface{}{"duration_ms": time.Since(start).Milliseconds(), "error": err, "pages": stats.Pages, "bytes": stats.Bytes,
+		"retries": stats.Retries, "avg_latency_ms": stats.AvgLatency.Milliseconds(), "p99_latency_ms": stats.P99Latency.Milliseconds()})
+	endSpan(map[string]interface{}{"docs": s.position().Docs}, err)
+
+	s.mu.Lock()
+	s.completed = err == nil
+	s.elapsed += time.Since(start)
+
+	// Being interrupted isn't an error of the slice
+	if ctx.Err() == nil {
+		s.err = err
+	}
+
+	s.mu.Unlock()
+
+	return err
+}
+
+// processStreaming writes the hits of every page as they are decoded, up to
+// streamedHits at a time
+func (s *slice) processStreaming(ctx context.Context, w *hitWriter) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		n, err := s.stream(ctx, w)
+
+		if err != nil {
+			return err
+		}
+
+		if n == 0 || s.boundReached || w.limit.reached() {
+			return nil
+		}
+	}
+}
+
+func (s *slice) processPrefetching(ctx context.Context, w *hitWriter, prefetch int) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// The fetching goroutine holds one page while blocked sending it
+	pages := make(chan page, prefetch-1)
+	fetchErr := make(chan error, 1)
+
+	// Pages left unwritten are dropped, once the fetching goroutine is done
+	defer func() {
+		cancel()
+
+		for p := range pages {
+			s.memory.release(p.bytes)
+		}
+	}()
+
+	go func() {
+		defer close(pages)
+
+		for {
+			if err := ctx.Err(); err != nil {
+				fetchErr <- err
+				return
+			}
+
+			p, err := s.next(ctx)
+
+			if err != nil {
+				fetchErr <- err
+				return
+			}
+
+			if len(p.hits) == 0 {
+				return
+			}
+
+			select {
+			case pages <- p:
+			case <-ctx.Done():
+				s.memory.release(p.bytes)
+				fetchErr <- ctx.Err()
+				return
+			}
+		}
+	}()
+
+	for p := range pages {
+		if err := s.write(ctx, w, p); err != nil {
+			return err
+		}
+
+		// Stops fetching pages, the slice is done
+		if w.limit.reached() {
+			return nil
+		}
+	}
+
+	select {
+	case err := <-fetchErr:
+		return err
+	default:
+		return nil
+	}
+}
+
+// retry restarts the slice with a new search once it failed, the hits
+// written by the previous attempts being skipped
+func (s *slice) retry() error {
+	c, err := s.restart()
+
+	if err != nil {
+		return err
+	}
+
+	// The statistics of the slice cover every attempt
+	if s.cursor != nil {
+		c.Continue(s.cursor)
+	}
+	stats := c.Stats()
+
+	s.mu.Lock()
+	s.cursor = c
+	s.skip, s.skipID = s.hits, s.lastID
+	s.requested, s.consumed = 0, 0
+	s.mu.Unlock()
+
+	s.pages, s.fetched, s.took = 0, stats.Bytes, stats.Took
+	s.log.log("retry", map[string]interface{}{"attempt": s.attempts, "skip": s.skip})
+
+	return nil
+}
+
+// next returns the next page of the slice, past the hits a retry skips
+func (s *slice) next(ctx context.Context) (page, error) {
+	for {
+		p, err := s.fetch(ctx)
+
+		if err != nil || s.skip == 0 {
+			return p, err
+		}
+
+		if len(p.hits) == 0 {
+			return p, fmt.Errorf("%w (it has %v documents less)", errSliceChanged, s.skip)
+		}
+
+		if p.hits, err = s.skipWritten(p.hits); err != nil {
+			s.memory.release(p.bytes)
+			return page{}, err
+		}
+
+		if len(p.hits) > 0 {
+			return p, nil
+		}
+
+		s.mu.Lock()
+		s.consumed++
+		s.mu.Unlock()
+
+		s.memory.release(p.bytes)
+	}
+}
+
+func (s *slice) fetch(ctx context.Context) (page, error) {
+	if s.boundReached {
+		return page{number: s.pages}, nil
+	}
+
+	if err := s.memory.wait(ctx); err != nil {
+		return page{}, err
+	}
+
+	s.pages++
+	name := "scroll"
+
+	s.mu.Lock()
+	s.requested++
+	s.mu.Unlock()
+
+	// The first page comes from the search opening the scroll
+	if s.pages == 1 {
+		name = "search"
+	}
+
+	_, endSpan := s.tracer.start(ctx, name, map[string]interface{}{"slice.id": s.id, "page": s.pages})
+	start := time.Now()
+	hits, err := s.cursor.Next()
+	elapsed := time.Since(start)
+	endSpan(map[string]interface{}{"hits": len(hits)}, err)
+
+	stats := s.cursor.Stats()
+
+	if s.slow > 0 && elapsed > s.slow {
+		s.logSlow(name, elapsed, stats.Took-s.took, stats.Bytes-s.fetched, err)
+	}
+
+	s.mu.Lock()
+
+	// Requests turned down by the cluster didn't move the scroll
+	if err != nil && isClusterError(err) {
+		s.requested--
+	}
+
+	// The search of a retry knows its total once it returned a page, the
+	// progress keeps the previous attempt until then
+	if err == nil {
+		s.expected, s.retrieved, s.exhausted, s.searched = stats.Expected, stats.Retrieved, stats.Done, true
+	}
+	s.mu.Unlock()
+
+	if s.bound != nil && err == nil {
+		hits, s.boundReached = s.bound.keep(hits)
+	}
+
+	p := page{hits: hits, scrollID: s.cursor.ScrollID(), number: s.pages}
+
+	if len(hits) > 0 && err == nil {
+		p.bytes = stats.Bytes - s.fetched
+		s.memory.add(p.bytes)
+	}
+
+	s.fetched, s.took = stats.Bytes, stats.Took
+	return p, err
+}
+
+// stream requests the next page and writes its hits as they are decoded,
+// returning the number of hits of the page (those a retry skips included)
+func (s *slice) stream(ctx context.Context, w *hitWriter) (int, error) {
+	if s.boundReached {
+		return 0, nil
+	}
+
+	s.pages++
+	name := "scroll"
+
+	s.mu.Lock()
+	s.requested++
+	s.mu.Unlock()
+
+	if s.pages == 1 {
+		name = "search"
+	}
+
+	_, endSpan := s.tracer.start(ctx, name, map[string]interface{}{"slice.id": s.id, "page": s.pages})
+	start := time.Now()
+	batch := make([]client.Hit, 0, streamedHits)
+	// written is what the page wrote so far, in writing time
+	var hits, docs, bytes int
+	var writing time.Duration
+
+	flush := func() error {
+		kept := batch
+		batch = make([]client.Hit, 0, streamedHits)
+
+		// Past the bound or the limit the rest of the page is decoded and
+		// dropped, for the cursor to count it
+		if s.bound != nil {
+			kept, s.boundReached = s.bound.keep(kept)
+		}
+
+		kept, err := s.skipWritten(kept)
+
+		if err != nil || len(kept) == 0 || w.limit.reached() {
+			return err
+		}
+
+		writeStart := time.Now()
+		d, n, err := s.writeHits(ctx, w, s.pages, kept)
+		writing += time.Since(writeStart)
+		hits, docs, bytes = hits+len(kept), docs+d, bytes+n
+
+		if err != nil {
+			return &outputWriteError{err}
+		}
+
+		return nil
+	}
+
+	n, err := s.cursor.NextEach(func(hit *client.Hit) error {
+		if batch = append(batch, *hit); len(batch) < streamedHits {
+			return nil
+		}
+
+		return flush()
+	})
+
+	if err == nil {
+		err = flush()
+	}
+
+	elapsed := time.Since(start) - writing
+	endSpan(map[string]interface{}{"hits": n}, err)
+
+	stats := s.cursor.Stats()
+
+	if s.slow > 0 && elapsed > s.slow {
+		s.logSlow(name, elapsed, stats.Took-s.took, stats.Bytes-s.fetched, err)
+	}
+
+	s.mu.Lock()
+
+	// Requests turned down by the cluster didn't move the scroll
+	if err != nil && isClusterError(err) {
+		s.requested--
+	}
+
+	// The search of a retry knows its total once it returned a page, the
+	// progress keeps the previous attempt until then
+	if err == nil {
+		s.expected, s.retrieved, s.exhausted, s.searched = stats.Expected, stats.Retrieved, stats.Done, true
+	}
+	s.mu.Unlock()
+
+	s.fetched, s.took = stats.Bytes, stats.Took
+
+	if err == nil && n == 0 && s.skip > 0 {
+		err = fmt.Errorf("%w (it has %v documents less)", errSliceChanged, s.skip)
+	}
+
+	var outErr *outputWriteError
+	resumable := false
+
+	if err == nil && n > 0 {
+		resumable = s.endPage(s.cursor.ScrollID())
+	}
+
+	if hits > 0 || errors.As(err, &outErr) {
+		var writeErr error
+
+		if outErr != nil {
+			writeErr = outErr.err
+		}
+
+		s.logWrite(hits, docs, bytes, writing, s.cursor.ScrollID(), resumable, writeErr)
+		s.warnSlowWrite(writing)
+	}
+
+	return n, err
+}
+
+// skipWritten drops the hits written by the previous attempts of the slice,
+// which a retry returns again first
+func (s *slice) skipWritten(hits []client.Hit) ([]client.Hit, error) {
+	if s.skip == 0 || len(hits) == 0 {
+		return hits, nil
+	}
+
+	n := s.skip
+
+	if n > len(hits) {
+		n = len(hits)
+	}
+
+	// The new search returns the documents in the same order as long as the
+	// index didn't change, which the last one skipped confirms
+	if s.skip -= n; s.skip == 0 && hits[n-1].ID != s.skipID {
+		return nil, fmt.Errorf("%w (%v instead of %v)", errSliceChanged, hits[n-1].ID, s.skipID)
+	}
+
+	return hits[n:], nil
+}
+
+// logSlow reports a request of the slice slower than -slowThreshold, with
+// the time ES spent on it (the rest being spent on the way and decoding)
+func (s *slice) logSlow(name string, elapsed, took time.Duration, bytes int64, err error) {
+	fmt.Fprintf(os.Stderr, "\nSlow %v of slice %v, page %v: %v (took %v), %v bytes", name, s.id, s.pages,
+		elapsed.Round(time.Millisecond), took, bytes)
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, ", failed: %v", err)
+	}
+
+	fmt.Fprintln(os.Stderr)
+
+	s.log.log("slow", map[string]interface{}{"request": name, "page": s.pages, "duration_ms": elapsed.Milliseconds(),
+		"took_ms": took.Milliseconds(), "bytes": bytes, "error": err})
+}
+
+// elapsedTime returns the time spent processing the slice
+func (s *slice) elapsedTime() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.elapsed
+}
+
+// progress returns the documents retrieved and expected by the slice, once
+// its search returned a page
+func (s *slice) progress() (retrieved, expected int, searched bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.retrieved, s.expected, s.searched
+}
+
+// stats returns the statistics of the requests of the slice, over every
+// attempt
+func (s *slice) stats() cursor.Stats {
+	s.mu.Lock()
+	c := s.cursor
+	s.mu.Unlock()
+
+	return c.Stats()
+}
+
+// retrievedDocs returns the number of documents retrieved by the cursor
+func (s *slice) retrievedDocs() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.retrieved
+}
+
+// remaining estimates the number of documents the slice has left to
+// retrieve, leaving out those stolen by other slices
+func (s *slice) remaining() int {
+	s.mu.Lock()
+	remaining := s.expected - s.retrieved
+
+	if s.exhausted {
+		remaining = 0
+	}
+
+	s.mu.Unlock()
+
+	if s.bound != nil {
+		s.bound.mu.Lock()
+		remaining -= s.bound.stolen
+		s.bound.mu.Unlock()
+	}
+
+	return remaining
+}
+
+// write writes the page and moves the slice position past it
+func (s *slice) write(ctx context.Context, w *hitWriter, p page) error {
+	defer s.memory.release(p.bytes)
+
+	start := time.Now()
+	docs, n, err := s.writeHits(ctx, w, p.number, p.hits)
+	resumable := err == nil && s.endPage(p.scrollID)
+	elapsed := time.Since(start)
+	s.logWrite(len(p.hits), docs, n, elapsed, p.scrollID, resumable, err)
+	s.warnSlowWrite(elapsed)
+
+	if err != nil {
+		return &outputWriteError{err}
+	}
+
+	return nil
+}
+
+// writeHits writes hits of the page number and moves the slice position
+// past them, returning the documents and bytes written
+func (s *slice) writeHits(ctx context.Context, w *hitWriter, number int, hits []client.Hit) (int, int, error) {
+	count := len(hits)
+	var lastID string
+
+	// Hits are filtered in place by the writer
+	if count > 0 {
+		lastID = hits[count-1].ID
+	}
+
+	_, endSpan := s.tracer.start(ctx, "write", map[string]interface{}{"slice.id": s.id, "page": number, "hits": count})
+	docs, n, err := w.write(ctx, hits)
+	endSpan(map[string]interface{}{"docs": docs, "bytes": n}, err)
+
+	if err == nil {
+		s.mu.Lock()
+		s.docs += docs
+		s.bytes += int64(n)
+		s.hits, s.lastID = s.hits+count, lastID
+		s.mu.Unlock()
+	}
+
+	return docs, n, err
+}
+
+// endPage moves the scroll id of the slice past the page written, reporting
+// whether it's one to resume from (no page was fetched ahead)
+func (s *slice) endPage(scrollID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.scrollID = scrollID
+	s.consumed++
+
+	return s.consumed == s.requested
+}
+
+// logWrite logs the write of the hits of a page, the scroll id being logged
+// only when it's one to resume from
+func (s *slice) logWrite(hits, docs, n int, elapsed time.Duration, scrollID string, resumable bool, err error) {
+	fields := map[string]interface{}{"hits": hits, "bytes": n, "duration_ms": elapsed.Milliseconds()}
+
+	if rejected := hits - docs; err == nil && rejected > 0 {
+		fields["dead_letters"] = rejected
+	}
+
+	if err != nil {
+		fields["error"] = err
+	}
+
+	if resumable {
+		fields["scroll_id"] = scrollID
+	}
+
+	s.log.log("write", fields)
+}
+
+// warnSlowWrite warns once when writing a page takes long enough for the
+// scroll to come close to expiring
+func (s *slice) warnSlowWrite(elapsed time.Duration) {
+	if s.ttl > 0 && elapsed > s.ttl/2 {
+		s.slowWrites.Do(func() {
+			fmt.Fprintf(os.Stderr, "\nWarning: writing a batch of slice %v took %v, the scroll expires if it takes longer than -searchContextTTL (%v)\n",
+				s.id, elapsed.Round(time.Millisecond), s.ttl)
+		})
+	}
+}
+
+// parseTTL parses an ES time value (e.g. 1m, 30s, 1d)
+func parseTTL(ttl string) (time.Duration, error) {
+	units := []struct {
+		suffix string
+		unit   time.Duration
+	}{
+		{"nanos", time.Nanosecond}, {"micros", time.Microsecond}, {"ms", time.Millisecond},
+		{"s", time.Second}, {"m", time.Minute}, {"h", time.Hour}, {"d", 24 * time.Hour},
+	}
+
+	for _, u := range units {
+		if strings.HasSuffix(ttl, u.suffix) {
+			n, err := strconv.ParseInt(strings.TrimSuffix(ttl, u.suffix), 10, 64)
+
+			if err != nil {
+				break
+			}
+
+			return time.Duration(n) * u.unit, nil
+		}
+	}
+
+	return 0, fmt.Errorf("Invalid time value %v", ttl)
+}
+
+// position returns the manifest entry of the slice
+func (s *slice) position() sliceManifest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m := sliceManifest{ID: s.id, Chunk: s.chunk, Expected: s.expected, Docs: s.docs, Bytes: s.bytes, Completed: s.completed}
+
+	// The scroll id is left out while pages fetched ahead weren't written,
+	// resuming from it would skip them
+	if !s.completed && s.consumed == s.requested {
+		m.ScrollID = s.scrollID
+	}
+
+	if s.err != nil {
+		m.Error = s.err.Error()
+	}
+
+	return m
+}
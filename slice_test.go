@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/alissonsales/esexport/client"
+	"github.com/alissonsales/esexport/cursor"
+)
+
+// pagedClient returns the given pages of ids, every scroll response with its
+// own scroll id and the total of the ids of every page
+type pagedClient struct {
+	pages [][]string
+	sent  int
+}
+
+func (c *pagedClient) next() (*client.ESSearchResponse, error) {
+	resp := &client.ESSearchResponse{ScrollID: fmt.Sprintf("scroll-%v", c.sent+1), Shards: client.Shards{Total: 1, Successful: 1}}
+
+	for _, page := range c.pages {
+		resp.Hits.Total += len(page)
+	}
+
+	if c.sent < len(c.pages) {
+		for _, id := range c.pages[c.sent] {
+			resp.Hits.Hits = append(resp.Hits.Hits, client.Hit{ID: id})
+		}
+	}
+
+	c.sent++
+	return resp, nil
+}
+
+func (c *pagedClient) Search(map[string]interface{}) (*client.ESSearchResponse, error) {
+	return c.next()
+}
+
+func (c *pagedClient) Scroll(string) (*client.ESSearchResponse, error) {
+	return c.next()
+}
+
+// recordingSink keeps the ids of the hits written, and the size of the
+// largest batch
+type recordingSink struct {
+	ids      []string
+	maxBatch int
+}
+
+func (s *recordingSink) WriteHits(ctx context.Context, hits []client.Hit) error {
+	if len(hits) > s.maxBatch {
+		s.maxBatch = len(hits)
+	}
+
+	for _, hit := range hits {
+		s.ids = append(s.ids, hit.ID)
+	}
+
+	return nil
+}
+
+func (s *recordingSink) Close() error {
+	return nil
+}
+
+func newTestSlice(t *testing.T, pages [][]string) *slice {
+	c, err := cursor.NewSlicedScrollCursor(&pagedClient{pages: pages}, 0, 0, "", map[string]interface{}{})
+
+	if err != nil {
+		t.Fatalf("Failed to create cursor: %v", err)
+	}
+
+	return &slice{cursor: c}
+}
+
+func TestSlicePositionWithPagesFetchedAhead(t *testing.T) {
+	s := newTestSlice(t, [][]string{{"1", "2"}, {"3", "4"}, {"5"}})
+	w := &hitWriter{sink: &recordingSink{}}
+	ctx := context.Background()
+
+	first, err := s.next(ctx)
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err := s.write(ctx, w, first); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if position := s.position(); position.ScrollID != "scroll-1" {
+		t.Errorf("Expected the position to continue after the first page, got '%+v'", position)
+	}
+
+	// Prefetching: the second page is fetched before the first one is
+	// written, resuming from the scroll would skip it
+	second, _ := s.next(ctx)
+	third, _ := s.next(ctx)
+
+	if err := s.write(ctx, w, second); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if position := s.position(); position.ScrollID != "" || position.Docs != 4 {
+		t.Errorf("Expected no scroll id while the third page isn't written, got '%+v'", position)
+	}
+
+	if err := s.write(ctx, w, third); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if position := s.position(); position.ScrollID != "scroll-3" || position.Docs != 5 {
+		t.Errorf("Expected the position to continue after the third page once written, got '%+v'", position)
+	}
+}
+
+func TestSliceRetrySkipsWrittenHits(t *testing.T) {
+	scenarios := []struct {
+		pages    [][]string
+		expected []string
+		changed  bool
+	}{
+		{[][]string{{"a", "b"}, {"c", "d"}, {"e"}}, []string{"d", "e"}, false},
+		{[][]string{{"a", "x"}, {"b", "c"}}, nil, true},
+		{[][]string{{"a"}}, nil, true},
+	}
+
+	for _, scenario := range scenarios {
+		s := &slice{hits: 3, lastID: "c", restart: func() (*cursor.SlicedScrollCursor, error) {
+			return cursor.NewSlicedScrollCursor(&pagedClient{pages: scenario.pages}, 0, 0, "", map[string]interface{}{})
+		}}
+
+		if err := s.retry(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		var ids []string
+		var err error
+
+		for {
+			var p page
+
+			if p, err = s.next(context.Background()); err != nil || len(p.hits) == 0 {
+				break
+			}
+
+			for _, hit := range p.hits {
+				ids = append(ids, hit.ID)
+			}
+		}
+
+		if changed := errors.Is(err, errSliceChanged); changed != scenario.changed {
+			t.Errorf("Expected the retry of %v to fail with errSliceChanged: %v, got %v", scenario.pages, scenario.changed, err)
+		}
+
+		if !scenario.changed && fmt.Sprint(ids) != fmt.Sprint(scenario.expected) {
+			t.Errorf("Expected the retry of %v to return %v, got %v", scenario.pages, scenario.expected, ids)
+		}
+	}
+}
+
+func TestSliceStreamsPages(t *testing.T) {
+	ids := func(from, to int) []string {
+		var ids []string
+
+		for i := from; i < to; i++ {
+			ids = append(ids, fmt.Sprint(i))
+		}
+
+		return ids
+	}
+
+	scenarios := []struct {
+		pages    [][]string
+		skip     int
+		skipID   string
+		expected []string
+	}{
+		{[][]string{ids(0, 250), ids(250, 280)}, 0, "", ids(0, 280)},
+		// A retry skips the hits written by the previous attempt
+		{[][]string{ids(0, 250), ids(250, 280)}, 120, "119", ids(120, 280)},
+	}
+
+	for _, scenario := range scenarios {
+		s := newTestSlice(t, scenario.pages)
+		s.skip, s.skipID = scenario.skip, scenario.skipID
+		sink := &recordingSink{}
+
+		if err := s.process(context.Background(), &hitWriter{sink: sink}, 0); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		if fmt.Sprint(sink.ids) != fmt.Sprint(scenario.expected) {
+			t.Errorf("Expected %v hits to be written in order, got %v", len(scenario.expected), sink.ids)
+		}
+
+		if sink.maxBatch > streamedHits {
+			t.Errorf("Expected at most %v hits to be written at once, got %v", streamedHits, sink.maxBatch)
+		}
+
+		if position := s.position(); position.Docs != len(scenario.expected) || !position.Completed {
+			t.Errorf("Expected the slice to complete with %v documents, got '%+v'", len(scenario.expected), position)
+		}
+	}
+}
+
+// flakyClient fails the scroll after the given page, the first time only
+type flakyClient struct {
+	pagedClient
+	failAfter int
+	failed    *bool
+}
+
+func (c *flakyClient) Scroll(id string) (*client.ESSearchResponse, error) {
+	if c.sent == c.failAfter && !*c.failed {
+		*c.failed = true
+		return nil, errors.New("connection reset")
+	}
+
+	return c.pagedClient.Scroll(id)
+}
+
+func TestRetryFailedSlices(t *testing.T) {
+	pages := [][]string{{"a", "b"}, {"c", "d"}, {"e"}}
+
+	scenarios := []struct {
+		failAfter int
+		prefetch  int
+	}{
+		{1, 0},
+		{2, 0},
+		{1, 1},
+		{2, 1},
+	}
+
+	for _, scenario := range scenarios {
+		failed := false
+
+		restart := func() (*cursor.SlicedScrollCursor, error) {
+			return cursor.NewSlicedScrollCursor(&flakyClient{pagedClient{pages: pages}, scenario.failAfter, &failed}, 0, 0, "", map[string]interface{}{})
+		}
+
+		c, err := restart()
+
+		if err != nil {
+			t.Fatalf("Failed to create cursor: %v", err)
+		}
+
+		s := &slice{cursor: c, restart: restart}
+		sink := &recordingSink{}
+		w := &hitWriter{sink: sink}
+
+		var retries []*slice
+		var mu sync.Mutex
+
+		// run processes the slice like the export does, with one retry
+		run := func(s *slice) bool {
+			var err error
+
+			if s.attempts++; s.attempts > 1 {
+				err = s.retry()
+			}
+
+			if err == nil {
+				err = s.process(context.Background(), w, scenario.prefetch)
+			}
+
+			if err != nil && s.attempts <= 1 {
+				mu.Lock()
+				retries = append(retries, s)
+				mu.Unlock()
+			}
+
+			return err == nil
+		}
+
+		if run(s) {
+			t.Fatalf("Expected the slice to fail after page %v", scenario.failAfter)
+		}
+
+		retryFailedSlices(context.Background(), &retries, &mu, run, 1)
+
+		if expected := []string{"a", "b", "c", "d", "e"}; fmt.Sprint(sink.ids) != fmt.Sprint(expected) {
+			t.Errorf("Expected %v to be written once each after failing after page %v (prefetch %v), got %v", expected, scenario.failAfter, scenario.prefetch, sink.ids)
+		}
+
+		if position := s.position(); s.attempts != 2 || position.Docs != 5 || !position.Completed {
+			t.Errorf("Expected the slice to complete with 5 documents on attempt 2, got attempt %v and '%+v'", s.attempts, position)
+		}
+
+		if current, total := processingProgress([]*slice{s}); current == nil || *current != 5 || *total != 5 {
+			t.Errorf("Expected the summary to count 5 of 5 documents after failing after page %v (prefetch %v), got %v of %v", scenario.failAfter, scenario.prefetch, current, total)
+		}
+
+		summary := summaryData{}
+		addThroughput(&summary, []*slice{s})
+
+		// The pages of the failed attempt are counted along those of the retry,
+		// its 3 pages and the empty last one
+		if throughput := summary.Slices[0]; throughput.Docs != 5 || s.stats().Pages != scenario.failAfter+len(pages)+1 {
+			t.Errorf("Expected the throughput of 5 documents over the pages of both attempts after failing after page %v (prefetch %v), got '%+v' over %v pages", scenario.failAfter, scenario.prefetch, throughput, s.stats().Pages)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/alissonsales/esexport/client"
+	"github.com/alissonsales/esexport/cursor"
+)
+
+// sliceLog writes the events of a slice (requests, batches, errors) as JSON
+// lines, so a single failed slice of a large run can be looked into. A nil
+// *sliceLog discards everything.
+type sliceLog struct {
+	mu    sync.Mutex
+	slice int
+	f     *os.File
+	enc   *json.Encoder
+}
+
+// openSliceLogs creates one log file per slice in the slices directory of
+// the run temp directory
+func openSliceLogs(tmp *runTempDir, slices int) ([]*sliceLog, error) {
+	dir, err := tmp.dir()
+
+	if err != nil {
+		return nil, err
+	}
+
+	dir = filepath.Join(dir, "slices")
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	logs := make([]*sliceLog, slices)
+
+	for i := range logs {
+		f, err := os.Create(filepath.Join(dir, fmt.Sprintf("slice-%d.log", i)))
+
+		if err != nil {
+			return nil, err
+		}
+
+		logs[i] = &sliceLog{slice: i, f: f, enc: json.NewEncoder(f)}
+	}
+
+	return logs, nil
+}
+
+func (l *sliceLog) log(event string, fields map[string]interface{}) {
+	if l == nil {
+		return
+	}
+
+	entry := map[string]interface{}{"time": time.Now().UTC().Format(time.RFC3339Nano), "slice": l.slice, "event": event}
+
+	for k, v := range fields {
+		if v == nil {
+			continue
+		}
+
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+
+		entry[k] = v
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.enc.Encode(entry)
+}
+
+func (l *sliceLog) Close() error {
+	if l == nil {
+		return nil
+	}
+
+	return l.f.Close()
+}
+
+// loggingClient logs the search and scroll requests of a slice
+type loggingClient struct {
+	next cursor.ElasticsearchClient
+	log  *sliceLog
+}
+
+func (c *loggingClient) Search(searchBody map[string]interface{}) (*client.ESSearchResponse, error) {
+	start := time.Now()
+	resp, err := c.next.Search(searchBody)
+	c.logRequest("search", start, resp, err)
+
+	return resp, err
+}
+
+func (c *loggingClient) Scroll(scrollID string) (*client.ESSearchResponse, error) {
+	start := time.Now()
+	resp, err := c.next.Scroll(scrollID)
+	c.logRequest("scroll", start, resp, err)
+
+	return resp, err
+}
+
+func (c *loggingClient) SearchEach(searchBody map[string]interface{}, hit func(*client.Hit) error) (*client.ESSearchResponse, error) {
+	start := time.Now()
+	resp, err := cursor.SearchEach(c.next, searchBody, hit)
+	c.logRequest("search", start, resp, err)
+
+	return resp, err
+}
+
+func (c *loggingClient) ScrollEach(scrollID string, hit func(*client.Hit) error) (*client.ESSearchResponse, error) {
+	start := time.Now()
+	resp, err := cursor.ScrollEach(c.next, scrollID, hit)
+	c.logRequest("scroll", start, resp, err)
+
+	return resp, err
+}
+
+func (c *loggingClient) logRequest(event string, start time.Time, resp *client.ESSearchResponse, err error) {
+	fields := map[string]interface{}{"duration_ms": time.Since(start).Milliseconds()}
+
+	if err != nil {
+		fields["error"] = err
+	} else {
+		fields["hits"] = len(resp.Hits.Hits) + resp.Streamed
+		fields["total"] = resp.Hits.Total
+	}
+
+	c.log.log(event, fields)
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/alissonsales/esexport/client"
+	"github.com/alissonsales/esexport/cursor"
+	"github.com/alissonsales/esexport/debug"
+	"github.com/alissonsales/esexport/features"
+)
+
+func init() {
+	features.Register("strategy", "work-stealing", "Splits the remaining documents of the slowest slice by ranges of -sliceField for the slices done early (-stealWork)")
+}
+
+// checkStealWork returns an error when -stealWork can't be used with the
+// other options or the query
+func checkStealWork(opts *cmdOpts, routed bool, query map[string]interface{}) error {
+	if opts.sliceField == "" || opts.sliceSize < 2 {
+		return errors.New("-stealWork requires -sliceField (a numeric or date field) and a -sliceSize of 2 or more")
+	}
+
+	if routed {
+		return errors.New("-stealWork takes a single -routing value")
+	}
+
+	if _, ok := query["sort"]; ok {
+		return errors.New("-stealWork sorts the scrolls by -sliceField, the query can't have a sort")
+	}
+
+	return nil
+}
+
+// sortedBy returns a copy of the query sorted by the field, in ascending
+// order, which slices need to know how far they went in the field values
+func sortedBy(query map[string]interface{}, field string) map[string]interface{} {
+	q := make(map[string]interface{}, len(query)+1)
+
+	for k, v := range query {
+		q[k] = v
+	}
+
+	q["sort"] = []interface{}{map[string]interface{}{field: "asc"}}
+	return q
+}
+
+// sliceBound is the part of the -sliceField values a slice exports: values
+// from those of its query up to hi (excluded). An unbounded slice exports
+// the documents without the field as well, which sort last.
+type sliceBound struct {
+	mu      sync.Mutex
+	hi      float64
+	bounded bool
+	// last is the highest value kept so far, nothing up to it can be stolen
+	last float64
+	seen bool
+	// dropped is the number of hits retrieved past hi, exported by the slice
+	// the values were stolen by
+	dropped int
+	// stolen estimates the documents of the slice exported by others
+	stolen int
+	// done is set once a hit past hi was seen, the slice has nothing left
+	done bool
+}
+
+// keep returns the hits of the page under the bound, which is reached when
+// some are left out
+func (b *sliceBound) keep(hits []client.Hit) ([]client.Hit, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for i := range hits {
+		// Documents without the field sort last with a value that isn't a
+		// number (or the highest long), so nothing after them is stolen
+		v := math.Inf(1)
+
+		if len(hits[i].Sort) > 0 {
+			if n, ok := hits[i].Sort[0].(float64); ok {
+				v = n
+			}
+		}
+
+		if b.bounded && v >= b.hi {
+			b.dropped += len(hits) - i
+			b.done = true
+			hits = hits[:i]
+			break
+		}
+
+		b.last, b.seen = v, true
+	}
+
+	// The sort values aren't part of the output
+	for i := range hits {
+		hits[i].Sort = nil
+	}
+
+	return hits, b.done
+}
+
+// split lowers the bound of the slice to a value halfway between the last
+// one it kept and its bound (or max), returning the filter of the values
+// given up and the share of the remaining values they represent. It returns
+// a nil filter when there is nothing left to split.
+func (b *sliceBound) split(field string, max float64) (interface{}, *sliceBound, float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	hi := max
+
+	if b.bounded {
+		hi = b.hi
+	}
+
+	if !b.seen || b.done || b.last >= hi || math.IsInf(b.last, 0) {
+		return nil, nil, 0
+	}
+
+	mid := b.last + (hi-b.last)/2
+
+	// Integer fields (and dates, as epoch milliseconds) are split on whole
+	// values, fractional bounds would be rounded by ES
+	if b.last == math.Trunc(b.last) && hi == math.Trunc(hi) {
+		mid = math.Floor(mid)
+	}
+
+	if mid <= b.last {
+		return nil, nil, 0
+	}
+
+	rng := map[string]interface{}{"gte": mid}
+	var filter interface{} = map[string]interface{}{"range": map[string]interface{}{field: rng}}
+
+	if b.bounded {
+		rng["lt"] = b.hi
+	} else {
+		// Documents without the field belong to the unbounded slice
+		filter = map[string]interface{}{"bool": map[string]interface{}{"should": []interface{}{
+			filter,
+			map[string]interface{}{"bool": map[string]interface{}{"must_not": map[string]interface{}{"exists": map[string]interface{}{"field": field}}}},
+		}}}
+	}
+
+	share := (hi - mid) / (hi - b.last)
+	thief := &sliceBound{hi: b.hi, bounded: b.bounded}
+	b.hi, b.bounded = mid, true
+
+	return filter, thief, share
+}
+
+// stealablePart is a slice along with what another slice needs to scroll
+// the part of its values it steals
+type stealablePart struct {
+	s       *slice
+	query   map[string]interface{}
+	sliceID int
+}
+
+// workStealer hands the slices done early part of the documents left to
+// the others: the values of -sliceField left to the slice expected to
+// export the most documents are split in two, the slice keeping the lower
+// half and a new one scrolling the upper half. Every scroll is sorted by
+// the field to know how far it went.
+type workStealer struct {
+	client   *client.Client
+	field    string
+	sliceMax int
+	pageSize int
+	query    map[string]interface{}
+
+	maxOnce sync.Once
+	max     float64
+	hasMax  bool
+
+	mu     sync.Mutex
+	parts  []*stealablePart
+	stolen []*slice
+	nextID int
+}
+
+func newWorkStealer(esClient *client.Client, opts *cmdOpts, query map[string]interface{}) *workStealer {
+	pageSize := 10
+
+	if size, ok := query["size"].(float64); ok && size > 0 {
+		pageSize = int(size)
+	}
+
+	return &workStealer{client: esClient, field: opts.sliceField, sliceMax: opts.sliceSize, pageSize: pageSize, query: query, nextID: opts.sliceSize}
+}
+
+// add makes the documents left to the slice available to others
+func (st *workStealer) add(s *slice, query map[string]interface{}, sliceID int) {
+	s.bound = &sliceBound{}
+
+	st.mu.Lock()
+	st.parts = append(st.parts, &stealablePart{s, query, sliceID})
+	st.mu.Unlock()
+}
+
+// fieldMax returns the highest value of the field among the documents of the
+// query, the upper end of the values of the unbounded slices
+func (st *workStealer) fieldMax() (float64, bool) {
+	st.maxOnce.Do(func() {
+		body := map[string]interface{}{"size": 0, "aggs": map[string]interface{}{"max": map[string]interface{}{"max": map[string]interface{}{"field": st.field}}}}
+
+		if q, ok := st.query["query"]; ok {
+			body["query"] = q
+		}
+
+		resp, err := st.client.Aggregate(body)
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "\nWarning: failed to read the highest %v, slices done early won't help the others: %v\n", st.field, err)
+			return
+		}
+
+		var max struct {
+			Value *float64 `json:"value"`
+		}
+
+		if err := json.Unmarshal(resp.Aggregations["max"], &max); err == nil && max.Value != nil {
+			st.max, st.hasMax = *max.Value, true
+		}
+	})
+
+	return st.max, st.hasMax
+}
+
+// steal returns a new slice exporting part of the documents left to
+// another, requested with esClient and logged to log, or nil when no slice
+// has enough left to be worth splitting
+func (st *workStealer) steal(esClient cursor.ElasticsearchClient, log *sliceLog) (*slice, error) {
+	max, ok := st.fieldMax()
+
+	if !ok {
+		return nil, nil
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	type candidate struct {
+		part      *stealablePart
+		remaining int
+	}
+
+	var candidates []candidate
+
+	for _, p := range st.parts {
+		if remaining := p.s.remaining(); remaining > 2*st.pageSize {
+			candidates = append(candidates, candidate{p, remaining})
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].remaining > candidates[j].remaining })
+
+	for _, c := range candidates {
+		filter, bound, share := c.part.s.bound.split(st.field, max)
+
+		if filter == nil {
+			continue
+		}
+
+		c.part.s.bound.mu.Lock()
+		c.part.s.bound.stolen += int(float64(c.remaining) * share)
+		c.part.s.bound.mu.Unlock()
+
+		query, err := filteredQuery(c.part.query, filter)
+
+		if err != nil {
+			return nil, err
+		}
+
+		ssc, err := cursor.NewSlicedScrollCursor(esClient, c.part.sliceID, st.sliceMax, st.field, query)
+
+		if err != nil {
+			return nil, err
+		}
+
+		victim := c.part.s
+		s := &slice{id: st.nextID, cursor: ssc, log: log, ttl: victim.ttl, tracer: victim.tracer, bound: bound, memory: victim.memory, slow: victim.slow}
+		st.nextID++
+		st.parts = append(st.parts, &stealablePart{s, query, c.part.sliceID})
+		st.stolen = append(st.stolen, s)
+
+		log.log("steal", map[string]interface{}{"from_slice": victim.id, "new_slice": s.id, "share": share})
+		debug.Debug(func() {
+			fmt.Fprintf(os.Stderr, "Slice %v takes about %v documents of slice %v\n", s.id, int(float64(c.remaining)*share), victim.id)
+		})
+
+		return s, nil
+	}
+
+	return nil, nil
+}
+
+// slices returns the slices created by stealing
+func (st *workStealer) slices() []*slice {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	return append([]*slice(nil), st.stolen...)
+}
+
+// progress returns the documents retrieved and expected by the slices and
+// the slices stolen from them. The documents of stolen slices are already
+// part of the total of the slices they were taken from, and the hits
+// retrieved past the bound of a slice are retrieved again by another.
+func (st *workStealer) progress(slices []*slice) (current, total *int) {
+	current, total = processingProgress(slices)
+
+	if current == nil {
+		return nil, nil
+	}
+
+	c := *current
+
+	for _, s := range st.slices() {
+		c += s.retrievedDocs()
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	for _, p := range st.parts {
+		p.s.bound.mu.Lock()
+		c -= p.s.bound.dropped
+		p.s.bound.mu.Unlock()
+	}
+
+	return &c, total
+}
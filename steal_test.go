@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/alissonsales/esexport/client"
+)
+
+func sortedHits(values ...interface{}) []client.Hit {
+	var hits []client.Hit
+
+	for i, v := range values {
+		hit := client.Hit{ID: fmt.Sprint(i)}
+
+		if v != nil {
+			hit.Sort = []interface{}{v}
+		}
+
+		hits = append(hits, hit)
+	}
+
+	return hits
+}
+
+func TestSliceBoundKeep(t *testing.T) {
+	scenarios := []struct {
+		bound   *sliceBound
+		hits    []client.Hit
+		kept    int
+		done    bool
+		dropped int
+		last    float64
+	}{
+		{&sliceBound{hi: 5, bounded: true}, sortedHits(1.0, 3.0, 5.0, 7.0), 2, true, 2, 3},
+		{&sliceBound{hi: 5, bounded: true}, sortedHits(1.0, 2.0), 2, false, 0, 2},
+		// Documents missing the field belong to the unbounded slice only
+		{&sliceBound{}, sortedHits(1.0, 9.0, nil), 3, false, 0, 0},
+		{&sliceBound{hi: 5, bounded: true}, sortedHits(1.0, nil), 1, true, 1, 1},
+	}
+
+	for i, scenario := range scenarios {
+		kept, done := scenario.bound.keep(scenario.hits)
+
+		if len(kept) != scenario.kept || done != scenario.done || scenario.bound.dropped != scenario.dropped {
+			t.Errorf("Scenario %v: expected %v hits kept, done %v and %v dropped, got %v, %v and %v",
+				i, scenario.kept, scenario.done, scenario.dropped, len(kept), done, scenario.bound.dropped)
+		}
+
+		if scenario.bound.bounded && scenario.bound.last != scenario.last {
+			t.Errorf("Scenario %v: expected the last value kept to be %v, got %v", i, scenario.last, scenario.bound.last)
+		}
+
+		for _, hit := range kept {
+			if hit.Sort != nil {
+				t.Errorf("Scenario %v: expected the sort values to be left out, got %v", i, hit.Sort)
+			}
+		}
+	}
+}
+
+func TestSliceBoundSplit(t *testing.T) {
+	scenarios := []struct {
+		bound    *sliceBound
+		max      float64
+		expected string
+		hi       float64
+		share    float64
+	}{
+		{&sliceBound{hi: 21, bounded: true, last: 10, seen: true}, 0,
+			"map[range:map[n:map[gte:15 lt:21]]]", 15, 6.0 / 11},
+		{&sliceBound{hi: 1, bounded: true, last: 0.5, seen: true}, 0,
+			"map[range:map[n:map[gte:0.75 lt:1]]]", 0.75, 0.5},
+		// The unbounded slice hands over the documents missing the field too
+		{&sliceBound{last: 0, seen: true}, 100,
+			"map[bool:map[should:[map[range:map[n:map[gte:50]]] map[bool:map[must_not:map[exists:map[field:n]]]]]]]", 50, 0.5},
+		{&sliceBound{hi: 10, bounded: true, last: 10, seen: true}, 0, "<nil>", 10, 0},
+		{&sliceBound{hi: 10, bounded: true, last: 12, seen: true}, 0, "<nil>", 10, 0},
+		{&sliceBound{hi: 11, bounded: true, last: 10, seen: true}, 0, "<nil>", 11, 0},
+		{&sliceBound{hi: 10, bounded: true}, 0, "<nil>", 10, 0},
+		{&sliceBound{hi: 10, bounded: true, last: 2, seen: true, done: true}, 0, "<nil>", 10, 0},
+	}
+
+	for i, scenario := range scenarios {
+		oldHi, oldBounded := scenario.bound.hi, scenario.bound.bounded
+		filter, thief, share := scenario.bound.split("n", scenario.max)
+
+		if fmt.Sprint(filter) != scenario.expected || share != scenario.share {
+			t.Errorf("Scenario %v: expected filter %v and share %v, got %v and %v", i, scenario.expected, scenario.share, filter, share)
+		}
+
+		if scenario.bound.hi != scenario.hi || (filter != nil && !scenario.bound.bounded) {
+			t.Errorf("Scenario %v: expected the slice to be bounded at %v, got %+v", i, scenario.hi, scenario.bound)
+		}
+
+		if filter == nil {
+			if thief != nil {
+				t.Errorf("Scenario %v: expected no thief when nothing is split, got %+v", i, thief)
+			}
+
+			continue
+		}
+
+		if thief.hi != oldHi || thief.bounded != oldBounded || thief.seen {
+			t.Errorf("Scenario %v: expected the thief to keep the bound %v (bounded %v), got %+v", i, oldHi, oldBounded, thief)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/alissonsales/esexport/features"
+)
+
+func init() {
+	features.Register("output", "es-target", "Bulk indexes the documents into another cluster instead of writing them (-target, -targetIndex)")
+}
+
+// openTarget returns a writer bulk indexing the documents written into
+// -targetIndex of the -target cluster, in batches of -httpBatchSize
+// documents retried like those of http(s):// outputs
+func openTarget(opts *cmdOpts) (*httpOutput, error) {
+	u, err := url.Parse(opts.target)
+
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, errors.New("Invalid -target (expected a URL like http://other-cluster:9200)")
+	}
+
+	if opts.targetIndex == "" {
+		return nil, errors.New("-target requires -targetIndex")
+	}
+
+	if opts.compress != "" || opts.encrypt != "" || opts.checksumSidecars {
+		return nil, errors.New("-compress, -encrypt and -sha256Files can't be used with -target")
+	}
+
+	if err := checkDocumentLines(opts, "-target"); err != nil {
+		return nil, err
+	}
+
+	endpoint := strings.TrimSuffix(u.String(), "/") + "/_bulk?filter_path=errors,items.*.status,items.*.error"
+	h, err := newHTTPOutput(opts, endpoint, targetHeader(opts))
+
+	if err != nil {
+		return nil, err
+	}
+
+	h.line = bulkAction(opts.targetIndex)
+	h.check = checkBulkResponse
+	return h, nil
+}
+
+// targetHeader returns the header authenticating the requests to -target
+func targetHeader(opts *cmdOpts) http.Header {
+	header := http.Header{}
+
+	if opts.targetUser != "" || opts.targetPassword != "" {
+		credentials := base64.StdEncoding.EncodeToString([]byte(opts.targetUser + ":" + opts.targetPassword))
+		header.Set("Authorization", "Basic "+credentials)
+	}
+
+	return header
+}
+
+// bulkAction returns the bulk action and source indexing the document of a
+// line into the index, under the same id
+func bulkAction(index string) func([]byte) ([]byte, error) {
+	return func(line []byte) ([]byte, error) {
+		var doc struct {
+			ID     string          `json:"_id"`
+			Source json.RawMessage `json:"_source"`
+		}
+
+		if err := json.Unmarshal(line, &doc); err != nil {
+			return nil, fmt.Errorf("Failed to index a document into -targetIndex: %v", err)
+		}
+
+		if doc.Source == nil {
+			return nil, fmt.Errorf("Document %v has no _source to index into -targetIndex", doc.ID)
+		}
+
+		action, err := json.Marshal(map[string]interface{}{"index": map[string]string{"_index": index, "_id": doc.ID}})
+
+		if err != nil {
+			return nil, err
+		}
+
+		action = append(action, '\n')
+		action = append(action, doc.Source...)
+		return append(action, '\n'), nil
+	}
+}
+
+// checkBulkResponse returns an error when documents of a bulk request
+// failed, worth retrying when they were all rejected for lack of capacity
+// (429) or a failure of the cluster (5xx). Retries index the documents that
+// succeeded again, which is harmless as they keep their ids.
+func checkBulkResponse(body []byte) (bool, error) {
+	var resp struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			Status int             `json:"status"`
+			Error  json.RawMessage `json:"error"`
+		} `json:"items"`
+	}
+
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return true, fmt.Errorf("Invalid bulk response: %v", err)
+	}
+
+	if !resp.Errors {
+		return false, nil
+	}
+
+	retry, failed := true, 0
+	var first json.RawMessage
+
+	for _, item := range resp.Items {
+		for _, result := range item {
+			if result.Error == nil {
+				continue
+			}
+
+			if result.Status != http.StatusTooManyRequests && result.Status < 500 {
+				retry = false
+			}
+
+			if failed++; first == nil {
+				first = result.Error
+			}
+		}
+	}
+
+	return retry, fmt.Errorf("%v documents failed to be indexed, the first with %s", failed, first)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// runTempDir holds the scratch files of a run, so they don't end up next to
+// the output. It's created on first use under -tempDir, removed when the run
+// succeeds and, with -keepTempOnError, kept for inspection when it fails.
+type runTempDir struct {
+	once        sync.Once
+	base        string
+	keepOnError bool
+	keepAlways  bool
+	path        string
+	err         error
+}
+
+func newRunTempDir(opts *cmdOpts) *runTempDir {
+	return &runTempDir{base: opts.tempDir, keepOnError: opts.keepTempOnError}
+}
+
+// dir returns the path of the directory, creating it if needed
+func (t *runTempDir) dir() (string, error) {
+	t.once.Do(func() {
+		if t.base != "" {
+			if t.err = os.MkdirAll(t.base, 0755); t.err != nil {
+				return
+			}
+		}
+
+		t.path, t.err = ioutil.TempDir(t.base, "esexport-run-")
+	})
+
+	return t.path, t.err
+}
+
+// keep makes cleanup keep the directory, e.g. when it holds files the user
+// asked for
+func (t *runTempDir) keep() {
+	t.keepAlways = true
+}
+
+// cleanup removes the directory (if it was created) unless the run failed
+// and it must be kept
+func (t *runTempDir) cleanup(failed bool) {
+	t.once.Do(func() {})
+
+	if t.path == "" {
+		return
+	}
+
+	if t.keepAlways || (failed && t.keepOnError) {
+		fmt.Fprintln(os.Stderr, "Temporary files kept in", t.path)
+		return
+	}
+
+	if err := os.RemoveAll(t.path); err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to remove temporary files:", err)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// placeholder matches the {{name}} placeholders of query templates
+var placeholder = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+
+// paramEnvPrefix prefixes the environment variables giving the value of a
+// placeholder not set by -param, ESEXPORT_PARAM_FROM for {{from}}
+const paramEnvPrefix = "ESEXPORT_PARAM_"
+
+// renderQuery replaces the {{name}} placeholders of the query with the
+// values of the name=value -param flags or, without one, of the
+// ESEXPORT_PARAM_<NAME> environment variables. Values are inserted as JSON
+// string content, so placeholders of strings go between quotes
+// ("{{from}}") while those of numbers don't. It fails on a placeholder
+// without a value and on a -param the query doesn't use, both likely typos.
+func renderQuery(query string, params []string) (string, error) {
+	values := map[string]string{}
+
+	for _, p := range params {
+		name, value, ok := cut(p, "=")
+
+		if !ok || name == "" {
+			return "", fmt.Errorf("Invalid -param %v (expected name=value)", p)
+		}
+
+		values[name] = value
+	}
+
+	used, missing := map[string]bool{}, map[string]bool{}
+
+	rendered := placeholder.ReplaceAllStringFunc(query, func(match string) string {
+		name := placeholder.FindStringSubmatch(match)[1]
+		value, ok := values[name]
+
+		if ok {
+			used[name] = true
+		} else if value, ok = os.LookupEnv(paramEnvPrefix + strings.ToUpper(name)); !ok {
+			missing[name] = true
+			return match
+		}
+
+		j, _ := json.Marshal(value)
+		return string(j[1 : len(j)-1])
+	})
+
+	if len(missing) > 0 {
+		return "", fmt.Errorf("Missing -param for the query placeholders %v", strings.Join(sortedNames(missing), ", "))
+	}
+
+	unknown := map[string]bool{}
+
+	for name := range values {
+		if !used[name] {
+			unknown[name] = true
+		}
+	}
+
+	if len(unknown) > 0 {
+		return "", fmt.Errorf("Unknown -param %v (not used by the query)", strings.Join(sortedNames(unknown), ", "))
+	}
+
+	return rendered, nil
+}
+
+func sortedNames(set map[string]bool) []string {
+	names := make([]string, 0, len(set))
+
+	for name := range set {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
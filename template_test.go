@@ -0,0 +1,54 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderQuery(t *testing.T) {
+	t.Setenv(paramEnvPrefix+"TENANT", "acme")
+
+	scenarios := []struct {
+		query    string
+		params   []string
+		expected string
+		err      string
+	}{
+		{`{"query":{"term":{"user":"{{user}}"}}}`, []string{"user=kimchy"}, `{"query":{"term":{"user":"kimchy"}}}`, ""},
+		{`{"size":{{ size }},"from":{{size}}}`, []string{"size=10"}, `{"size":10,"from":10}`, ""},
+		{`{"query":{"match_all":{}}}`, nil, `{"query":{"match_all":{}}}`, ""},
+		// Values are JSON escaped, they can't break out of their string
+		{`{"term":{"name":"{{name}}"}}`, []string{`name=a"b\c` + "\n"}, `{"term":{"name":"a\"b\\c\n"}}`, ""},
+		{`{"term":{"name":"{{name}}"}}`, []string{`name=x"},"script":{"source":"1`}, `{"term":{"name":"x\"},\"script\":{\"source\":\"1"}}`, ""},
+		{`{"term":{"q":"{{q}}"}}`, []string{"q=a=b"}, `{"term":{"q":"a=b"}}`, ""},
+		// Without -param, the value comes from the environment
+		{`{"term":{"tenant":"{{tenant}}"}}`, nil, `{"term":{"tenant":"acme"}}`, ""},
+		{`{"term":{"tenant":"{{tenant}}"}}`, []string{"tenant=other"}, `{"term":{"tenant":"other"}}`, ""},
+		{`{"range":{"ts":{"gte":"{{from}}","lt":"{{to}}"}}}`, []string{"from=now-1d"}, "", "Missing -param for the query placeholders to"},
+		{`{"range":{"ts":{"gte":"{{from}}","lt":"{{to}}"}}}`, nil, "", "Missing -param for the query placeholders from, to"},
+		{`{"size":10}`, []string{"size=10"}, "", "Unknown -param size (not used by the query)"},
+		{`{"size":{{size}}}`, []string{"size"}, "", "Invalid -param size (expected name=value)"},
+		{`{"size":{{size}}}`, []string{"=10"}, "", "Invalid -param =10 (expected name=value)"},
+	}
+
+	for _, scenario := range scenarios {
+		rendered, err := renderQuery(scenario.query, scenario.params)
+
+		if scenario.err != "" {
+			if err == nil || !strings.Contains(err.Error(), scenario.err) {
+				t.Errorf("Expected rendering %v with %v to fail with '%v', got '%v' (%v)", scenario.query, scenario.params, scenario.err, rendered, err)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Unexpected error rendering %v with %v: %v", scenario.query, scenario.params, err)
+			continue
+		}
+
+		if rendered != scenario.expected {
+			t.Errorf("Expected %v with %v to render %v, got %v", scenario.query, scenario.params, scenario.expected, rendered)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/alissonsales/esexport/features"
+)
+
+func init() {
+	features.Register("output", "throttled", "Limits the rate output files are written at (-maxWriteBytesPerSec)")
+}
+
+// writeLimiter paces the writes of an output to a number of bytes per
+// second, shared by the slices (and partition files) writing to it
+type writeLimiter struct {
+	mu          sync.Mutex
+	bytesPerSec float64
+	// next is when the bytes written so far are paid for
+	next time.Time
+}
+
+// newWriteLimiter returns a limiter of bytesPerSec, or nil (no limit) when
+// it isn't positive
+func newWriteLimiter(bytesPerSec int64) *writeLimiter {
+	if bytesPerSec <= 0 {
+		return nil
+	}
+
+	return &writeLimiter{bytesPerSec: float64(bytesPerSec)}
+}
+
+// wait blocks until n more bytes can be written
+func (l *writeLimiter) wait(n int) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+
+	if l.next.Before(now) {
+		l.next = now
+	}
+
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(float64(n) / l.bytesPerSec * float64(time.Second)))
+	l.mu.Unlock()
+
+	time.Sleep(delay)
+}
+
+// throttledWriter writes to w at the pace of its limiter
+type throttledWriter struct {
+	w       io.Writer
+	limiter *writeLimiter
+}
+
+func (t *throttledWriter) Write(p []byte) (int, error) {
+	t.limiter.wait(len(p))
+	return t.w.Write(p)
+}
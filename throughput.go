@@ -0,0 +1,52 @@
+package main
+
+import (
+	"time"
+)
+
+// sliceThroughput is how fast a slice exported its documents, in the summary
+type sliceThroughput struct {
+	ID          int           `json:"id"`
+	Docs        int           `json:"docs"`
+	Bytes       int64         `json:"bytes"`
+	Elapsed     time.Duration `json:"elapsed_ns"`
+	DocsPerSec  float64       `json:"docs_per_sec"`
+	BytesPerSec int64         `json:"bytes_per_sec"`
+	AvgLatency  time.Duration `json:"avg_latency_ns"`
+	Retries     int           `json:"retries"`
+}
+
+// addThroughput adds the throughput of the slices to the summary, along with
+// the average latency of their pages and their retries overall
+func addThroughput(summary *summaryData, slices []*slice) {
+	var latency time.Duration
+	pages := 0
+
+	for _, s := range slices {
+		position := s.position()
+		stats := s.stats()
+		elapsed := s.elapsedTime()
+
+		t := sliceThroughput{ID: s.id, Docs: position.Docs, Bytes: position.Bytes, Elapsed: elapsed, AvgLatency: stats.AvgLatency, Retries: stats.Retries}
+		t.DocsPerSec, t.BytesPerSec = rates(t.Docs, t.Bytes, elapsed)
+
+		summary.Slices = append(summary.Slices, t)
+		summary.Bytes += position.Bytes
+		summary.Retries += stats.Retries
+		latency += stats.AvgLatency * time.Duration(stats.Pages)
+		pages += stats.Pages
+	}
+
+	if pages > 0 {
+		summary.AvgLatency = latency / time.Duration(pages)
+	}
+}
+
+// rates returns the documents and bytes per second written in elapsed
+func rates(docs int, bytes int64, elapsed time.Duration) (float64, int64) {
+	if elapsed <= 0 {
+		return 0, 0
+	}
+
+	return float64(docs) / elapsed.Seconds(), int64(float64(bytes) / elapsed.Seconds())
+}
@@ -0,0 +1,123 @@
+//go:build !minimal
+// +build !minimal
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/alissonsales/esexport/features"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func init() {
+	features.Register("observability", "otel", "Sends OpenTelemetry spans of the searches, scrolls and writes to a collector (-otelEndpoint)")
+}
+
+// spanTracer records the spans of an export and sends them to the OTLP
+// collector of -otelEndpoint. A nil spanTracer records nothing.
+type spanTracer struct {
+	provider *sdktrace.TracerProvider
+	tracer   trace.Tracer
+}
+
+func newSpanTracer(opts *cmdOpts) (*spanTracer, error) {
+	if opts.otelEndpoint == "" {
+		return nil, nil
+	}
+
+	u, err := url.Parse(opts.otelEndpoint)
+
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, errors.New("Invalid -otelEndpoint (expected a URL like http://collector:4318)")
+	}
+
+	// Like OTEL_EXPORTER_OTLP_ENDPOINT, a URL without path is the base of
+	// the traces endpoint
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/v1/traces"
+	}
+
+	// The collector is subject to -egressAllow like ES
+	exporter, err := otlptracehttp.New(context.Background(),
+		otlptracehttp.WithEndpointURL(u.String()),
+		otlptracehttp.WithHTTPClient(&http.Client{Transport: egressTransport(opts), Timeout: 10 * time.Second}))
+
+	if err != nil {
+		return nil, err
+	}
+
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		fmt.Fprintln(os.Stderr, "Error sending traces:", err)
+	}))
+
+	res := resource.NewSchemaless(attribute.String("service.name", "esexport"), attribute.String("service.version", version))
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+
+	return &spanTracer{provider: provider, tracer: provider.Tracer("github.com/alissonsales/esexport")}, nil
+}
+
+// start starts a span named esexport.<name>, child of the one in ctx. It's
+// ended by the returned function, with the attributes known by then and the
+// error of the operation.
+func (t *spanTracer) start(ctx context.Context, name string, attrs map[string]interface{}) (context.Context, func(map[string]interface{}, error)) {
+	if t == nil {
+		return ctx, func(map[string]interface{}, error) {}
+	}
+
+	ctx, span := t.tracer.Start(ctx, "esexport."+name, trace.WithAttributes(spanAttributes(attrs)...))
+
+	return ctx, func(attrs map[string]interface{}, err error) {
+		span.SetAttributes(spanAttributes(attrs)...)
+
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+
+		span.End()
+	}
+}
+
+// shutdown sends the spans not sent yet
+func (t *spanTracer) shutdown() {
+	if t == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := t.provider.Shutdown(ctx); err != nil {
+		fmt.Fprintln(os.Stderr, "Error sending traces:", err)
+	}
+}
+
+func spanAttributes(attrs map[string]interface{}) []attribute.KeyValue {
+	kvs := make([]attribute.KeyValue, 0, len(attrs))
+
+	for k, v := range attrs {
+		switch v := v.(type) {
+		case int:
+			kvs = append(kvs, attribute.Int(k, v))
+		case int64:
+			kvs = append(kvs, attribute.Int64(k, v))
+		default:
+			kvs = append(kvs, attribute.String(k, fmt.Sprint(v)))
+		}
+	}
+
+	return kvs
+}
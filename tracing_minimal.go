@@ -0,0 +1,26 @@
+//go:build minimal
+// +build minimal
+
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+// spanTracer records nothing, minimal builds leave OpenTelemetry out
+type spanTracer struct{}
+
+func newSpanTracer(opts *cmdOpts) (*spanTracer, error) {
+	if opts.otelEndpoint != "" {
+		return nil, errors.New("-otelEndpoint isn't available in minimal builds")
+	}
+
+	return nil, nil
+}
+
+func (t *spanTracer) start(ctx context.Context, name string, attrs map[string]interface{}) (context.Context, func(map[string]interface{}, error)) {
+	return ctx, func(map[string]interface{}, error) {}
+}
+
+func (t *spanTracer) shutdown() {}
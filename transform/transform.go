@@ -0,0 +1,323 @@
+// Package transform implements changes applied to the exported documents
+// between the cursors and the output, like renaming or dropping fields
+package transform
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/alissonsales/esexport/client"
+)
+
+// A Transformer changes a document in place
+type Transformer interface {
+	Transform(hit *client.Hit) error
+}
+
+// TransformerFunc adapts a function to the Transformer interface
+type TransformerFunc func(hit *client.Hit) error
+
+// Transform calls f(hit)
+func (f TransformerFunc) Transform(hit *client.Hit) error {
+	return f(hit)
+}
+
+// Pipeline applies its transformers in order
+type Pipeline []Transformer
+
+// Transform applies every transformer of the pipeline to the document
+func (p Pipeline) Transform(hit *client.Hit) error {
+	for _, t := range p {
+		if err := t.Transform(hit); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Rename moves the value of a field to another one. Fields are addressed by
+// their dotted path in _source (e.g. "user.name").
+func Rename(from, to string) Transformer {
+	return TransformerFunc(func(hit *client.Hit) error {
+		value, ok := Get(hit.Source, from)
+
+		if !ok {
+			return nil
+		}
+
+		Delete(hit.Source, from)
+
+		if hit.Source == nil {
+			hit.Source = map[string]interface{}{}
+		}
+
+		Set(hit.Source, to, value)
+		return nil
+	})
+}
+
+// Drop removes a field
+func Drop(field string) Transformer {
+	return TransformerFunc(func(hit *client.Hit) error {
+		Delete(hit.Source, field)
+		return nil
+	})
+}
+
+// Constant sets a field to the given value, overwriting any existing one
+func Constant(field string, value interface{}) Transformer {
+	return TransformerFunc(func(hit *client.Hit) error {
+		if hit.Source == nil {
+			hit.Source = map[string]interface{}{}
+		}
+
+		Set(hit.Source, field, value)
+		return nil
+	})
+}
+
+// Coerce converts the value of a field to the given type: int, float,
+// string or bool. Missing and null fields are left untouched.
+func Coerce(field, typ string) (Transformer, error) {
+	var convert func(interface{}) (interface{}, error)
+
+	switch typ {
+	case "int":
+		convert = toInt
+	case "float":
+		convert = toFloat
+	case "string":
+		convert = toString
+	case "bool":
+		convert = toBool
+	default:
+		return nil, fmt.Errorf("Unknown type %v (expected int, float, string or bool)", typ)
+	}
+
+	return TransformerFunc(func(hit *client.Hit) error {
+		value, ok := Get(hit.Source, field)
+
+		if !ok || value == nil {
+			return nil
+		}
+
+		converted, err := convert(value)
+
+		if err != nil {
+			return fmt.Errorf("Failed to coerce %v of document %v to %v: %v", field, hit.ID, typ, err)
+		}
+
+		Set(hit.Source, field, converted)
+		return nil
+	}), nil
+}
+
+// Hash replaces the value of a field with the hex encoded SHA-256 of the salt
+// followed by the value (converted to a string first). Each element of an
+// array is hashed separately. Missing and null fields are left untouched.
+func Hash(field, salt string) Transformer {
+	hash := func(value interface{}) (interface{}, error) {
+		if value == nil {
+			return nil, nil
+		}
+
+		s, err := toString(value)
+
+		if err != nil {
+			return nil, err
+		}
+
+		sum := sha256.Sum256([]byte(salt + s.(string)))
+		return hex.EncodeToString(sum[:]), nil
+	}
+
+	return TransformerFunc(func(hit *client.Hit) error {
+		value, ok := Get(hit.Source, field)
+
+		if !ok {
+			return nil
+		}
+
+		var hashed interface{}
+		var err error
+
+		if values, isArray := value.([]interface{}); isArray {
+			hashedValues := make([]interface{}, len(values))
+
+			for i, v := range values {
+				if hashedValues[i], err = hash(v); err != nil {
+					break
+				}
+			}
+
+			hashed = hashedValues
+		} else {
+			hashed, err = hash(value)
+		}
+
+		if err != nil {
+			return fmt.Errorf("Failed to hash %v of document %v: %v", field, hit.ID, err)
+		}
+
+		Set(hit.Source, field, hashed)
+		return nil
+	})
+}
+
+// Redact blanks out the value of a field (it's replaced with null), keeping
+// the field itself so consumers relying on it don't break
+func Redact(field string) Transformer {
+	return TransformerFunc(func(hit *client.Hit) error {
+		if _, ok := Get(hit.Source, field); ok {
+			Set(hit.Source, field, nil)
+		}
+
+		return nil
+	})
+}
+
+// ParseValue parses a constant given on the command line: valid JSON values
+// (numbers, booleans, objects...) are decoded, anything else is a string
+func ParseValue(s string) interface{} {
+	var value interface{}
+
+	if err := json.Unmarshal([]byte(s), &value); err != nil {
+		return s
+	}
+
+	return value
+}
+
+// Get returns the value at the dotted path of the document source
+func Get(source map[string]interface{}, path string) (interface{}, bool) {
+	keys := strings.Split(path, ".")
+	current := source
+
+	for i, key := range keys {
+		value, ok := current[key]
+
+		if !ok {
+			return nil, false
+		}
+
+		if i == len(keys)-1 {
+			return value, true
+		}
+
+		if current, ok = value.(map[string]interface{}); !ok {
+			return nil, false
+		}
+	}
+
+	return nil, false
+}
+
+// Set sets the value at the dotted path of the document source, creating
+// the intermediate objects as needed
+func Set(source map[string]interface{}, path string, value interface{}) {
+	keys := strings.Split(path, ".")
+	current := source
+
+	for _, key := range keys[:len(keys)-1] {
+		next, ok := current[key].(map[string]interface{})
+
+		if !ok {
+			next = map[string]interface{}{}
+			current[key] = next
+		}
+
+		current = next
+	}
+
+	current[keys[len(keys)-1]] = value
+}
+
+// Delete removes the value at the dotted path of the document source
+func Delete(source map[string]interface{}, path string) {
+	keys := strings.Split(path, ".")
+	current := source
+
+	for _, key := range keys[:len(keys)-1] {
+		next, ok := current[key].(map[string]interface{})
+
+		if !ok {
+			return
+		}
+
+		current = next
+	}
+
+	delete(current, keys[len(keys)-1])
+}
+
+func toInt(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case float64:
+		if v != float64(int64(v)) {
+			return nil, fmt.Errorf("%v is not an integer", v)
+		}
+
+		return int64(v), nil
+	case int64, int:
+		return v, nil
+	case string:
+		return strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+	case bool:
+		if v {
+			return int64(1), nil
+		}
+
+		return int64(0), nil
+	}
+
+	return nil, fmt.Errorf("can't convert %T", value)
+}
+
+func toFloat(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case int64:
+		return float64(v), nil
+	case int:
+		return float64(v), nil
+	case string:
+		return strconv.ParseFloat(strings.TrimSpace(v), 64)
+	}
+
+	return nil, fmt.Errorf("can't convert %T", value)
+}
+
+func toString(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case int64, int, bool:
+		return fmt.Sprint(v), nil
+	}
+
+	j, err := json.Marshal(value)
+	return string(j), err
+}
+
+func toBool(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case bool:
+		return v, nil
+	case float64:
+		return v != 0, nil
+	case int64:
+		return v != 0, nil
+	case string:
+		return strconv.ParseBool(strings.TrimSpace(v))
+	}
+
+	return nil, fmt.Errorf("can't convert %T", value)
+}
@@ -0,0 +1,123 @@
+package transform
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/alissonsales/esexport/client"
+)
+
+func newHit(t *testing.T, source string) *client.Hit {
+	hit := &client.Hit{ID: "id"}
+
+	if err := json.Unmarshal([]byte(source), &hit.Source); err != nil {
+		t.Fatalf("Failed to parse source: %v", err)
+	}
+
+	return hit
+}
+
+func TestPipeline(t *testing.T) {
+	coerceAge, _ := Coerce("age", "int")
+	coerceZip, _ := Coerce("address.zip", "string")
+	coerceActive, _ := Coerce("active", "bool")
+
+	scenarios := []struct {
+		transformer    Transformer
+		source         string
+		expectedSource string
+	}{
+		{Rename("name", "full_name"), `{"name":"a"}`, `{"full_name":"a"}`},
+		{Rename("user.name", "name"), `{"user":{"name":"a","id":1}}`, `{"name":"a","user":{"id":1}}`},
+		{Rename("name", "user.name"), `{"name":"a"}`, `{"user":{"name":"a"}}`},
+		{Rename("missing", "other"), `{"name":"a"}`, `{"name":"a"}`},
+		{Drop("name"), `{"name":"a","id":1}`, `{"id":1}`},
+		{Drop("user.name"), `{"user":{"name":"a","id":1}}`, `{"user":{"id":1}}`},
+		{Drop("user.name"), `{"user":"a"}`, `{"user":"a"}`},
+		{Constant("source", "es"), `{"id":1}`, `{"id":1,"source":"es"}`},
+		{Constant("meta.version", ParseValue("2")), `{"meta":{"a":1}}`, `{"meta":{"a":1,"version":2}}`},
+		{coerceAge, `{"age":"42"}`, `{"age":42}`},
+		{coerceAge, `{"age":null}`, `{"age":null}`},
+		{coerceZip, `{"address":{"zip":1234}}`, `{"address":{"zip":"1234"}}`},
+		{coerceActive, `{"active":"true"}`, `{"active":true}`},
+		{Pipeline{Rename("a", "b"), Drop("c"), Constant("d", true)}, `{"a":1,"c":2}`, `{"b":1,"d":true}`},
+	}
+
+	for _, scenario := range scenarios {
+		hit := newHit(t, scenario.source)
+
+		if err := scenario.transformer.Transform(hit); err != nil {
+			t.Errorf("Unexpected error transforming %v: %v", scenario.source, err)
+		}
+
+		source, _ := json.Marshal(hit.Source)
+
+		if string(source) != scenario.expectedSource {
+			t.Errorf("Expected source to be '%v', got '%s'", scenario.expectedSource, source)
+		}
+	}
+}
+
+func TestAnonymization(t *testing.T) {
+	scenarios := []struct {
+		transformer    Transformer
+		source         string
+		expectedSource string
+	}{
+		// sha256("a@b.com")
+		{Hash("email", ""), `{"email":"a@b.com"}`, `{"email":"fb98d44ad7501a959f3f4f4a3f004fe2d9e581ea6207e218c4b02c08a4d75adf"}`},
+		// sha256("salt" + "42")
+		{Hash("user.id", "salt"), `{"user":{"id":42}}`, `{"user":{"id":"ba5bf48c9d94fef61432ae21b346d1307be9476c9c8e98d111366abbb69d45cd"}}`},
+		{Hash("tags", ""), `{"tags":["a",null]}`, `{"tags":["ca978112ca1bbdcafac231b39a23dc4da786eff8147c4e72b9807785afee48bb",null]}`},
+		{Hash("missing", ""), `{"email":"a@b.com"}`, `{"email":"a@b.com"}`},
+		{Redact("ssn"), `{"ssn":"123","name":"a"}`, `{"name":"a","ssn":null}`},
+		{Redact("user.ssn"), `{"user":{"name":"a"}}`, `{"user":{"name":"a"}}`},
+	}
+
+	for _, scenario := range scenarios {
+		hit := newHit(t, scenario.source)
+
+		if err := scenario.transformer.Transform(hit); err != nil {
+			t.Errorf("Unexpected error transforming %v: %v", scenario.source, err)
+		}
+
+		source, _ := json.Marshal(hit.Source)
+
+		if string(source) != scenario.expectedSource {
+			t.Errorf("Expected source to be '%v', got '%s'", scenario.expectedSource, source)
+		}
+	}
+}
+
+func TestCoerceErrors(t *testing.T) {
+	if _, err := Coerce("field", "date"); err == nil || err.Error() != "Unknown type date (expected int, float, string or bool)" {
+		t.Errorf("Unexpected error for unknown type: %v", err)
+	}
+
+	coerce, _ := Coerce("age", "int")
+	err := coerce.Transform(newHit(t, `{"age":1.5}`))
+
+	if err == nil || err.Error() != "Failed to coerce age of document id to int: 1.5 is not an integer" {
+		t.Errorf("Unexpected error coercing a float to int: %v", err)
+	}
+}
+
+func TestParseValue(t *testing.T) {
+	scenarios := []struct {
+		value    string
+		expected string
+	}{
+		{"text", `"text"`},
+		{"12", `12`},
+		{"true", `true`},
+		{`{"a":1}`, `{"a":1}`},
+	}
+
+	for _, scenario := range scenarios {
+		j, _ := json.Marshal(ParseValue(scenario.value))
+
+		if string(j) != scenario.expected {
+			t.Errorf("Expected %v to be parsed as %v, got %s", scenario.value, scenario.expected, j)
+		}
+	}
+}
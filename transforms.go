@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/alissonsales/esexport/features"
+	"github.com/alissonsales/esexport/transform"
+)
+
+func init() {
+	features.Register("transform", "rename", "Renames document fields (-rename)")
+	features.Register("transform", "drop", "Removes document fields (-drop)")
+	features.Register("transform", "set", "Sets document fields to constants (-set)")
+	features.Register("transform", "coerce", "Converts document fields to another type (-coerce)")
+	features.Register("transform", "hash", "Replaces document fields by their SHA-256 (-hashFields)")
+	features.Register("transform", "redact", "Blanks out document fields (-redactFields)")
+}
+
+// stringList is a flag that can be repeated, accumulating its values. In the
+// config file it's written as a YAML sequence.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// newTransformPipeline builds the transformations applied to every document,
+// in a fixed order: renames, drops, constants, coercions, hashes and redactions
+func newTransformPipeline(opts *cmdOpts) (transform.Pipeline, error) {
+	var pipeline transform.Pipeline
+
+	for _, r := range opts.rename {
+		from, to, ok := cut(r, ":")
+
+		if !ok || from == "" || to == "" {
+			return nil, fmt.Errorf("Invalid -rename %v (expected from:to)", r)
+		}
+
+		pipeline = append(pipeline, transform.Rename(from, to))
+	}
+
+	for _, field := range opts.drop {
+		pipeline = append(pipeline, transform.Drop(field))
+	}
+
+	for _, s := range opts.set {
+		field, value, ok := cut(s, "=")
+
+		if !ok || field == "" {
+			return nil, fmt.Errorf("Invalid -set %v (expected field=value)", s)
+		}
+
+		pipeline = append(pipeline, transform.Constant(field, transform.ParseValue(value)))
+	}
+
+	for _, c := range opts.coerce {
+		field, typ, ok := cut(c, ":")
+
+		if !ok || field == "" {
+			return nil, fmt.Errorf("Invalid -coerce %v (expected field:type)", c)
+		}
+
+		t, err := transform.Coerce(field, typ)
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid -coerce %v: %v", c, err)
+		}
+
+		pipeline = append(pipeline, t)
+	}
+
+	for _, field := range splitList(opts.hashFields) {
+		pipeline = append(pipeline, transform.Hash(field, opts.hashSalt))
+	}
+
+	for _, field := range splitList(opts.redactFields) {
+		pipeline = append(pipeline, transform.Redact(field))
+	}
+
+	return pipeline, nil
+}
+
+// cut slices s around the first instance of sep
+func cut(s, sep string) (before, after string, found bool) {
+	if i := strings.Index(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+
+	return s, "", false
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/alissonsales/esexport/debug"
+	"github.com/alissonsales/esexport/features"
+)
+
+func init() {
+	features.Register("auth", "basic", "Authenticates on ES with a username and password")
+	features.Register("transport", "gzip", "Requests gzip compressed responses from ES")
+	features.Register("transport", "proxy", "Connects to ES through an HTTP(S) proxy (-proxy or HTTP_PROXY/HTTPS_PROXY/NO_PROXY)")
+}
+
+// transportSettings are the options a transport depends on
+type transportSettings struct {
+	connectTimeout time.Duration
+	keepAlive      time.Duration
+	noKeepAlives   bool
+	compression    bool
+	maxIdleConns   int
+	workers        int
+	egressAllow    string
+	proxy          string
+	user           string
+	password       string
+	debugDump      string
+	debugDumpBytes int64
+}
+
+// transports holds the transports created so far by their settings, so the
+// exports of a batch sharing them reuse their connections to ES
+var transports = struct {
+	sync.Mutex
+	m map[transportSettings]http.RoundTripper
+}{m: map[transportSettings]http.RoundTripper{}}
+
+// sharedTransport returns the transport for the options, created by
+// newTransport the first time they are seen
+func sharedTransport(opts *cmdOpts) (http.RoundTripper, error) {
+	settings := transportSettings{opts.connectTimeout, opts.keepAlive, opts.noKeepAlives, opts.compression, opts.maxIdleConns,
+		workerCount(opts), opts.egressAllow, opts.proxy, opts.user, opts.password, opts.debugDump, opts.debugDumpBytes}
+
+	transports.Lock()
+	defer transports.Unlock()
+
+	if t, ok := transports.m[settings]; ok {
+		return t, nil
+	}
+
+	t, err := newTransport(opts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	transports.m[settings] = t
+	return t, nil
+}
+
+// newTransport returns the transport used to talk to ES, tuned by the
+// connection flags. It starts from http.DefaultTransport so proxy settings
+// from the environment keep being honored, unless -proxy is given.
+func newTransport(opts *cmdOpts) (http.RoundTripper, error) {
+	t := dialingTransport(opts)
+	t.DisableKeepAlives = opts.noKeepAlives
+	// The transport sends "Accept-Encoding: gzip" and transparently
+	// decompresses the responses unless compression is disabled
+	t.DisableCompression = !opts.compression
+	t.MaxIdleConnsPerHost = opts.maxIdleConns
+
+	if opts.proxy != "" {
+		u, err := url.Parse(opts.proxy)
+
+		if err != nil || u.Host == "" {
+			// The value isn't printed as it may hold credentials
+			return nil, errors.New("Invalid -proxy (expected a URL like http://proxy:3128)")
+		}
+
+		t.Proxy = http.ProxyURL(u)
+	}
+
+	if t.MaxIdleConnsPerHost <= 0 {
+		t.MaxIdleConnsPerHost = workerCount(opts)
+	}
+
+	if t.MaxIdleConns < t.MaxIdleConnsPerHost {
+		t.MaxIdleConns = t.MaxIdleConnsPerHost
+	}
+
+	// Requests through a proxy are checked against -egressAllow as well
+	rt := egressAllowlist(splitList(opts.egressAllow)).transport(t)
+
+	// Below the credentials, which aren't written
+	if opts.debugDump != "" {
+		d, err := newDumpTransport(opts.debugDump, opts.debugDumpBytes, rt)
+
+		if err != nil {
+			return nil, err
+		}
+
+		rt = d
+	}
+
+	if opts.compression {
+		rt = &compressionCheckTransport{next: rt}
+	}
+
+	if opts.user != "" || opts.password != "" {
+		rt = &basicAuthTransport{opts.user, opts.password, rt}
+	}
+
+	return rt, nil
+}
+
+// compressionCheckTransport reports (once, when debugging) responses ES sent
+// uncompressed although gzip was accepted, which happens when
+// http.compression is disabled on the cluster
+type compressionCheckTransport struct {
+	once sync.Once
+	next http.RoundTripper
+}
+
+func (t *compressionCheckTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+
+	if err == nil && !resp.Uncompressed && resp.Header.Get("Content-Encoding") == "" {
+		t.once.Do(func() {
+			debug.Debug(func() {
+				fmt.Fprintf(os.Stderr, "ES responses are not compressed, consider enabling http.compression on %v\n", req.URL.Host)
+			})
+		})
+	}
+
+	return resp, err
+}
+
+// basicAuthTransport adds basic auth credentials to every request
+type basicAuthTransport struct {
+	user     string
+	password string
+	next     http.RoundTripper
+}
+
+func (t *basicAuthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r := req.Clone(req.Context())
+	r.SetBasicAuth(t.user, t.password)
+	return t.next.RoundTrip(r)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/alissonsales/esexport/client"
+)
+
+// countCheck compares the documents exported with those matching the query,
+// counted again once the slices are done. Documents added or deleted during
+// the export make them differ as well.
+type countCheck struct {
+	// Count is the number of documents matching the query after the export
+	Count int64 `json:"count"`
+	// Exported is the number of documents written, to the output or
+	// -deadLetter
+	Exported int `json:"exported"`
+	// Slices are the slices which retrieved another number of documents
+	// than their search reported
+	Slices []sliceCount `json:"slices,omitempty"`
+}
+
+type sliceCount struct {
+	ID        int `json:"id"`
+	Expected  int `json:"expected"`
+	Retrieved int `json:"retrieved"`
+}
+
+// countSkipReason tells why the documents exported can't be compared with
+// the count of the query, if they can't
+func countSkipReason(opts *cmdOpts) string {
+	switch {
+	case opts.maxDocs > 0:
+		return "-maxDocs"
+	case opts.sample != 0:
+		return "-sample"
+	case opts.idsFile != "":
+		return "-idsFile"
+	case opts.follow:
+		return "-follow"
+	case opts.resumeScroll != "":
+		return "-resumeScroll"
+	}
+
+	return ""
+}
+
+// checkCount counts the documents of the query again to compare them with
+// the documents exported. Slices are compared with the total of their
+// search, unless they share their documents with -stealWork.
+func checkCount(esClient *client.Client, opts *cmdOpts, query map[string]interface{}, slices []*slice, rejected int) (*countCheck, error) {
+	count, err := esClient.Count(query)
+
+	if err != nil {
+		return nil, err
+	}
+
+	c := &countCheck{Count: count, Exported: rejected}
+
+	for _, s := range slices {
+		position := s.position()
+		c.Exported += position.Docs
+
+		if opts.stealWork {
+			continue
+		}
+
+		if retrieved := s.retrievedDocs(); retrieved != position.Expected {
+			c.Slices = append(c.Slices, sliceCount{s.id, position.Expected, retrieved})
+		}
+	}
+
+	return c, nil
+}
+
+// mismatches describes the differences found
+func (c *countCheck) mismatches() []string {
+	var mismatches []string
+
+	if int64(c.Exported) != c.Count {
+		mismatches = append(mismatches, fmt.Sprintf("%v documents exported but %v match the query", c.Exported, c.Count))
+	}
+
+	for _, s := range c.Slices {
+		mismatches = append(mismatches, fmt.Sprintf("slice %v retrieved %v documents but its search reported %v", s.ID, s.Retrieved, s.Expected))
+	}
+
+	return mismatches
+}
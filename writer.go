@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+
+	"github.com/alissonsales/esexport/client"
+	"github.com/alissonsales/esexport/features"
+	"github.com/alissonsales/esexport/transform"
+)
+
+func init() {
+	features.Register("format", "ndjson", "One JSON document per line")
+	features.Register("format", "ids", "One document _id per line (-idsOnly)")
+	features.Register("transform", "command", "Pipes batches of documents through a shell command (-transformCmd)")
+}
+
+// hitWriter turns the batches of hits returned by the cursors into output:
+// it applies the transformations and hands the hits to the sink, optionally
+// serialized through an external command. Hits failing on their own go to
+// deadLetters when set, instead of failing the batch.
+type hitWriter struct {
+	transforms  transform.Pipeline
+	command     string
+	sink        Sink
+	tempDir     *runTempDir
+	deadLetters *deadLetterFile
+	quality     *qualityGate
+	// watermark tracks the latest timestamp exported when following
+	watermark *watermark
+	// limit stops the slices after -maxDocs documents, if set
+	limit *docLimit
+}
+
+// outputWriteError is a failure to write a batch to the output, which the
+// slices share: the first one stops the export
+type outputWriteError struct {
+	err error
+}
+
+func (e *outputWriteError) Error() string {
+	return e.err.Error()
+}
+
+func (e *outputWriteError) Unwrap() error {
+	return e.err
+}
+
+// write writes the batch, returning the number of hits and bytes written
+// (unknown to sinks other than files)
+func (w *hitWriter) write(ctx context.Context, hits []client.Hit) (int, int, error) {
+	if hits = w.limit.take(hits); len(hits) == 0 {
+		return 0, 0, nil
+	}
+
+	if w.watermark != nil {
+		if hits = w.watermark.observe(hits); len(hits) == 0 {
+			return 0, 0, nil
+		}
+	}
+
+	valid := hits[:0]
+
+	for i := range hits {
+		if err := w.transforms.Transform(&hits[i]); err != nil {
+			if err := w.reject(&hits[i], "transform", err); err != nil {
+				return 0, 0, err
+			}
+
+			continue
+		}
+
+		valid = append(valid, hits[i])
+	}
+
+	written, n := valid, 0
+	var err error
+
+	if f, ok := w.sink.(*fileSink); !ok {
+		err = w.sink.WriteHits(ctx, valid)
+	} else if w.command != "" {
+		n, err = w.writeThroughCommand(ctx, f, valid)
+	} else {
+		written, n, err = f.write(ctx, valid)
+	}
+
+	if err != nil {
+		return 0, n, err
+	}
+
+	for i := range written {
+		w.observe(&written[i])
+	}
+
+	return len(written), n, nil
+}
+
+// observe counts the written hit in the quality checks
+func (w *hitWriter) observe(hit *client.Hit) {
+	if w.quality != nil {
+		w.quality.observe(hit)
+	}
+}
+
+// reject sends the hit to the dead letters, or returns err when there are
+// none so the batch fails
+func (w *hitWriter) reject(hit *client.Hit, stage string, err error) error {
+	if w.deadLetters == nil {
+		return err
+	}
+
+	return w.deadLetters.add(hit, stage, err)
+}
+
+// writeThroughCommand pipes the batch into the command and writes what it
+// prints to stdout in a single write, so the output of concurrent batches
+// doesn't interleave
+func (w *hitWriter) writeThroughCommand(ctx context.Context, f *fileSink, hits []client.Hit) (int, error) {
+	var input bytes.Buffer
+
+	for i := range hits {
+		line, err := f.formatter.Format(&hits[i])
+
+		if err != nil {
+			return 0, err
+		}
+
+		input.Write(line)
+	}
+
+	var output bytes.Buffer
+	cmd := shellCommand(w.command)
+	cmd.Stdin = &input
+	cmd.Stdout = &output
+	cmd.Stderr = os.Stderr
+
+	// Scratch files of the command (e.g. sort) go to the run temp directory
+	if dir, err := w.tempDir.dir(); err == nil {
+		cmd.Env = append(os.Environ(), "TMPDIR="+dir, "TMP="+dir, "TEMP="+dir)
+	}
+
+	if err := cmd.Run(); err != nil {
+		return 0, fmt.Errorf("Transform command failed: %v", err)
+	}
+
+	return f.output.write(ctx, output.Bytes())
+}
+
+func shellCommand(command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/C", command)
+	}
+
+	return exec.Command("sh", "-c", command)
+}